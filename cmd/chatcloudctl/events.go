@@ -0,0 +1,117 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+	"github.com/neonxp/chatcloud/pkg/token"
+)
+
+// mintToken issues access token signed with instance secret, useful for debugging clients
+func mintToken(e *env, args []string) error {
+	fs := flag.NewFlagSet("mint", flag.ContinueOnError)
+	user := fs.String("user", "", "token subject")
+	su := fs.Bool("su", false, "issue server token allowed to act on behalf of any user")
+	ttl := fs.Duration("ttl", time.Hour, "token lifetime")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *user == "" && !*su {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	raw, expiresAt, err := token.Issue(t.Instance, *user, *su, *ttl)
+	if err != nil {
+		return err
+	}
+	return e.print(map[string]interface{}{
+		"access_token": raw,
+		"token_type":   "bearer",
+		"expires_at":   expiresAt,
+	})
+}
+
+// tailEvents prints live events of room or user channel as JSON lines until interrupted
+func tailEvents(e *env, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	room := fs.String("room", "", "room id")
+	user := fs.String("user", "", "user id")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if (*room == "") == (*user == "") {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	channel := hub.UserChannel(t.Instance.ID, *user)
+	if *room != "" {
+		if _, err := findRoom(t, *room); err != nil {
+			return err
+		}
+		channel = hub.RoomChannel(t.Instance.ID, *room)
+	}
+	sub, err := hub.New(e.redis()).Subscribe("cli", channel)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	fmt.Fprintf(os.Stderr, "tailing %s, press Ctrl+C to stop\n", channel)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	for {
+		select {
+		case <-interrupt:
+			return nil
+		case event, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			if err := e.print(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publisher sends events about changes made by CLI to connected clients. Change is already stored, so failure is only reported
+func (e *env) publisher() tenant.Publisher {
+	h := hub.New(e.redis())
+	return func(channel string, name string, data interface{}) {
+		if err := h.Publish(channel, name, data); err != nil {
+			fmt.Fprintf(os.Stderr, "can't publish %s event: %v\n", name, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/neonxp/chatcloud/pkg/chatkit"
+)
+
+// importChatkit loads Chatkit export directory, running it again resumes interrupted import
+func importChatkit(e *env, args []string) error {
+	fs := flag.NewFlagSet("chatkit", flag.ContinueOnError)
+	dir := fs.String("dir", "", "directory with export files: "+chatkit.FileUsers+", "+chatkit.FileRooms+", "+chatkit.FileMessages+"...")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	if err := t.EnsureIndexes(); err != nil {
+		return err
+	}
+	stats, err := chatkit.NewImporter(t, *dir, func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}).Run()
+	if printErr := e.print(stats); printErr != nil {
+		return printErr
+	}
+	return err
+}
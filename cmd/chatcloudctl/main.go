@@ -0,0 +1,235 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Command chatcloudctl operates instances directly through the storage layer:
+// manages users, rooms, memberships and roles, mints tokens, tails events, imports Chatkit exports
+// applies retention policies and runs maintenance tasks.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	goredis "github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/config"
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/redis"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+// errUsage makes command print its usage and exit with code 2
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	usage string
+	run   func(e *env, args []string) error
+}
+
+var commands = map[string]map[string]command{
+	"users":       userCommands,
+	"rooms":       roomCommands,
+	"members":     memberCommands,
+	"roles":       roleCommands,
+	"token":       {"mint": {"-user ID [-su] [-ttl 1h]", mintToken}},
+	"events":      {"tail": {"(-room ID | -user ID)", tailEvents}},
+	"maintenance": maintenanceCommands,
+	"import":      {"chatkit": {"-dir DIR", importChatkit}},
+	"retention":   retentionCommands,
+}
+
+// env holds connections shared by commands, opened lazily
+type env struct {
+	cfg        *config.Config
+	instanceID string
+	out        io.Writer
+	database   *mongo.Database
+	rds        *goredis.Client
+	tenants    *tenant.Registry
+}
+
+func main() {
+	fs := flag.NewFlagSet("chatcloudctl", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to YAML or TOML config file of the server")
+	instanceID := fs.String("instance", os.Getenv("CHATCLOUD_INSTANCE"), "instance to operate on")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		os.Exit(2)
+	}
+	args := fs.Args()
+	if len(args) < 2 {
+		usage(fs)
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		usage(fs)
+		os.Exit(2)
+	}
+	var cfgArgs []string
+	if *configFile != "" {
+		cfgArgs = []string{"-config", *configFile}
+	}
+	cfg, err := config.Load(cfgArgs)
+	if err != nil {
+		fail(err)
+	}
+	e := &env{cfg: cfg, instanceID: *instanceID, out: os.Stdout}
+	defer e.close()
+	if err := cmd.run(e, args[2:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "usage: chatcloudctl %s %s %s\n", args[0], args[1], cmd.usage)
+			os.Exit(2)
+		}
+		e.close()
+		fail(err)
+	}
+}
+
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "usage: chatcloudctl [-config file] [-instance id] <command> <subcommand> [args]")
+	fs.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "commands:")
+	groups := make([]string, 0, len(commands))
+	for g := range commands {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		subs := make([]string, 0, len(commands[g]))
+		for s := range commands[g] {
+			subs = append(subs, s)
+		}
+		sort.Strings(subs)
+		for _, s := range subs {
+			fmt.Fprintln(os.Stderr, strings.TrimRight(fmt.Sprintf("  %s %s %s", g, s, commands[g][s].usage), " "))
+		}
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+func (e *env) registry() (*tenant.Registry, error) {
+	if e.tenants != nil {
+		return e.tenants, nil
+	}
+	database, err := db.New(e.cfg.MongoConnection, e.cfg.MongoName)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to mongo: %w", err)
+	}
+	e.database = database
+	e.tenants, err = tenant.NewRegistry(database, e.redis(), e.cfg.Isolation)
+	return e.tenants, err
+}
+
+func (e *env) redis() *goredis.Client {
+	if e.rds == nil {
+		e.rds = redis.New(e.cfg)
+	}
+	return e.rds
+}
+
+// tenant returns instance selected with -instance flag
+func (e *env) tenant() (*tenant.Tenant, error) {
+	if e.instanceID == "" {
+		return nil, errors.New("instance is not set, use -instance flag or CHATCLOUD_INSTANCE env")
+	}
+	tenants, err := e.registry()
+	if err != nil {
+		return nil, err
+	}
+	t, err := tenants.Get(e.instanceID)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("instance %s not found", e.instanceID)
+	}
+	return t, err
+}
+
+func (e *env) close() {
+	if e.database != nil {
+		_ = e.database.Client().Disconnect(context.Background())
+		e.database = nil
+	}
+	if e.rds != nil {
+		_ = e.rds.Close()
+		e.rds = nil
+	}
+}
+
+// print writes v as indented JSON
+func (e *env) print(v interface{}) error {
+	enc := json.NewEncoder(e.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// parse parses subcommand flags and checks count of positional arguments
+func parse(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	fs.SetOutput(ioutil.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if positional >= 0 && fs.NArg() != positional {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// list splits comma separated flag value
+func list(v string) []string {
+	if v == "" {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// customData decodes JSON flag value
+func customData(v string) (interface{}, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(v), &data); err != nil {
+		return nil, fmt.Errorf("custom data: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,230 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+var maintenanceCommands = map[string]command{
+	"indexes": {"[-all]", ensureIndexes},
+	"check":   {"[-all] [-fix]", checkData},
+}
+
+// forEachTenant runs fn for selected instance or for every instance when all is set
+func forEachTenant(e *env, all bool, fn func(t *tenant.Tenant) error) error {
+	if !all {
+		t, err := e.tenant()
+		if err != nil {
+			return err
+		}
+		return fn(t)
+	}
+	tenants, err := e.registry()
+	if err != nil {
+		return err
+	}
+	instances, err := tenants.List()
+	if err != nil {
+		return err
+	}
+	for _, i := range instances {
+		t, err := tenants.Get(i.ID)
+		if err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return fmt.Errorf("instance %s: %w", i.ID, err)
+		}
+	}
+	return nil
+}
+
+func ensureIndexes(e *env, args []string) error {
+	fs := flag.NewFlagSet("indexes", flag.ContinueOnError)
+	all := fs.Bool("all", false, "process every instance")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	return forEachTenant(e, *all, func(t *tenant.Tenant) error {
+		if err := t.EnsureIndexes(); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.out, "%s: indexes are up to date\n", t.Instance.ID)
+		return nil
+	})
+}
+
+// checkReport lists references to missing documents
+type checkReport struct {
+	Instance           string   `json:"instance"`
+	MissingMembers     []string `json:"missing_members"`
+	OrphanMessageRooms []string `json:"orphan_message_rooms"`
+	OrphanRoleUsers    []string `json:"orphan_role_users"`
+	OrphanRoleRooms    []string `json:"orphan_role_rooms"`
+	Fixed              bool     `json:"fixed"`
+	// HeldMessages counts messages of orphan rooms kept by fix because their authors are under legal hold
+	HeldMessages int64 `json:"held_messages,omitempty"`
+}
+
+func checkData(e *env, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	all := fs.Bool("all", false, "process every instance")
+	fix := fs.Bool("fix", false, "remove dangling references")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	return forEachTenant(e, *all, func(t *tenant.Tenant) error {
+		report, err := check(t)
+		if err != nil {
+			return err
+		}
+		if *fix {
+			if err := repair(t, report); err != nil {
+				return err
+			}
+			report.Fixed = true
+		}
+		return e.print(report)
+	})
+}
+
+func check(t *tenant.Tenant) (*checkReport, error) {
+	report := &checkReport{Instance: t.Instance.ID}
+
+	memberIDs, err := t.Rooms.MemberIDs()
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := t.Roles.Assignments()
+	if err != nil {
+		return nil, err
+	}
+	userIDs := memberIDs
+	roomIDs := map[string]bool{}
+	for _, a := range assignments {
+		userIDs = append(userIDs, a.UserID)
+		if a.RoomID != "" {
+			roomIDs[a.RoomID] = true
+		}
+	}
+	users, err := t.Users.FindByIDs(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	existingUsers := map[string]bool{}
+	for _, u := range users {
+		existingUsers[u.ID] = true
+	}
+	for _, id := range memberIDs {
+		if !existingUsers[id] {
+			report.MissingMembers = append(report.MissingMembers, id)
+		}
+	}
+
+	messageRooms, err := t.Messages.RoomIDs()
+	if err != nil {
+		return nil, err
+	}
+	rooms := append([]primitive.ObjectID{}, messageRooms...)
+	for id := range roomIDs {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			rooms = append(rooms, oid)
+		}
+	}
+	found, err := t.Rooms.FindByIDs(rooms)
+	if err != nil {
+		return nil, err
+	}
+	existingRooms := map[string]bool{}
+	for _, r := range found {
+		existingRooms[r.ID.Hex()] = true
+	}
+	for _, id := range messageRooms {
+		if !existingRooms[id.Hex()] {
+			report.OrphanMessageRooms = append(report.OrphanMessageRooms, id.Hex())
+		}
+	}
+	seen := map[string]bool{}
+	for _, a := range assignments {
+		if !existingUsers[a.UserID] && !seen[a.UserID] {
+			seen[a.UserID] = true
+			report.OrphanRoleUsers = append(report.OrphanRoleUsers, a.UserID)
+		}
+	}
+	for id := range roomIDs {
+		if !existingRooms[id] {
+			report.OrphanRoleRooms = append(report.OrphanRoleRooms, id)
+		}
+	}
+	return report, nil
+}
+
+func repair(t *tenant.Tenant, report *checkReport) error {
+	for _, id := range report.MissingMembers {
+		if err := t.Rooms.RemoveUserEverywhere(id); err != nil {
+			return err
+		}
+	}
+	if len(report.OrphanMessageRooms) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(report.OrphanMessageRooms))
+		for _, id := range report.OrphanMessageRooms {
+			oid, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, oid)
+		}
+		heldIDs, err := t.Users.HeldIDs()
+		if err != nil {
+			return err
+		}
+		if _, err := t.Messages.RemoveByRooms(ids, heldIDs); err != nil {
+			return err
+		}
+		if len(heldIDs) > 0 {
+			for _, id := range ids {
+				n, err := t.Messages.CountByUsers(id, heldIDs)
+				if err != nil {
+					return err
+				}
+				report.HeldMessages += n
+			}
+		}
+	}
+	for _, id := range report.OrphanRoleUsers {
+		if err := t.Roles.UnassignAll(id); err != nil {
+			return err
+		}
+	}
+	if len(report.OrphanRoleRooms) > 0 {
+		if err := t.Roles.UnassignRooms(report.OrphanRoleRooms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/neonxp/chatcloud/pkg/retention"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+var retentionCommands = map[string]command{
+	"run":   {"[-all]", runRetention},
+	"audit": {"[-room ID] [-before ID] [-limit 20]", retentionAudit},
+}
+
+// runRetention applies retention policies once, the same way the server worker does
+func runRetention(e *env, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	all := fs.Bool("all", false, "process every instance")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	tenants, err := e.registry()
+	if err != nil {
+		return err
+	}
+	log := logrus.New()
+	log.SetOutput(os.Stderr)
+	worker := retention.NewWorker(tenants, e.cfg.RetentionBatch, e.cfg.RetentionPause, log)
+	return forEachTenant(e, *all, func(t *tenant.Tenant) error {
+		n, err := worker.Apply(context.Background(), t)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.out, "%s: %d messages purged\n", t.Instance.ID, n)
+		return nil
+	})
+}
+
+func retentionAudit(e *env, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	room := fs.String("room", "", "show records of room only")
+	before := fs.String("before", "", "show records older than this record id")
+	limit := fs.Int("limit", 20, "records to show, at most 100")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	var roomID, beforeID primitive.ObjectID
+	var err error
+	if *room != "" {
+		if roomID, err = primitive.ObjectIDFromHex(*room); err != nil {
+			return fmt.Errorf("invalid room id: %w", err)
+		}
+	}
+	if *before != "" {
+		if beforeID, err = primitive.ObjectIDFromHex(*before); err != nil {
+			return fmt.Errorf("invalid record id: %w", err)
+		}
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	records, err := t.Retention.Find(roomID, beforeID, *limit)
+	if err != nil {
+		return err
+	}
+	return e.print(records)
+}
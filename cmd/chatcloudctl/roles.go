@@ -0,0 +1,179 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var roleCommands = map[string]command{
+	"create":      {"-name NAME [-scope global|room] [-permissions P1,P2]", createRole},
+	"list":        {"", listRoles},
+	"delete":      {"NAME SCOPE", deleteRole},
+	"permissions": {"NAME SCOPE [-add P1,P2] [-remove P1,P2]", rolePermissions},
+	"assign":      {"[-room ID] USER ROLE", assignRole},
+	"unassign":    {"[-room ID] USER", unassignRole},
+	"user":        {"USER", userRoles},
+}
+
+func createRole(e *env, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	name := fs.String("name", "", "role name")
+	scope := fs.String("scope", models.RoleScopeGlobal, "role scope")
+	permissions := fs.String("permissions", "", "comma separated permissions")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	r, err := t.Roles.CreateRole(*name, *scope, list(*permissions))
+	if err != nil {
+		return err
+	}
+	return e.print(r)
+}
+
+func listRoles(e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	roles, err := t.Roles.FindRoles()
+	if err != nil {
+		return err
+	}
+	return e.print(roles)
+}
+
+func deleteRole(e *env, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	err = t.Roles.RemoveRole(args[0], args[1])
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("role %s with scope %s not found", args[0], args[1])
+	}
+	return err
+}
+
+func rolePermissions(e *env, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	fs := flag.NewFlagSet("permissions", flag.ContinueOnError)
+	add := fs.String("add", "", "comma separated permissions to add")
+	remove := fs.String("remove", "", "comma separated permissions to remove")
+	if _, err := parse(fs, args[2:], 0); err != nil {
+		return err
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	r, err := t.Roles.UpdatePermissions(args[0], args[1], list(*add), list(*remove))
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("role %s with scope %s not found", args[0], args[1])
+	}
+	if err != nil {
+		return err
+	}
+	return e.print(r)
+}
+
+func assignRole(e *env, args []string) error {
+	fs := flag.NewFlagSet("assign", flag.ContinueOnError)
+	room := fs.String("room", "", "assign room scoped role in this room")
+	rest, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	if err := t.UsersExist(rest[:1]); err != nil {
+		return err
+	}
+	if *room != "" {
+		if _, err := findRoom(t, *room); err != nil {
+			return err
+		}
+	}
+	ur, err := t.Roles.Assign(rest[0], rest[1], *room)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("role %s not found", rest[1])
+	}
+	if err != nil {
+		return err
+	}
+	return e.print(ur)
+}
+
+func unassignRole(e *env, args []string) error {
+	fs := flag.NewFlagSet("unassign", flag.ContinueOnError)
+	room := fs.String("room", "", "remove role in this room instead of global one")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	err = t.Roles.Unassign(rest[0], *room)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("user %s has no such role", rest[0])
+	}
+	return err
+}
+
+func userRoles(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	roles, err := t.Roles.UserRoles(args[0])
+	if err != nil {
+		return err
+	}
+	return e.print(roles)
+}
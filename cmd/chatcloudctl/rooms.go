@@ -0,0 +1,231 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+	"github.com/neonxp/chatcloud/pkg/transcript"
+)
+
+var roomCommands = map[string]command{
+	"create": {"-creator USER -name NAME [-private] [-members U1,U2] [-custom-data JSON]", createRoom},
+	"list":   {"[-from ID] [-limit N] [-private]", listRooms},
+	"get":    {"ID", getRoom},
+	"delete": {"ID", deleteRoom},
+	"export": {"[-format jsonl|csv|html] [-out FILE] ID", exportRoom},
+}
+
+var memberCommands = map[string]command{
+	"add":    {"ROOM USER...", addMembers},
+	"remove": {"ROOM USER...", removeMembers},
+	"rooms":  {"USER", joinedRooms},
+}
+
+func createRoom(e *env, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	creator := fs.String("creator", "", "id of user creating room")
+	name := fs.String("name", "", "room name")
+	private := fs.Bool("private", false, "make room private")
+	members := fs.String("members", "", "comma separated ids of initial members")
+	data := fs.String("custom-data", "", "custom data as JSON")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *creator == "" || *name == "" {
+		return errUsage
+	}
+	cd, err := customData(*data)
+	if err != nil {
+		return err
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	userIDs := append(list(*members), *creator)
+	if err := t.UsersExist(userIDs); err != nil {
+		return err
+	}
+	r, err := t.Rooms.CreateRoom(*creator, *name, *private, "", cd, list(*members))
+	if err != nil {
+		return err
+	}
+	return e.print(r)
+}
+
+func listRooms(e *env, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	from := fs.String("from", "", "list rooms after this room id")
+	limit := fs.Int("limit", 20, "page size, up to 100")
+	private := fs.Bool("private", false, "include private rooms")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	rooms, err := t.Rooms.Find(*from, *limit, *private)
+	if err != nil {
+		return err
+	}
+	return e.print(rooms)
+}
+
+func getRoom(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	r, err := findRoom(t, args[0])
+	if err != nil {
+		return err
+	}
+	return e.print(r)
+}
+
+// deleteRoom removes room together with its messages, cursors, exports, moderation data and room scoped role assignments.
+// Rooms whose messages are under legal hold are refused
+func deleteRoom(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	r, err := findRoom(t, args[0])
+	if err != nil {
+		return err
+	}
+	return t.DeleteRoom(e.publisher(), r)
+}
+
+// exportRoom writes room transcript to file or stdout
+func exportRoom(e *env, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", models.ExportFormatJSONL, "transcript format: jsonl, csv or html")
+	out := fs.String("out", "", "output file, stdout when empty")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if transcript.ContentType(*format) == "" {
+		return transcript.ErrFormat
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	r, err := findRoom(t, rest[0])
+	if err != nil {
+		return err
+	}
+	var (
+		w io.Writer = e.out
+		f *os.File
+	)
+	if *out != "" {
+		if f, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := transcript.Write(context.Background(), t.Users, t.Messages, r, *format, w)
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "exported %d messages\n", n)
+	return nil
+}
+
+func addMembers(e *env, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	r, err := findRoom(t, args[0])
+	if err != nil {
+		return err
+	}
+	return t.AddMembers(e.publisher(), r, args[1:])
+}
+
+// removeMembers removes users from room, their open room streams end the same way as when removed through API
+func removeMembers(e *env, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	r, err := findRoom(t, args[0])
+	if err != nil {
+		return err
+	}
+	return t.RemoveMembers(e.publisher(), r, args[1:])
+}
+
+func joinedRooms(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	rooms, err := t.Rooms.JoinedRooms(args[0])
+	if err != nil {
+		return err
+	}
+	return e.print(rooms)
+}
+
+func findRoom(t *tenant.Tenant, id string) (*models.Room, error) {
+	r, err := t.Rooms.FindByID(id)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("room %s not found", id)
+	}
+	return r, err
+}
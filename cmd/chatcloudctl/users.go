@@ -0,0 +1,131 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var userCommands = map[string]command{
+	"create": {"-id ID -name NAME [-avatar-url URL] [-custom-data JSON]", createUser},
+	"list":   {"[-from RFC3339] [-limit N]", listUsers},
+	"get":    {"ID", getUser},
+	"delete": {"ID", deleteUser},
+}
+
+func createUser(e *env, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	id := fs.String("id", "", "user id")
+	name := fs.String("name", "", "display name")
+	avatarURL := fs.String("avatar-url", "", "avatar url")
+	data := fs.String("custom-data", "", "custom data as JSON")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *id == "" || *name == "" {
+		return errUsage
+	}
+	cd, err := customData(*data)
+	if err != nil {
+		return err
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	if _, err := t.Users.FindByID(*id); err == nil {
+		return fmt.Errorf("user %s already exists", *id)
+	} else if err != mongo.ErrNoDocuments {
+		return err
+	}
+	u, err := t.Users.CreateUser(*id, *name, *avatarURL, cd)
+	if err != nil {
+		return err
+	}
+	return e.print(u)
+}
+
+func listUsers(e *env, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	from := fs.String("from", "", "list users created after this time")
+	limit := fs.Int("limit", 20, "page size, up to 100")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	var fromTS time.Time
+	if *from != "" {
+		var err error
+		if fromTS, err = time.Parse(time.RFC3339Nano, *from); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	users, err := t.Users.Find(fromTS, *limit)
+	if err != nil {
+		return err
+	}
+	return e.print(users)
+}
+
+func getUser(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	u, err := t.Users.FindByID(args[0])
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("user %s not found", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	return e.print(u)
+}
+
+// deleteUser removes user with memberships and role assignments, messages are kept. Users under legal hold are refused
+func deleteUser(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	t, err := e.tenant()
+	if err != nil {
+		return err
+	}
+	u, err := t.Users.FindByID(args[0])
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("user %s not found", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	return t.DeleteUser(e.publisher(), u)
+}
@@ -3,12 +3,19 @@ module github.com/neonxp/chatcloud
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/caarlos0/env v3.5.0+incompatible
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-chi/chi v4.1.0+incompatible
+	github.com/go-chi/cors v1.1.1
 	github.com/go-chi/render v1.0.1
 	github.com/go-redis/redis v6.15.7+incompatible
 	github.com/neonxp/rutina/v2 v2.0.0
 	github.com/onsi/ginkgo v1.12.0 // indirect
 	github.com/onsi/gomega v1.9.0 // indirect
-	go.mongodb.org/mongo-driver v1.3.1
+	github.com/prometheus/client_golang v1.5.1
+	github.com/sirupsen/logrus v1.5.0
+	go.mongodb.org/mongo-driver v1.5.1
+	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -23,37 +23,138 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"os"
+	"sync"
 
 	"github.com/neonxp/rutina/v2"
+	"github.com/sirupsen/logrus"
 
 	"github.com/neonxp/chatcloud/pkg/config"
 	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/logger"
+	"github.com/neonxp/chatcloud/pkg/metrics"
+	"github.com/neonxp/chatcloud/pkg/moderation"
+	"github.com/neonxp/chatcloud/pkg/redis"
+	"github.com/neonxp/chatcloud/pkg/retention"
 	"github.com/neonxp/chatcloud/pkg/server"
+	"github.com/neonxp/chatcloud/pkg/tenant"
 )
 
 func main() {
-	r := rutina.New(rutina.Opt.SetListenOsSignals(true))
 	cfg, err := config.New()
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("can't load config")
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			logrus.WithError(err).Error("can't print config")
+		}
+		return
+	}
+	log, err := logger.New(cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		log.Println(err)
+		logrus.WithError(err).Error("can't create logger")
 		return
 	}
+	r := rutina.New(rutina.Opt.SetListenOsSignals(true).SetLogger(log.Debugf))
 	database, err := db.New(cfg.MongoConnection, cfg.MongoName)
 	if err != nil {
-		log.Println(err)
+		log.WithError(err).Error("can't connect to mongo")
 		return
 	}
-	api := server.NewServer(database, cfg)
+	rds := redis.New(cfg)
+	// one registry is shared by api server and workers, so they see the same cached instances
+	tenants, err := tenant.NewRegistry(database, rds, cfg.Isolation)
+	if err != nil {
+		log.WithError(err).Error("can't create instance registry")
+		return
+	}
+	if cfg.LegacyInstance != "" {
+		instance, err := tenants.AdoptLegacy(cfg.LegacyInstance)
+		if err != nil {
+			log.WithError(err).Error("can't adopt legacy data")
+			return
+		}
+		if instance != nil {
+			log.WithField("instance", instance.ID).Warn("data stored before instances is served by new instance, use chatcloudctl to mint its tokens")
+		}
+	}
+	api, err := server.NewServer(database, rds, tenants, cfg, log)
+	if err != nil {
+		log.WithError(err).Error("can't create server")
+		return
+	}
+	if err := api.Init(); err != nil {
+		log.WithError(err).Error("can't init server")
+		return
+	}
+	metricsServer := metrics.NewServer(cfg.MetricsListen)
 	r.Go(api.Run, rutina.RunOpt.SetOnDone(rutina.Shutdown))
+	r.Go(metricsServer.Run, nil)
+	r.Go(tenants.Watch, nil)
+	// Workers don't share rutina context, they are stopped after api server and waited for before storage is closed
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers sync.WaitGroup
+	startWorker := func(name string, run func(ctx context.Context) error) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := run(workersCtx); err != nil {
+				log.WithError(err).WithField("worker", name).Error("worker stopped with error")
+			}
+		}()
+	}
+	if cfg.RetentionInterval > 0 {
+		worker := retention.NewWorker(tenants, cfg.RetentionBatch, cfg.RetentionPause, log.WithField("worker", "retention"))
+		startWorker("retention", func(ctx context.Context) error {
+			return worker.Run(ctx, cfg.RetentionInterval)
+		})
+	}
+	if cfg.SanctionInterval > 0 {
+		worker := moderation.NewWorker(tenants, hub.New(rds), log.WithField("worker", "moderation"))
+		startWorker("moderation", func(ctx context.Context) error {
+			return worker.Run(ctx, cfg.SanctionInterval)
+		})
+	}
 	r.Go(func(ctx context.Context) error {
 		<-ctx.Done()
-		if err := api.Close(); err != nil {
-			log.Println(err)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		// Notifications are pushed to redis within request that produces them, so once api server
+		// has drained requests there is nothing left to flush
+		if err := api.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Error("can't shutdown api server")
+		}
+		stopWorkers()
+		stopped := make(chan struct{})
+		go func() {
+			workers.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			log.Warn("workers did not stop in time")
+		}
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Error("can't shutdown metrics server")
+		}
+		if err := database.Client().Disconnect(shutdownCtx); err != nil {
+			log.WithError(err).Error("can't disconnect from mongo")
+		}
+		if err := rds.Close(); err != nil {
+			log.WithError(err).Error("can't close redis client")
 		}
 		return nil
 	}, nil)
 	if err := r.Wait(); err != nil {
-		log.Println(err)
+		log.WithError(err).Error("stopped with error")
 	}
 }
@@ -0,0 +1,174 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package chatkit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// User, Room and other types mirror records of Chatkit export, which follow Chatkit API representation
+
+type User struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	AvatarURL  string          `json:"avatar_url"`
+	CustomData json.RawMessage `json:"custom_data"`
+	CreatedAt  string          `json:"created_at"`
+	UpdatedAt  string          `json:"updated_at"`
+}
+
+type Room struct {
+	ID                            string          `json:"id"`
+	CreatedByID                   string          `json:"created_by_id"`
+	Name                          string          `json:"name"`
+	Private                       bool            `json:"private"`
+	PushNotificationTitleOverride string          `json:"push_notification_title_override"`
+	CustomData                    json.RawMessage `json:"custom_data"`
+	MemberUserIDs                 []string        `json:"member_user_ids"`
+	CreatedAt                     string          `json:"created_at"`
+	UpdatedAt                     string          `json:"updated_at"`
+	LastMessageAt                 string          `json:"last_message_at"`
+}
+
+type Membership struct {
+	RoomID  string   `json:"room_id"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// Message is either multipart (v3+ API) or plain text (v2 API) message
+type Message struct {
+	ID        int64  `json:"id"`
+	UserID    string `json:"user_id"`
+	RoomID    string `json:"room_id"`
+	Parts     []Part `json:"parts"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type Part struct {
+	Type       string      `json:"type"`
+	Content    string      `json:"content"`
+	URL        string      `json:"url"`
+	Attachment *Attachment `json:"attachment"`
+}
+
+type Attachment struct {
+	ID          string          `json:"id"`
+	DownloadURL string          `json:"download_url"`
+	RefreshURL  string          `json:"refresh_url"`
+	Expiration  string          `json:"expiration"`
+	Name        string          `json:"name"`
+	CustomData  json.RawMessage `json:"custom_data"`
+	Size        int64           `json:"size"`
+}
+
+type Cursor struct {
+	CursorType int64  `json:"cursor_type"`
+	Position   int64  `json:"position"`
+	RoomID     string `json:"room_id"`
+	UserID     string `json:"user_id"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+type Role struct {
+	RoleName    string   `json:"role_name"`
+	Scope       string   `json:"scope"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserRole struct {
+	UserID   string `json:"user_id"`
+	RoleName string `json:"role_name"`
+	RoomID   string `json:"room_id"`
+}
+
+// decoder reads records from file holding either JSON array or JSON lines
+type decoder struct {
+	dec   *json.Decoder
+	array bool
+}
+
+func newDecoder(r io.Reader) (*decoder, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return &decoder{dec: json.NewDecoder(br)}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+			continue
+		}
+		d := &decoder{dec: json.NewDecoder(br), array: b[0] == '['}
+		if d.array {
+			if _, err := d.dec.Token(); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	}
+}
+
+// next decodes next record into v, io.EOF when there are no more records
+func (d *decoder) next(v interface{}) error {
+	if !d.dec.More() {
+		return io.EOF
+	}
+	return d.dec.Decode(v)
+}
+
+func openExport(name string) (*os.File, *decoder, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := newDecoder(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return f, d, nil
+}
+
+// timestamp converts Chatkit RFC 3339 time, missing time falls back to def
+func timestamp(s string, def primitive.DateTime) (primitive.DateTime, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	return primitive.NewDateTimeFromTime(t), nil
+}
@@ -0,0 +1,443 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package chatkit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/manager"
+	"github.com/neonxp/chatcloud/pkg/markdown"
+	"github.com/neonxp/chatcloud/pkg/models"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+const checkpointEvery = 500
+
+// Files of export directory in import order, every file is optional
+const (
+	FileRoles       = "roles.json"
+	FileUsers       = "users.json"
+	FileRooms       = "rooms.json"
+	FileMemberships = "memberships.json"
+	FileUserRoles   = "user_roles.json"
+	FileMessages    = "messages.json"
+	FileCursors     = "cursors.json"
+)
+
+const (
+	kindRoom    = "chatkit_room"
+	kindMessage = "chatkit_message"
+)
+
+var errSkip = errors.New("skipped")
+
+// Stats counts records per export file
+type Stats struct {
+	File     string `json:"file"`
+	Imported int64  `json:"imported"`
+	Skipped  int64  `json:"skipped"`
+	Resumed  int64  `json:"resumed_from"`
+}
+
+// Importer loads Chatkit export into instance. Room and message ids are mapped to local ones,
+// message ids are allocated before messages are imported in order of creation, so history order
+// does not depend on order of records in export.
+// Progress and id mapping are stored in instance, so running importer again resumes interrupted import
+type Importer struct {
+	t   *tenant.Tenant
+	dir string
+	log func(format string, args ...interface{})
+}
+
+func NewImporter(t *tenant.Tenant, dir string, log func(format string, args ...interface{})) *Importer {
+	return &Importer{t: t, dir: dir, log: log}
+}
+
+type step struct {
+	file   string
+	record func() interface{}
+	apply  func(v interface{}) error
+	// prepare is optional pass over whole file before records are applied
+	prepare func(path string) error
+}
+
+func (i *Importer) Run() ([]*Stats, error) {
+	steps := []step{
+		{FileRoles, func() interface{} { return new(Role) }, i.importRole, nil},
+		{FileUsers, func() interface{} { return new(User) }, i.importUser, nil},
+		{FileRooms, func() interface{} { return new(Room) }, i.importRoom, nil},
+		{FileMemberships, func() interface{} { return new(Membership) }, i.importMembership, nil},
+		{FileUserRoles, func() interface{} { return new(UserRole) }, i.importUserRole, nil},
+		{FileMessages, func() interface{} { return new(Message) }, i.importMessage, i.allocateMessageIDs},
+		{FileCursors, func() interface{} { return new(Cursor) }, i.importCursor, nil},
+	}
+	var stats []*Stats
+	for _, s := range steps {
+		st, err := i.run(s)
+		if os.IsNotExist(err) {
+			i.log("%s: not found, skipping", s.file)
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+func (i *Importer) run(s step) (*Stats, error) {
+	path := filepath.Join(i.dir, s.file)
+	if s.prepare != nil {
+		if err := s.prepare(path); err != nil {
+			return nil, err
+		}
+	}
+	f, dec, err := openExport(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	source := "chatkit/" + s.file
+	offset, err := i.t.Imports.Progress(source)
+	if err != nil {
+		return nil, err
+	}
+	st := &Stats{File: s.file, Resumed: offset}
+	if offset > 0 {
+		i.log("%s: resuming after %d records", s.file, offset)
+	}
+	var n int64
+	for ; ; n++ {
+		v := s.record()
+		err := dec.next(v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return st, fmt.Errorf("%s: record %d: %w", s.file, n, err)
+		}
+		if n < offset {
+			continue
+		}
+		switch err := s.apply(v); err {
+		case nil:
+			st.Imported++
+		case errSkip:
+			st.Skipped++
+		default:
+			return st, fmt.Errorf("%s: record %d: %w", s.file, n, err)
+		}
+		if (n+1)%checkpointEvery == 0 {
+			if err := i.t.Imports.SetProgress(source, n+1); err != nil {
+				return st, err
+			}
+			i.log("%s: %d records done", s.file, n+1)
+		}
+	}
+	if err := i.t.Imports.SetProgress(source, n); err != nil {
+		return st, err
+	}
+	i.log("%s: imported %d, skipped %d", s.file, st.Imported, st.Skipped)
+	return st, nil
+}
+
+func (i *Importer) importRole(v interface{}) error {
+	r := v.(*Role)
+	_, err := i.t.Roles.CreateRole(r.RoleName, r.Scope, r.Permissions)
+	if err == manager.ErrRoleExists {
+		return errSkip
+	}
+	return err
+}
+
+func (i *Importer) importUser(v interface{}) error {
+	u := v.(*User)
+	if _, err := i.t.Users.FindByID(u.ID); err == nil {
+		return errSkip
+	} else if err != mongo.ErrNoDocuments {
+		return err
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	createdAt, err := timestamp(u.CreatedAt, now)
+	if err != nil {
+		return err
+	}
+	updatedAt, err := timestamp(u.UpdatedAt, createdAt)
+	if err != nil {
+		return err
+	}
+	return i.t.Users.Insert(&models.User{
+		ID:         u.ID,
+		Name:       u.Name,
+		AvatarURL:  u.AvatarURL,
+		CustomData: u.CustomData,
+		CreatedAt:  createdAt,
+		UpdatedAt:  updatedAt,
+	})
+}
+
+// importRoom reserves local id before inserting, so retried record never creates second room
+func (i *Importer) importRoom(v interface{}) error {
+	r := v.(*Room)
+	id, err := i.mapRoom(r.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := i.t.Rooms.FindByID(id.Hex()); err == nil {
+		return errSkip
+	} else if err != mongo.ErrNoDocuments {
+		return err
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	createdAt, err := timestamp(r.CreatedAt, now)
+	if err != nil {
+		return err
+	}
+	updatedAt, err := timestamp(r.UpdatedAt, createdAt)
+	if err != nil {
+		return err
+	}
+	lastMessageAt, err := timestamp(r.LastMessageAt, 0)
+	if err != nil {
+		return err
+	}
+	members := r.MemberUserIDs
+	if members == nil {
+		members = []string{}
+	}
+	return i.t.Rooms.Insert(&models.Room{
+		ID:                            id,
+		Name:                          r.Name,
+		Private:                       r.Private,
+		PushNotificationTitleOverride: r.PushNotificationTitleOverride,
+		CreatedByID:                   r.CreatedByID,
+		LastMessageAt:                 lastMessageAt,
+		CreatedAt:                     createdAt,
+		UpdatedAt:                     updatedAt,
+		CustomData:                    r.CustomData,
+		MemberUserIDs:                 members,
+	})
+}
+
+func (i *Importer) importMembership(v interface{}) error {
+	m := v.(*Membership)
+	roomID, err := i.roomID(m.RoomID)
+	if err != nil {
+		return err
+	}
+	if len(m.UserIDs) == 0 {
+		return errSkip
+	}
+	return i.t.Rooms.AddMembers(roomID, m.UserIDs)
+}
+
+func (i *Importer) importUserRole(v interface{}) error {
+	ur := v.(*UserRole)
+	roomID := ""
+	if ur.RoomID != "" {
+		id, err := i.roomID(ur.RoomID)
+		if err != nil {
+			return err
+		}
+		roomID = id.Hex()
+	}
+	_, err := i.t.Roles.Assign(ur.UserID, ur.RoleName, roomID)
+	if err == mongo.ErrNoDocuments {
+		i.log("%s: role %s of user %s is not defined, skipping", FileUserRoles, ur.RoleName, ur.UserID)
+		return errSkip
+	}
+	return err
+}
+
+// allocateMessageIDs maps ids of all messages in export to local ones ordered by creation time and Chatkit id.
+// Ids mapped by interrupted import are kept, they belong to the oldest messages as allocation goes in the same order
+func (i *Importer) allocateMessageIDs(path string) error {
+	f, dec, err := openExport(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	type entry struct {
+		id int64
+		at primitive.DateTime
+	}
+	var entries []entry
+	for n := 0; ; n++ {
+		m := new(Message)
+		err := dec.next(m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: record %d: %w", FileMessages, n, err)
+		}
+		at, err := timestamp(m.CreatedAt, 0)
+		if err != nil {
+			return fmt.Errorf("%s: record %d: %w", FileMessages, n, err)
+		}
+		entries = append(entries, entry{id: m.ID, at: at})
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].at != entries[b].at {
+			return entries[a].at < entries[b].at
+		}
+		return entries[a].id < entries[b].id
+	})
+	var allocated int64
+	for _, e := range entries {
+		_, err := i.t.Imports.LookupNumericID(kindMessage, e.id)
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+		id, err := i.t.Messages.NextID()
+		if err != nil {
+			return err
+		}
+		if err := i.t.Imports.MapNumericID(kindMessage, e.id, id); err != nil {
+			return err
+		}
+		allocated++
+	}
+	i.log("%s: allocated %d message ids", FileMessages, allocated)
+	return nil
+}
+
+// importMessage stores message under id allocated by allocateMessageIDs
+func (i *Importer) importMessage(v interface{}) error {
+	m := v.(*Message)
+	roomID, err := i.roomID(m.RoomID)
+	if err != nil {
+		return err
+	}
+	id, err := i.t.Imports.LookupNumericID(kindMessage, m.ID)
+	if err != nil {
+		return fmt.Errorf("id of message %d is not allocated: %w", m.ID, err)
+	}
+	if _, err := i.t.Messages.FindByID(id); err == nil {
+		return errSkip
+	} else if err != mongo.ErrNoDocuments {
+		return err
+	}
+	createdAt, err := timestamp(m.CreatedAt, primitive.NewDateTimeFromTime(time.Now()))
+	if err != nil {
+		return err
+	}
+	updatedAt, err := timestamp(m.UpdatedAt, createdAt)
+	if err != nil {
+		return err
+	}
+	parts := make([]models.MessagePart, 0, len(m.Parts))
+	for _, p := range m.Parts {
+		part := models.MessagePart{Type: p.Type, Content: p.Content, URL: p.URL}
+		// history is imported as is, markdown that fails the checks keeps source only
+		if markdown.Is(p.Type) {
+			if html, text, err := markdown.Render(p.Content); err == nil {
+				part.HTML, part.Text = html, text
+			}
+		}
+		if p.Attachment != nil {
+			part.Attachment = models.Attachment{
+				ID:          primitive.NewObjectID(),
+				CustomData:  p.Attachment.CustomData,
+				DownloadURL: p.Attachment.DownloadURL,
+				Expiration:  p.Attachment.Expiration,
+				Name:        p.Attachment.Name,
+				RefreshURL:  p.Attachment.RefreshURL,
+				Size:        p.Attachment.Size,
+			}
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 && m.Text != "" {
+		parts = append(parts, models.MessagePart{Type: "text/plain", Content: m.Text})
+	}
+	return i.t.Messages.Insert(&models.Message{
+		ID:        id,
+		CreatedAt: createdAt,
+		Parts:     parts,
+		RoomID:    roomID,
+		UpdatedAt: updatedAt,
+		UserID:    m.UserID,
+	})
+}
+
+// importCursor points cursor to imported message, or to the closest earlier one when message was not exported
+func (i *Importer) importCursor(v interface{}) error {
+	c := v.(*Cursor)
+	roomID, err := i.roomID(c.RoomID)
+	if err != nil {
+		return err
+	}
+	position, err := i.t.Imports.LookupNumericIDAtOrBefore(kindMessage, c.Position)
+	if err == mongo.ErrNoDocuments {
+		return errSkip
+	}
+	if err != nil {
+		return err
+	}
+	updatedAt, err := timestamp(c.UpdatedAt, primitive.NewDateTimeFromTime(time.Now()))
+	if err != nil {
+		return err
+	}
+	return i.t.Cursors.Set(&models.Cursor{
+		CursorType: c.CursorType,
+		Position:   position,
+		RoomID:     roomID.Hex(),
+		UserID:     c.UserID,
+		UpdatedAt:  updatedAt,
+	})
+}
+
+// mapRoom returns local id of Chatkit room, reserving new one if room was not seen yet
+func (i *Importer) mapRoom(old string) (primitive.ObjectID, error) {
+	id, err := i.roomID(old)
+	if err != errSkip {
+		return id, err
+	}
+	id = primitive.NewObjectID()
+	return id, i.t.Imports.MapID(kindRoom, old, id.Hex())
+}
+
+// roomID returns local id of imported room, errSkip for rooms missing from export
+func (i *Importer) roomID(old string) (primitive.ObjectID, error) {
+	hex, err := i.t.Imports.LookupID(kindRoom, old)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, errSkip
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return primitive.ObjectIDFromHex(hex)
+}
@@ -0,0 +1,248 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package client is Go SDK for ChatCloud API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries    = 3
+	defaultMinBackoff = 200 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// Client calls API of one instance. It is safe for concurrent use
+type Client struct {
+	baseURL    string
+	http       *http.Client
+	tokens     *tokenSource
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+type Option func(c *Client)
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to configure TLS client certificates
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// WithRetries sets how many times failed request is retried and backoff bounds between attempts
+func WithRetries(retries int, minBackoff time.Duration, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New creates client for instance at host (e.g. https://chat.example.com) authenticated with instance key
+func New(host string, instanceID string, keyID string, secret string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(host, "/") + "/api/v1/" + url.PathEscape(instanceID),
+		http:       http.DefaultClient,
+		retries:    defaultRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	c.tokens = newTokenSource(c, keyID, secret)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is returned for non successful API responses
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("chatcloud: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("chatcloud: %d %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is API 404 response
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// do performs authenticated request on behalf of userID, empty userID means server token
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, userID string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	auth := func(req *http.Request) error {
+		tok, err := c.tokens.Token(ctx, userID)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+		return nil
+	}
+	err := c.roundTrip(ctx, method, path, query, body, auth, out)
+	if e, ok := err.(*Error); ok && e.StatusCode == http.StatusUnauthorized {
+		// Token may be revoked or expired earlier than expected, so refresh it once
+		c.tokens.invalidate(userID)
+		err = c.roundTrip(ctx, method, path, query, body, auth, out)
+	}
+	return err
+}
+
+// roundTrip sends request retrying network errors and overload responses with exponential backoff
+func (c *Client) roundTrip(ctx context.Context, method string, path string, query url.Values, body []byte, auth func(req *http.Request) error, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var lastErr error
+	var wait time.Duration
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			if wait <= 0 {
+				wait = c.backoff(attempt)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			wait = 0
+		}
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, u, reader)
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Accept", "application/json")
+		if err := auth(req); err != nil {
+			return err
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			if !idempotent(method) {
+				return err
+			}
+			continue
+		}
+		err = decode(resp, out)
+		if e, ok := err.(*Error); ok && retryable(method, e.StatusCode) {
+			lastErr = err
+			if wait = retryAfter(resp); wait > c.maxBackoff {
+				wait = c.maxBackoff
+			}
+			continue
+		}
+		return err
+	}
+	return lastErr
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << uint(attempt-1)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	// Full jitter keeps many clients from retrying in lockstep
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+func decode(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		e := &Error{StatusCode: resp.StatusCode}
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = json.Unmarshal(b, e)
+		return e
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodHead:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether request can be repeated. Non idempotent requests are repeated only when server surely did not process them
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return 0
+}
+
+func jsonBody(in interface{}) ([]byte, error) {
+	return json.Marshal(in)
+}
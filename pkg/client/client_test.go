@@ -0,0 +1,375 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+const testPrefix = "/api/v1/test"
+
+// testAPI issues numbered tokens and passes other requests to handler
+type testAPI struct {
+	mu        sync.Mutex
+	expiresIn int64
+	issued    int
+	revoked   map[string]bool
+	handler   http.HandlerFunc
+}
+
+func (a *testAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == testPrefix+"/token" {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "key" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		a.mu.Lock()
+		a.issued++
+		tok := "tok-" + strconv.Itoa(a.issued)
+		a.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(&TokenResponse{AccessToken: tok, TokenType: "bearer", ExpiresIn: a.expiresIn})
+		return
+	}
+	a.mu.Lock()
+	revoked := a.revoked[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	a.mu.Unlock()
+	if revoked {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	a.handler(w, r)
+}
+
+func (a *testAPI) tokens() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.issued
+}
+
+func testClient(t *testing.T, handler http.HandlerFunc) (*Client, *testAPI) {
+	api := &testAPI{expiresIn: 3600, revoked: map[string]bool{}, handler: handler}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return New(srv.URL, "test", "key", "secret", WithRetries(3, time.Millisecond, 5*time.Millisecond)), api
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		statuses []int
+		want     int
+		calls    int
+	}{
+		{"get retried until success", http.MethodGet, []int{503, 502, 200}, 200, 3},
+		{"get gives up after retries", http.MethodGet, []int{503, 503, 503, 503, 200}, 503, 4},
+		{"post retried on overload", http.MethodPost, []int{429, 200}, 200, 2},
+		{"post not retried on bad gateway", http.MethodPost, []int{502, 200}, 502, 1},
+		{"client errors not retried", http.MethodGet, []int{404, 200}, 404, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			c, _ := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.statuses[calls]
+				calls++
+				mu.Unlock()
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = w.Write([]byte(`{}`))
+				}
+			})
+			err := c.do(context.Background(), tt.method, "/users", nil, "", nil, &struct{}{})
+			status := http.StatusOK
+			if e, ok := err.(*Error); ok {
+				status = e.StatusCode
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.want {
+				t.Errorf("status = %d, want %d", status, tt.want)
+			}
+			if calls != tt.calls {
+				t.Errorf("calls = %d, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	calls := 0
+	c, _ := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	start := time.Now()
+	if err := c.DeleteUser(context.Background(), "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retry waited %s, backoff cap is ignored", elapsed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := New("http://localhost", "test", "key", "secret", WithRetries(10, 10*time.Millisecond, 50*time.Millisecond))
+	for attempt := 1; attempt <= 10; attempt++ {
+		limit := 10 * time.Millisecond << uint(attempt-1)
+		if limit > 50*time.Millisecond {
+			limit = 50 * time.Millisecond
+		}
+		for i := 0; i < 100; i++ {
+			if d := c.backoff(attempt); d <= 0 || d > limit {
+				t.Fatalf("backoff(%d) = %s, want in (0, %s]", attempt, d, limit)
+			}
+		}
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	c, api := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("Authorization"))
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(&models.User{ID: "u1"})
+	})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetUser(ctx, "u1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := api.tokens(); n != 1 {
+		t.Fatalf("issued %d tokens, cached token must be reused", n)
+	}
+
+	api.mu.Lock()
+	api.revoked["tok-1"] = true
+	api.mu.Unlock()
+	if _, err := c.GetUser(ctx, "u1"); err != nil {
+		t.Fatalf("revoked token is not refreshed: %v", err)
+	}
+	if n := api.tokens(); n != 2 {
+		t.Fatalf("issued %d tokens, want new token after 401", n)
+	}
+	if last := seen[len(seen)-1]; last != "Bearer tok-2" {
+		t.Errorf("retried with %q, want refreshed token", last)
+	}
+
+	api.mu.Lock()
+	api.expiresIn = 30
+	api.mu.Unlock()
+	c.tokens.invalidate("")
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetUser(ctx, "u1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := api.tokens(); n != 4 {
+		t.Errorf("issued %d tokens, token expiring within refresh window must be replaced", n)
+	}
+}
+
+func TestTokenPerUser(t *testing.T) {
+	c, api := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ctx := context.Background()
+	for _, user := range []string{"u1", "u2", "u1", ""} {
+		if err := c.JoinRoom(ctx, user, "r1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := api.tokens(); n != 3 {
+		t.Errorf("issued %d tokens, want one per acted user", n)
+	}
+}
+
+func TestUserIterator(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var users []*models.User
+	for i := 0; i < 5; i++ {
+		users = append(users, &models.User{
+			ID:        fmt.Sprintf("u%d", i),
+			CreatedAt: primitive.NewDateTimeFromTime(base.Add(time.Duration(i) * time.Second)),
+		})
+	}
+	var queries []string
+	c, _ := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		queries = append(queries, q.Encode())
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		var from time.Time
+		if v := q.Get("from_ts"); v != "" {
+			var err error
+			if from, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		page := []*models.User{}
+		for _, u := range users {
+			if u.CreatedAt.Time().After(from) && len(page) < limit {
+				page = append(page, u)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	})
+	it := c.Users(2)
+	var got []string
+	for it.Next(context.Background()) {
+		got = append(got, it.User().ID)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "u0,u1,u2,u3,u4"; strings.Join(got, ",") != want {
+		t.Errorf("iterated %v, want %s", got, want)
+	}
+	if len(queries) != 4 {
+		t.Errorf("made %d requests, want 3 pages and empty one", len(queries))
+	}
+	if it.Next(context.Background()) {
+		t.Error("exhausted iterator advanced again")
+	}
+}
+
+func TestRoomIteratorStopsOnError(t *testing.T) {
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	c, _ := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("include_private") != "true" || q.Get("include_archived") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch q.Get("from_id") {
+		case "":
+			_ = json.NewEncoder(w).Encode([]*models.Room{{ID: ids[0]}, {ID: ids[1]}})
+		case ids[1].Hex():
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	it := c.Rooms(2, true)
+	n := 0
+	for it.Next(context.Background()) {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("iterated %d rooms, want 2", n)
+	}
+	if e, ok := it.Err().(*Error); !ok || e.StatusCode != http.StatusForbidden {
+		t.Errorf("err = %v, want 403", it.Err())
+	}
+}
+
+func TestSubscriptionReconnects(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns int
+	)
+	c, _ := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != methodSubscribe || r.URL.Path != testPrefix+"/users/u1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		conns++
+		n := conns
+		mu.Unlock()
+		enc := json.NewEncoder(w)
+		switch n {
+		case 1:
+			// Server shutdown asks subscriber to come back shortly
+			_ = enc.Encode(&Event{Name: "new_message", Data: json.RawMessage(`1`)})
+			_ = enc.Encode(&Event{Name: eventReconnect, Data: json.RawMessage(`{"retry_after_ms":1}`)})
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 3:
+			// Dropped connection
+			_ = enc.Encode(&Event{Name: "new_message", Data: json.RawMessage(`2`)})
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub := c.SubscribeUser(ctx, "u1")
+	var got []string
+	for event := range sub.Events() {
+		got = append(got, event.Name+":"+string(event.Data))
+	}
+	if want := "new_message:1,new_message:2"; strings.Join(got, ",") != want {
+		t.Errorf("received %v, want %s", got, want)
+	}
+	if e, ok := sub.Err().(*Error); !ok || e.StatusCode != http.StatusForbidden {
+		t.Errorf("err = %v, want 403 ending subscription", sub.Err())
+	}
+	if conns != 4 {
+		t.Errorf("connected %d times, want 4", conns)
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	c, _ := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	sub := c.SubscribeRoom(context.Background(), "r1", "u1")
+	sub.Close()
+	select {
+	case _, ok := <-sub.Events():
+		if ok {
+			t.Error("event received after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription is not closed")
+	}
+	if sub.Err() != nil {
+		t.Errorf("err = %v, closing is not an error", sub.Err())
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+type setCursorRequest struct {
+	Position int64 `json:"position"`
+}
+
+// SetReadCursor marks messages up to position as read by user
+func (c *Client) SetReadCursor(ctx context.Context, roomID string, userID string, position int64) error {
+	return c.do(ctx, http.MethodPut, cursorPath(roomID, userID), nil, userID, &setCursorRequest{Position: position}, nil)
+}
+
+func (c *Client) GetReadCursor(ctx context.Context, roomID string, userID string) (*models.Cursor, error) {
+	cursor := new(models.Cursor)
+	return cursor, c.do(ctx, http.MethodGet, cursorPath(roomID, userID), nil, "", nil, cursor)
+}
+
+func (c *Client) RoomReadCursors(ctx context.Context, roomID string) ([]*models.Cursor, error) {
+	var cursors []*models.Cursor
+	return cursors, c.do(ctx, http.MethodGet, "/cursors/0/rooms/"+url.PathEscape(roomID), nil, "", nil, &cursors)
+}
+
+func (c *Client) UserReadCursors(ctx context.Context, userID string) ([]*models.Cursor, error) {
+	var cursors []*models.Cursor
+	return cursors, c.do(ctx, http.MethodGet, "/cursors/0/users/"+url.PathEscape(userID), nil, "", nil, &cursors)
+}
+
+func cursorPath(roomID string, userID string) string {
+	return "/cursors/0/rooms/" + url.PathEscape(roomID) + "/users/" + url.PathEscape(userID)
+}
+
+// SetThreadCursor marks thread of parentID read up to position by user
+func (c *Client) SetThreadCursor(ctx context.Context, roomID string, parentID int64, userID string, position int64) error {
+	return c.do(ctx, http.MethodPut, messagePath(roomID, parentID)+"/cursor", nil, userID, &setCursorRequest{Position: position}, nil)
+}
+
+func (c *Client) GetThreadCursor(ctx context.Context, roomID string, parentID int64, userID string) (*models.ThreadRS, error) {
+	rs := new(models.ThreadRS)
+	return rs, c.do(ctx, http.MethodGet, messagePath(roomID, parentID)+"/cursor", nil, userID, nil, rs)
+}
+
+// ReadStates returns read cursor with unread message and mention counts for every room user joined
+func (c *Client) ReadStates(ctx context.Context, userID string) ([]*models.RS, error) {
+	var states []*models.RS
+	return states, c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/read_states", nil, userID, nil, &states)
+}
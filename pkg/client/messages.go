@@ -0,0 +1,180 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+const (
+	DirectionOlder = "older"
+	DirectionNewer = "newer"
+)
+
+type SendMessageRequest struct {
+	Parts    []models.MessagePart `json:"parts"`
+	ParentID int64                `json:"parent_id,omitempty"`
+}
+
+type SendMessageResponse struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// SendMessage posts message to room on behalf of userID and returns its id
+func (c *Client) SendMessage(ctx context.Context, roomID string, userID string, parts []models.MessagePart) (int64, error) {
+	resp := new(SendMessageResponse)
+	err := c.do(ctx, http.MethodPost, roomPath(roomID)+"/messages", nil, userID, &SendMessageRequest{Parts: parts}, resp)
+	return resp.MessageID, err
+}
+
+// SendText is shortcut for single text/plain part message
+func (c *Client) SendText(ctx context.Context, roomID string, userID string, text string) (int64, error) {
+	return c.SendMessage(ctx, roomID, userID, []models.MessagePart{{Type: "text/plain", Content: text}})
+}
+
+// Reply posts message to thread of parentID on behalf of userID and returns its id
+func (c *Client) Reply(ctx context.Context, roomID string, parentID int64, userID string, parts []models.MessagePart) (int64, error) {
+	resp := new(SendMessageResponse)
+	err := c.do(ctx, http.MethodPost, roomPath(roomID)+"/messages", nil, userID, &SendMessageRequest{Parts: parts, ParentID: parentID}, resp)
+	return resp.MessageID, err
+}
+
+func (c *Client) GetMessage(ctx context.Context, roomID string, messageID int64) (*models.Message, error) {
+	m := new(models.Message)
+	return m, c.do(ctx, http.MethodGet, messagePath(roomID, messageID), nil, "", nil, m)
+}
+
+func (c *Client) EditMessage(ctx context.Context, roomID string, messageID int64, userID string, parts []models.MessagePart) error {
+	return c.do(ctx, http.MethodPut, messagePath(roomID, messageID), nil, userID, &SendMessageRequest{Parts: parts}, nil)
+}
+
+func (c *Client) DeleteMessage(ctx context.Context, roomID string, messageID int64) error {
+	return c.do(ctx, http.MethodDelete, messagePath(roomID, messageID), nil, "", nil, nil)
+}
+
+// AddReaction reacts to message on behalf of userID and returns updated reactions of message
+func (c *Client) AddReaction(ctx context.Context, roomID string, messageID int64, userID string, reaction string) ([]models.Reaction, error) {
+	var reactions []models.Reaction
+	return reactions, c.do(ctx, http.MethodPut, reactionPath(roomID, messageID, reaction), nil, userID, nil, &reactions)
+}
+
+func (c *Client) RemoveReaction(ctx context.Context, roomID string, messageID int64, userID string, reaction string) ([]models.Reaction, error) {
+	var reactions []models.Reaction
+	return reactions, c.do(ctx, http.MethodDelete, reactionPath(roomID, messageID, reaction), nil, userID, nil, &reactions)
+}
+
+// PinMessage pins message on behalf of userID, user needs message:pin permission
+func (c *Client) PinMessage(ctx context.Context, roomID string, messageID int64, userID string) error {
+	return c.do(ctx, http.MethodPut, messagePath(roomID, messageID)+"/pin", nil, userID, nil, nil)
+}
+
+func (c *Client) UnpinMessage(ctx context.Context, roomID string, messageID int64, userID string) error {
+	return c.do(ctx, http.MethodDelete, messagePath(roomID, messageID)+"/pin", nil, userID, nil, nil)
+}
+
+// Pins returns pinned messages of room, recently pinned first
+func (c *Client) Pins(ctx context.Context, roomID string) ([]*models.Message, error) {
+	var messages []*models.Message
+	return messages, c.do(ctx, http.MethodGet, roomPath(roomID)+"/pins", nil, "", nil, &messages)
+}
+
+// Mentions returns page of messages mentioning userID, newest first, starting before initialID when it is set
+func (c *Client) Mentions(ctx context.Context, userID string, initialID int64, limit int) ([]*models.Message, error) {
+	query := url.Values{}
+	if initialID != 0 {
+		query.Set("initial_id", strconv.FormatInt(initialID, 10))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var messages []*models.Message
+	return messages, c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/mentions", query, userID, nil, &messages)
+}
+
+// Messages iterates over room history starting after initialID (0 means from the newest or oldest message) in given direction
+func (c *Client) Messages(roomID string, initialID int64, direction string, pageSize int) *MessageIterator {
+	return &MessageIterator{c: c, path: roomPath(roomID) + "/messages", fromID: initialID, direction: direction, pageSize: pageSize}
+}
+
+// Replies iterates over thread of parentID the same way Messages iterates over room history
+func (c *Client) Replies(roomID string, parentID int64, initialID int64, direction string, pageSize int) *MessageIterator {
+	return &MessageIterator{c: c, path: messagePath(roomID, parentID) + "/replies", fromID: initialID, direction: direction, pageSize: pageSize}
+}
+
+type MessageIterator struct {
+	c         *Client
+	path      string
+	direction string
+	pageSize  int
+	fromID    int64
+	buf       []*models.Message
+	cur       *models.Message
+	err       error
+	done      bool
+}
+
+func (it *MessageIterator) Next(ctx context.Context) bool {
+	if len(it.buf) == 0 && !it.done && it.err == nil {
+		query := url.Values{}
+		if it.pageSize > 0 {
+			query.Set("limit", strconv.Itoa(it.pageSize))
+		}
+		if it.fromID != 0 {
+			query.Set("initial_id", strconv.FormatInt(it.fromID, 10))
+		}
+		if it.direction != "" {
+			query.Set("direction", it.direction)
+		}
+		it.err = it.c.do(ctx, http.MethodGet, it.path, query, "", nil, &it.buf)
+		if len(it.buf) == 0 {
+			it.done = true
+		} else {
+			it.fromID = it.buf[len(it.buf)-1].ID
+		}
+	}
+	if len(it.buf) == 0 {
+		return false
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+func (it *MessageIterator) Message() *models.Message {
+	return it.cur
+}
+
+func (it *MessageIterator) Err() error {
+	return it.err
+}
+
+func messagePath(roomID string, messageID int64) string {
+	return roomPath(roomID) + "/messages/" + strconv.FormatInt(messageID, 10)
+}
+
+func reactionPath(roomID string, messageID int64, reaction string) string {
+	return messagePath(roomID, messageID) + "/reactions/" + url.PathEscape(reaction)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+// SanctionRequest describes ban or mute, zero ExpiresAt keeps it until lifted
+type SanctionRequest struct {
+	Reason    string
+	ExpiresAt time.Time
+}
+
+type sanctionBody struct {
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type kickRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// BanUser removes userID from room and keeps them out, moderatorID needs room:moderate permission
+func (c *Client) BanUser(ctx context.Context, roomID string, userID string, moderatorID string, req *SanctionRequest) error {
+	return c.do(ctx, http.MethodPut, roomPath(roomID)+"/bans/"+url.PathEscape(userID), nil, moderatorID, req.body(), nil)
+}
+
+func (c *Client) UnbanUser(ctx context.Context, roomID string, userID string, moderatorID string) error {
+	return c.do(ctx, http.MethodDelete, roomPath(roomID)+"/bans/"+url.PathEscape(userID), nil, moderatorID, nil, nil)
+}
+
+// MuteUser keeps userID from posting to room, moderatorID needs room:moderate permission
+func (c *Client) MuteUser(ctx context.Context, roomID string, userID string, moderatorID string, req *SanctionRequest) error {
+	return c.do(ctx, http.MethodPut, roomPath(roomID)+"/mutes/"+url.PathEscape(userID), nil, moderatorID, req.body(), nil)
+}
+
+func (c *Client) UnmuteUser(ctx context.Context, roomID string, userID string, moderatorID string) error {
+	return c.do(ctx, http.MethodDelete, roomPath(roomID)+"/mutes/"+url.PathEscape(userID), nil, moderatorID, nil, nil)
+}
+
+// KickUser removes userID from room, they can join again
+func (c *Client) KickUser(ctx context.Context, roomID string, userID string, moderatorID string, reason string) error {
+	return c.do(ctx, http.MethodPost, roomPath(roomID)+"/users/"+url.PathEscape(userID)+"/kick", nil, moderatorID, &kickRequest{Reason: reason}, nil)
+}
+
+func (c *Client) Bans(ctx context.Context, roomID string, moderatorID string) ([]*models.Sanction, error) {
+	var sanctions []*models.Sanction
+	return sanctions, c.do(ctx, http.MethodGet, roomPath(roomID)+"/bans", nil, moderatorID, nil, &sanctions)
+}
+
+func (c *Client) Mutes(ctx context.Context, roomID string, moderatorID string) ([]*models.Sanction, error) {
+	var sanctions []*models.Sanction
+	return sanctions, c.do(ctx, http.MethodGet, roomPath(roomID)+"/mutes", nil, moderatorID, nil, &sanctions)
+}
+
+// ModerationLog returns page of moderation actions in room, newest first, starting before beforeID when it is set
+func (c *Client) ModerationLog(ctx context.Context, roomID string, moderatorID string, beforeID string, limit int) ([]*models.ModerationEntry, error) {
+	query := url.Values{}
+	if beforeID != "" {
+		query.Set("before", beforeID)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var entries []*models.ModerationEntry
+	return entries, c.do(ctx, http.MethodGet, roomPath(roomID)+"/moderation_log", query, moderatorID, nil, &entries)
+}
+
+func (r *SanctionRequest) body() *sanctionBody {
+	b := &sanctionBody{}
+	if r == nil {
+		return b
+	}
+	b.Reason = r.Reason
+	if !r.ExpiresAt.IsZero() {
+		b.ExpiresAt = &r.ExpiresAt
+	}
+	return b
+}
+
+type filtersBody struct {
+	Rules []models.FilterRule `json:"rules"`
+}
+
+// RoomFilters returns message filter rules of room, moderatorID needs room:moderate permission
+func (c *Client) RoomFilters(ctx context.Context, roomID string, moderatorID string) ([]models.FilterRule, error) {
+	body := new(filtersBody)
+	return body.Rules, c.do(ctx, http.MethodGet, roomPath(roomID)+"/filters", nil, moderatorID, nil, body)
+}
+
+// SetRoomFilters replaces message filter rules of room, they apply after instance rules
+func (c *Client) SetRoomFilters(ctx context.Context, roomID string, moderatorID string, rules []models.FilterRule) error {
+	if rules == nil {
+		rules = []models.FilterRule{}
+	}
+	return c.do(ctx, http.MethodPut, roomPath(roomID)+"/filters", nil, moderatorID, &filtersBody{Rules: rules}, nil)
+}
+
+// Flagged returns page of messages held for review by filters, newest first, starting before initialID when it is set
+func (c *Client) Flagged(ctx context.Context, roomID string, moderatorID string, initialID int64, limit int) ([]*models.Message, error) {
+	query := url.Values{}
+	if initialID != 0 {
+		query.Set("initial_id", strconv.FormatInt(initialID, 10))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var messages []*models.Message
+	return messages, c.do(ctx, http.MethodGet, roomPath(roomID)+"/flagged", query, moderatorID, nil, &messages)
+}
+
+// ClearFlags marks flagged message as reviewed
+func (c *Client) ClearFlags(ctx context.Context, roomID string, messageID int64, moderatorID string) error {
+	return c.do(ctx, http.MethodDelete, messagePath(roomID, messageID)+"/flags", nil, moderatorID, nil, nil)
+}
+
+type reportBody struct {
+	Reason string `json:"reason"`
+}
+
+type resolveBody struct {
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ReportMessage reports message to moderators on behalf of userID
+func (c *Client) ReportMessage(ctx context.Context, roomID string, messageID int64, userID string, reason string) (*models.Report, error) {
+	report := new(models.Report)
+	return report, c.do(ctx, http.MethodPost, messagePath(roomID, messageID)+"/report", nil, userID, &reportBody{Reason: reason}, report)
+}
+
+// Reports returns page of room reports with reported messages, newest first, starting before beforeID when it is set.
+// Empty status lists open reports
+func (c *Client) Reports(ctx context.Context, roomID string, moderatorID string, status string, beforeID string, limit int) ([]*models.Report, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+	if beforeID != "" {
+		query.Set("before", beforeID)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var reports []*models.Report
+	return reports, c.do(ctx, http.MethodGet, roomPath(roomID)+"/reports", query, moderatorID, nil, &reports)
+}
+
+// ResolveReport applies action to reported message and resolves all its open reports,
+// reason is used for ban_sender action only
+func (c *Client) ResolveReport(ctx context.Context, roomID string, reportID string, moderatorID string, action string, reason string) (*models.Report, error) {
+	report := new(models.Report)
+	return report, c.do(ctx, http.MethodPost, roomPath(roomID)+"/reports/"+url.PathEscape(reportID)+"/resolve", nil, moderatorID, &resolveBody{Action: action, Reason: reason}, report)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+type UpdatePermissionsRequest struct {
+	AddPermissions    []string `json:"add_permissions,omitempty"`
+	RemovePermissions []string `json:"remove_permissions,omitempty"`
+}
+
+type AssignRoleRequest struct {
+	Name   string `json:"name"`
+	RoomID string `json:"room_id,omitempty"`
+}
+
+func (c *Client) CreateRole(ctx context.Context, role *models.Role) error {
+	return c.do(ctx, http.MethodPost, "/roles", nil, "", role, nil)
+}
+
+func (c *Client) Roles(ctx context.Context) ([]*models.Role, error) {
+	var roles []*models.Role
+	return roles, c.do(ctx, http.MethodGet, "/roles", nil, "", nil, &roles)
+}
+
+func (c *Client) DeleteRole(ctx context.Context, name string, scope string) error {
+	return c.do(ctx, http.MethodDelete, rolePath(name, scope), nil, "", nil, nil)
+}
+
+func (c *Client) RolePermissions(ctx context.Context, name string, scope string) ([]string, error) {
+	var permissions []string
+	return permissions, c.do(ctx, http.MethodGet, rolePath(name, scope)+"/permissions", nil, "", nil, &permissions)
+}
+
+func (c *Client) UpdateRolePermissions(ctx context.Context, name string, scope string, req *UpdatePermissionsRequest) error {
+	return c.do(ctx, http.MethodPut, rolePath(name, scope)+"/permissions", nil, "", req, nil)
+}
+
+// AssignRole gives user global role or room role when roomID is set
+func (c *Client) AssignRole(ctx context.Context, userID string, name string, roomID string) error {
+	return c.do(ctx, http.MethodPut, "/users/"+url.PathEscape(userID)+"/roles", nil, "", &AssignRoleRequest{Name: name, RoomID: roomID}, nil)
+}
+
+func (c *Client) UserRoles(ctx context.Context, userID string) ([]*models.UserRole, error) {
+	var roles []*models.UserRole
+	return roles, c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/roles", nil, "", nil, &roles)
+}
+
+// RemoveRole removes user's global role or room role when roomID is set
+func (c *Client) RemoveRole(ctx context.Context, userID string, roomID string) error {
+	query := url.Values{}
+	if roomID != "" {
+		query.Set("room_id", roomID)
+	}
+	return c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(userID)+"/roles", query, "", nil, nil)
+}
+
+func rolePath(name string, scope string) string {
+	return "/roles/" + url.PathEscape(name) + "/scope/" + url.PathEscape(scope)
+}
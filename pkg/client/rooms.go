@@ -0,0 +1,185 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+type CreateRoomRequest struct {
+	Name                          string      `json:"name"`
+	Private                       bool        `json:"private,omitempty"`
+	PushNotificationTitleOverride string      `json:"push_notification_title_override,omitempty"`
+	CustomData                    interface{} `json:"custom_data,omitempty"`
+	UserIDs                       []string    `json:"user_ids,omitempty"`
+}
+
+type UpdateRoomRequest struct {
+	Name                          string      `json:"name,omitempty"`
+	Private                       *bool       `json:"private,omitempty"`
+	PushNotificationTitleOverride *string     `json:"push_notification_title_override,omitempty"`
+	CustomData                    interface{} `json:"custom_data,omitempty"`
+}
+
+type membershipRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+type joinRequest struct {
+	RoomID string `json:"room_id"`
+}
+
+// CreateRoom creates room on behalf of creatorID who becomes its member
+func (c *Client) CreateRoom(ctx context.Context, creatorID string, req *CreateRoomRequest) (*models.Room, error) {
+	room := new(models.Room)
+	return room, c.do(ctx, http.MethodPost, "/rooms", nil, creatorID, req, room)
+}
+
+// DirectMessageRoom returns direct message room of userID and otherID, creating it when missing
+func (c *Client) DirectMessageRoom(ctx context.Context, userID string, otherID string) (*models.Room, error) {
+	room := new(models.Room)
+	return room, c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(userID)+"/dm/"+url.PathEscape(otherID), nil, userID, nil, room)
+}
+
+// GroupDirectMessageRoom returns direct message room of userID and otherIDs, the same members always get the same room
+func (c *Client) GroupDirectMessageRoom(ctx context.Context, userID string, otherIDs []string) (*models.Room, error) {
+	room := new(models.Room)
+	return room, c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(userID)+"/dm", nil, userID, &membershipRequest{UserIDs: otherIDs}, room)
+}
+
+func (c *Client) GetRoom(ctx context.Context, roomID string) (*models.Room, error) {
+	room := new(models.Room)
+	return room, c.do(ctx, http.MethodGet, roomPath(roomID), nil, "", nil, room)
+}
+
+func (c *Client) UpdateRoom(ctx context.Context, roomID string, req *UpdateRoomRequest) error {
+	return c.do(ctx, http.MethodPut, roomPath(roomID), nil, "", req, nil)
+}
+
+func (c *Client) DeleteRoom(ctx context.Context, roomID string) error {
+	return c.do(ctx, http.MethodDelete, roomPath(roomID), nil, "", nil, nil)
+}
+
+func (c *Client) JoinedRooms(ctx context.Context, userID string) ([]*models.Room, error) {
+	var rooms []*models.Room
+	return rooms, c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/joined_rooms", nil, userID, nil, &rooms)
+}
+
+func (c *Client) JoinableRooms(ctx context.Context, userID string) ([]*models.Room, error) {
+	var rooms []*models.Room
+	return rooms, c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/joinable_rooms", nil, userID, nil, &rooms)
+}
+
+func (c *Client) AddUsersToRoom(ctx context.Context, roomID string, userIDs []string) error {
+	return c.do(ctx, http.MethodPut, roomPath(roomID)+"/users/add", nil, "", &membershipRequest{UserIDs: userIDs}, nil)
+}
+
+func (c *Client) RemoveUsersFromRoom(ctx context.Context, roomID string, userIDs []string) error {
+	return c.do(ctx, http.MethodPut, roomPath(roomID)+"/users/remove", nil, "", &membershipRequest{UserIDs: userIDs}, nil)
+}
+
+func (c *Client) JoinRoom(ctx context.Context, userID string, roomID string) error {
+	return c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(userID)+"/join", nil, userID, &joinRequest{RoomID: roomID}, nil)
+}
+
+func (c *Client) LeaveRoom(ctx context.Context, userID string, roomID string) error {
+	return c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(userID)+"/leave", nil, userID, &joinRequest{RoomID: roomID}, nil)
+}
+
+// ArchiveRoom makes room read only on behalf of userID, who needs room:archive permission
+func (c *Client) ArchiveRoom(ctx context.Context, roomID string, userID string) error {
+	return c.do(ctx, http.MethodPut, roomPath(roomID)+"/archive", nil, userID, nil, nil)
+}
+
+func (c *Client) UnarchiveRoom(ctx context.Context, roomID string, userID string) error {
+	return c.do(ctx, http.MethodDelete, roomPath(roomID)+"/archive", nil, userID, nil, nil)
+}
+
+// SearchRooms returns public rooms whose name contains name ignoring case, archived ones included
+func (c *Client) SearchRooms(ctx context.Context, name string, limit int) ([]*models.Room, error) {
+	query := url.Values{"name": {name}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var rooms []*models.Room
+	return rooms, c.do(ctx, http.MethodGet, "/rooms", query, "", nil, &rooms)
+}
+
+// Rooms iterates over all rooms of instance, archived ones too, including private ones when includePrivate is set
+func (c *Client) Rooms(pageSize int, includePrivate bool) *RoomIterator {
+	return &RoomIterator{c: c, pageSize: pageSize, includePrivate: includePrivate}
+}
+
+type RoomIterator struct {
+	c              *Client
+	pageSize       int
+	includePrivate bool
+	fromID         string
+	buf            []*models.Room
+	cur            *models.Room
+	err            error
+	done           bool
+}
+
+func (it *RoomIterator) Next(ctx context.Context) bool {
+	if len(it.buf) == 0 && !it.done && it.err == nil {
+		query := url.Values{}
+		if it.pageSize > 0 {
+			query.Set("limit", strconv.Itoa(it.pageSize))
+		}
+		if it.fromID != "" {
+			query.Set("from_id", it.fromID)
+		}
+		if it.includePrivate {
+			query.Set("include_private", "true")
+		}
+		query.Set("include_archived", "true")
+		it.err = it.c.do(ctx, http.MethodGet, "/rooms", query, "", nil, &it.buf)
+		if len(it.buf) == 0 {
+			it.done = true
+		} else {
+			it.fromID = it.buf[len(it.buf)-1].ID.Hex()
+		}
+	}
+	if len(it.buf) == 0 {
+		return false
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+func (it *RoomIterator) Room() *models.Room {
+	return it.cur
+}
+
+func (it *RoomIterator) Err() error {
+	return it.err
+}
+
+func roomPath(roomID string) string {
+	return "/rooms/" + url.PathEscape(roomID)
+}
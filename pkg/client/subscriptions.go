@@ -0,0 +1,164 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	methodSubscribe = "SUBSCRIBE"
+	eventReconnect  = "reconnect"
+)
+
+// Event is an item of room or user stream
+type Event struct {
+	Name      string          `json:"event_name"`
+	Data      json.RawMessage `json:"data"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// Subscription delivers events until context is cancelled, Close is called or server rejects subscription.
+// Dropped connections and server shutdowns are handled by reconnecting
+type Subscription struct {
+	events chan *Event
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	err    error
+}
+
+// Events returns channel that is closed when subscription ends
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Err returns error that ended subscription, if any
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+// SubscribeRoom streams events of room as seen by userID
+func (c *Client) SubscribeRoom(ctx context.Context, roomID string, userID string) *Subscription {
+	return c.subscribe(ctx, roomPath(roomID), userID)
+}
+
+// SubscribeUser streams events addressed to user
+func (c *Client) SubscribeUser(ctx context.Context, userID string) *Subscription {
+	return c.subscribe(ctx, "/users/"+url.PathEscape(userID), userID)
+}
+
+func (c *Client) subscribe(ctx context.Context, path string, userID string) *Subscription {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscription{
+		events: make(chan *Event),
+		cancel: cancel,
+	}
+	go func() {
+		defer close(s.events)
+		attempt := 0
+		for {
+			received, wait, err := c.stream(ctx, path, userID, s.events)
+			if ctx.Err() != nil {
+				return
+			}
+			if e, ok := err.(*Error); ok {
+				switch {
+				case e.StatusCode == http.StatusUnauthorized && attempt == 0:
+					c.tokens.invalidate(userID)
+				case e.StatusCode < http.StatusInternalServerError && e.StatusCode != http.StatusTooManyRequests:
+					s.mu.Lock()
+					s.err = err
+					s.mu.Unlock()
+					return
+				}
+			}
+			if received {
+				attempt = 0
+			}
+			attempt++
+			if wait <= 0 {
+				wait = c.backoff(attempt)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+	return s
+}
+
+// stream reads one connection. It reports whether any event was received and reconnect delay suggested by server
+func (c *Client) stream(ctx context.Context, path string, userID string, events chan<- *Event) (bool, time.Duration, error) {
+	tok, err := c.tokens.Token(ctx, userID)
+	if err != nil {
+		return false, 0, err
+	}
+	req, err := http.NewRequest(methodSubscribe, c.baseURL+path, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, retryAfter(resp), decode(resp, nil)
+	}
+	defer resp.Body.Close()
+
+	received := false
+	dec := json.NewDecoder(resp.Body)
+	for {
+		event := new(Event)
+		if err := dec.Decode(event); err != nil {
+			return received, 0, err
+		}
+		received = true
+		if event.Name == eventReconnect {
+			hint := struct {
+				RetryAfterMs int64 `json:"retry_after_ms"`
+			}{}
+			_ = json.Unmarshal(event.Data, &hint)
+			return received, time.Duration(hint.RetryAfterMs) * time.Millisecond, nil
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return received, 0, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// refreshBefore is how long before expiration cached token is replaced
+const refreshBefore = time.Minute
+
+type TokenRequest struct {
+	GrantType string `json:"grant_type"`
+	UserID    string `json:"user_id,omitempty"`
+	SU        bool   `json:"su,omitempty"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token issues access token for user, e.g. to hand it to end user application. Empty userID with su issues server token
+func (c *Client) Token(ctx context.Context, userID string, su bool) (*TokenResponse, error) {
+	resp := new(TokenResponse)
+	auth := func(req *http.Request) error {
+		req.SetBasicAuth(c.tokens.keyID, c.tokens.secret)
+		return nil
+	}
+	body, err := jsonBody(&TokenRequest{GrantType: "client_credentials", UserID: userID, SU: su})
+	if err != nil {
+		return nil, err
+	}
+	return resp, c.roundTrip(ctx, http.MethodPost, "/token", nil, body, auth, resp)
+}
+
+type cachedToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// tokenSource keeps server tokens per acted user and refreshes them before expiration
+type tokenSource struct {
+	c      *Client
+	keyID  string
+	secret string
+	mu     sync.Mutex
+	cache  map[string]*cachedToken
+}
+
+func newTokenSource(c *Client, keyID string, secret string) *tokenSource {
+	return &tokenSource{
+		c:      c,
+		keyID:  keyID,
+		secret: secret,
+		cache:  map[string]*cachedToken{},
+	}
+}
+
+func (t *tokenSource) Token(ctx context.Context, userID string) (string, error) {
+	t.mu.Lock()
+	cached, ok := t.cache[userID]
+	t.mu.Unlock()
+	if ok && time.Until(cached.expiresAt) > refreshBefore {
+		return cached.token, nil
+	}
+	resp, err := t.c.Token(ctx, userID, true)
+	if err != nil {
+		return "", err
+	}
+	cached = &cachedToken{
+		token:     resp.AccessToken,
+		expiresAt: time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+	t.mu.Lock()
+	t.cache[userID] = cached
+	t.mu.Unlock()
+	return cached.token, nil
+}
+
+func (t *tokenSource) invalidate(userID string) {
+	t.mu.Lock()
+	delete(t.cache, userID)
+	t.mu.Unlock()
+}
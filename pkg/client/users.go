@@ -0,0 +1,120 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+type CreateUserRequest struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	AvatarURL  string      `json:"avatar_url,omitempty"`
+	CustomData interface{} `json:"custom_data,omitempty"`
+}
+
+type UpdateUserRequest struct {
+	Name       string      `json:"name,omitempty"`
+	AvatarURL  string      `json:"avatar_url,omitempty"`
+	CustomData interface{} `json:"custom_data,omitempty"`
+}
+
+func (c *Client) CreateUser(ctx context.Context, req *CreateUserRequest) (*models.User, error) {
+	u := new(models.User)
+	return u, c.do(ctx, http.MethodPost, "/users", nil, "", req, u)
+}
+
+func (c *Client) CreateUsers(ctx context.Context, req []*CreateUserRequest) ([]*models.User, error) {
+	var users []*models.User
+	return users, c.do(ctx, http.MethodPost, "/batch_users", nil, "", req, &users)
+}
+
+func (c *Client) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	u := new(models.User)
+	return u, c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID), nil, "", nil, u)
+}
+
+func (c *Client) GetUsersByIDs(ctx context.Context, userIDs []string) ([]*models.User, error) {
+	var users []*models.User
+	return users, c.do(ctx, http.MethodGet, "/users_by_ids", url.Values{"id": userIDs}, "", nil, &users)
+}
+
+func (c *Client) UpdateUser(ctx context.Context, userID string, req *UpdateUserRequest) error {
+	return c.do(ctx, http.MethodPut, "/users/"+url.PathEscape(userID), nil, "", req, nil)
+}
+
+func (c *Client) DeleteUser(ctx context.Context, userID string) error {
+	return c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(userID), nil, "", nil, nil)
+}
+
+// Users iterates over all users in order of creation, fetching pageSize users per request
+func (c *Client) Users(pageSize int) *UserIterator {
+	return &UserIterator{c: c, pageSize: pageSize}
+}
+
+type UserIterator struct {
+	c        *Client
+	pageSize int
+	fromTS   time.Time
+	buf      []*models.User
+	cur      *models.User
+	err      error
+	done     bool
+}
+
+// Next advances iterator, it returns false when there are no more users or on error
+func (it *UserIterator) Next(ctx context.Context) bool {
+	if len(it.buf) == 0 && !it.done && it.err == nil {
+		query := url.Values{}
+		if it.pageSize > 0 {
+			query.Set("limit", strconv.Itoa(it.pageSize))
+		}
+		if !it.fromTS.IsZero() {
+			query.Set("from_ts", it.fromTS.Format(time.RFC3339Nano))
+		}
+		it.err = it.c.do(ctx, http.MethodGet, "/users", query, "", nil, &it.buf)
+		if len(it.buf) == 0 {
+			it.done = true
+		} else {
+			it.fromTS = it.buf[len(it.buf)-1].CreatedAt.Time()
+		}
+	}
+	if len(it.buf) == 0 {
+		return false
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+func (it *UserIterator) User() *models.User {
+	return it.cur
+}
+
+func (it *UserIterator) Err() error {
+	return it.err
+}
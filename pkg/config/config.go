@@ -22,19 +22,97 @@ THE SOFTWARE.
 package config
 
 import (
-	"github.com/caarlos0/env"
+	"fmt"
+	"io"
+	"os"
+	"time"
 )
 
-//Config stores env variables
+//Config stores application settings. Values are layered: defaults, config file, env variables and command line flags
 type Config struct {
-	Listen          string `env:"LISTEN" envDefault:":3000"`
-	MongoConnection string `env:"MONGO_CONNECTION" envDefault:"mongodb://localhost:27017/"`
-	MongoName       string `env:"MONGO_DBNAME" envDefault:"chatkit"`
-	Redis           string `env:"REDIS" envDefault:"localhost:6379"`
+	ConfigFile        string        `config:"config" env:"CONFIG_FILE" usage:"path to YAML or TOML config file"`
+	PrintConfig       bool          `config:"print-config" usage:"print effective config with secrets redacted and exit"`
+	Listen            string        `config:"listen" env:"LISTEN" default:":3000"`
+	MongoConnection   string        `config:"mongo_connection" env:"MONGO_CONNECTION" default:"mongodb://localhost:27017/" secret:"true"`
+	MongoName         string        `config:"mongo_dbname" env:"MONGO_DBNAME" default:"chatkit"`
+	AdminToken        string        `config:"admin_token" env:"ADMIN_TOKEN" secret:"true"`
+	Isolation         string        `config:"instance_isolation" env:"INSTANCE_ISOLATION" default:"prefix"`
+	LegacyInstance    string        `config:"legacy_instance" env:"LEGACY_INSTANCE" default:"default" usage:"instance serving data and /api paths of deployments older than instances, empty disables it"`
+	TokenTTL          time.Duration `config:"token_ttl" env:"TOKEN_TTL" default:"24h"`
+	Redis             string        `config:"redis" env:"REDIS" default:"localhost:6379"`
+	RedisPassword     string        `config:"redis_password" env:"REDIS_PASSWORD" secret:"true"`
+	TLSCert           string        `config:"tls_cert" env:"TLS_CERT"`
+	TLSKey            string        `config:"tls_key" env:"TLS_KEY"`
+	TLSClientCA       string        `config:"tls_client_ca" env:"TLS_CLIENT_CA"`
+	TLSReload         time.Duration `config:"tls_reload" env:"TLS_RELOAD" default:"30s"`
+	CORSOrigins       []string      `config:"cors_origins" env:"CORS_ORIGINS"`
+	CORSCredentials   bool          `config:"cors_credentials" env:"CORS_CREDENTIALS"`
+	CORSMaxAge        time.Duration `config:"cors_max_age" env:"CORS_MAX_AGE" default:"10m"`
+	MetricsListen     string        `config:"metrics_listen" env:"METRICS_LISTEN" default:":9100"`
+	HealthTimeout     time.Duration `config:"health_timeout" env:"HEALTH_TIMEOUT" default:"2s"`
+	LogLevel          string        `config:"log_level" env:"LOG_LEVEL" default:"info"`
+	LogFormat         string        `config:"log_format" env:"LOG_FORMAT" default:"json"`
+	ShutdownTimeout   time.Duration `config:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"15s"`
+	ReconnectAfter    time.Duration `config:"reconnect_after" env:"RECONNECT_AFTER" default:"1s"`
+	RetentionInterval time.Duration `config:"retention_interval" env:"RETENTION_INTERVAL" default:"1h" usage:"how often retention policies are applied, 0 disables the worker"`
+	RetentionBatch    int           `config:"retention_batch" env:"RETENTION_BATCH" default:"500" usage:"messages purged per batch"`
+	RetentionPause    time.Duration `config:"retention_pause" env:"RETENTION_PAUSE" default:"200ms" usage:"pause between retention batches"`
+	PinsPerRoom       int           `config:"pins_per_room" env:"PINS_PER_ROOM" default:"50" usage:"maximum number of pinned messages in a room"`
+	SanctionInterval  time.Duration `config:"sanction_interval" env:"SANCTION_INTERVAL" default:"1m" usage:"how often expired bans and mutes are lifted, 0 disables the worker"`
+	FilterHookURL     string        `config:"filter_hook_url" env:"FILTER_HOOK_URL" usage:"moderation service asked about every new or edited message, empty disables the hook"`
+	FilterHookTimeout time.Duration `config:"filter_hook_timeout" env:"FILTER_HOOK_TIMEOUT" default:"2s" usage:"how long to wait for moderation service"`
+	FilterHookFailure string        `config:"filter_hook_failure" env:"FILTER_HOOK_FAILURE" default:"open" usage:"what happens when moderation service fails: open lets messages through, closed rejects them"`
+	ReportThreshold   int           `config:"report_threshold" env:"REPORT_THRESHOLD" default:"3" usage:"open reports that hide message until moderator reviews it, 0 disables hiding"`
+	UnfurlTimeout     time.Duration `config:"unfurl_timeout" env:"UNFURL_TIMEOUT" default:"5s" usage:"how long to fetch page for link preview, 0 disables link previews"`
+	UnfurlMaxSize     int64         `config:"unfurl_max_size" env:"UNFURL_MAX_SIZE" default:"1048576" usage:"bytes of page read looking for link preview metadata"`
+	UnfurlCacheTTL    time.Duration `config:"unfurl_cache_ttl" env:"UNFURL_CACHE_TTL" default:"24h" usage:"how long link previews are kept in redis"`
+	UnfurlConcurrency int           `config:"unfurl_concurrency" env:"UNFURL_CONCURRENCY" default:"16" usage:"messages unfurled at the same time, links of messages sent while all are busy get no preview"`
+	NotifyQueueLimit  int           `config:"notify_queue_limit" env:"NOTIFY_QUEUE_LIMIT" default:"10000" usage:"notifications kept per instance and priority until delivery workers pop them, oldest are dropped first"`
 }
 
-//New instantiates logger object
+//New loads config from file, environment and os.Args
 func New() (*Config, error) {
-	var cfg Config
-	return &cfg, env.Parse(&cfg)
+	return Load(os.Args[1:])
+}
+
+//Load loads config layers and validates the result
+func Load(args []string) (*Config, error) {
+	cfg := new(Config)
+	if err := applyDefaults(cfg); err != nil {
+		return nil, err
+	}
+	flags, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+	// Config file location itself may come from env or flags, so resolve it first
+	file := os.Getenv("CONFIG_FILE")
+	if v, ok := flags["config"]; ok {
+		file = v
+	}
+	if file != "" {
+		if err := applyFile(cfg, file); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", file, err)
+		}
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+	if err := applyFlags(cfg, flags); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+//Print writes effective config as YAML with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	b, err := c.redacted()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
 }
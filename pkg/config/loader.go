@@ -0,0 +1,262 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/caarlos0/env"
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "<redacted>"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fields iterates over config fields that have a key
+func fields(cfg *Config, fn func(field reflect.StructField, value reflect.Value) error) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("config") == "" {
+			continue
+		}
+		if err := fn(t.Field(i), v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyDefaults(cfg *Config) error {
+	return fields(cfg, func(field reflect.StructField, value reflect.Value) error {
+		def, ok := field.Tag.Lookup("default")
+		if !ok {
+			return nil
+		}
+		return setValue(value, def)
+	})
+}
+
+func applyFile(cfg *Config, file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return fmt.Errorf("unsupported format, use .yaml, .yml or .toml")
+	}
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	err = fields(cfg, func(field reflect.StructField, value reflect.Value) error {
+		key := field.Tag.Get("config")
+		known[key] = true
+		v, ok := raw[key]
+		if !ok {
+			return nil
+		}
+		if err := setValue(value, fileValue(v)); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key := range raw {
+		if !known[key] {
+			return fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return nil
+}
+
+// fileValue converts decoded file value to the same string form env variables use
+func fileValue(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// applyEnv reads env variables and secrets mounted as files (<NAME>_FILE)
+func applyEnv(cfg *Config) error {
+	if err := env.Parse(cfg); err != nil {
+		return err
+	}
+	return fields(cfg, func(field reflect.StructField, value reflect.Value) error {
+		name := field.Tag.Get("env")
+		if name == "" || field.Tag.Get("secret") != "true" {
+			return nil
+		}
+		file := os.Getenv(name + "_FILE")
+		if file == "" {
+			return nil
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		return setValue(value, strings.TrimRight(string(b), "\r\n"))
+	})
+}
+
+// parseFlags returns only flags explicitly passed in args
+func parseFlags(args []string) (map[string]string, error) {
+	fs := flag.NewFlagSet("chatcloud", flag.ContinueOnError)
+	values := map[string]*string{}
+	bools := map[string]*bool{}
+	err := fields(new(Config), func(field reflect.StructField, value reflect.Value) error {
+		name := strings.Replace(field.Tag.Get("config"), "_", "-", -1)
+		usage := fmt.Sprintf("overrides %s", field.Tag.Get("config"))
+		if env := field.Tag.Get("env"); env != "" {
+			usage = fmt.Sprintf("overrides %s and env %s", field.Tag.Get("config"), env)
+		}
+		if u, ok := field.Tag.Lookup("usage"); ok {
+			usage = u
+		}
+		if value.Kind() == reflect.Bool {
+			bools[name] = fs.Bool(name, false, usage)
+			return nil
+		}
+		values[name] = fs.String(name, field.Tag.Get("default"), usage)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	set := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		key := strings.Replace(f.Name, "-", "_", -1)
+		if b, ok := bools[f.Name]; ok {
+			set[key] = strconv.FormatBool(*b)
+			return
+		}
+		set[key] = *values[f.Name]
+	})
+	return set, nil
+}
+
+func applyFlags(cfg *Config, flags map[string]string) error {
+	return fields(cfg, func(field reflect.StructField, value reflect.Value) error {
+		key := strings.Replace(field.Tag.Get("config"), "-", "_", -1)
+		v, ok := flags[key]
+		if !ok {
+			return nil
+		}
+		if err := setValue(value, v); err != nil {
+			return fmt.Errorf("flag -%s: %w", field.Tag.Get("config"), err)
+		}
+		return nil
+	})
+}
+
+func setValue(value reflect.Value, raw string) error {
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(i)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", value.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+func (c *Config) redacted() ([]byte, error) {
+	out := yaml.MapSlice{}
+	err := fields(c, func(field reflect.StructField, value reflect.Value) error {
+		key := field.Tag.Get("config")
+		if key == "config" || key == "print-config" {
+			return nil
+		}
+		var v interface{} = value.Interface()
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		if field.Tag.Get("secret") == "true" && value.Len() > 0 {
+			v = redacted
+		}
+		out = append(out, yaml.MapItem{Key: key, Value: v})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(out)
+}
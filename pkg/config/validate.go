@@ -0,0 +1,152 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+//Validate checks that every setting is usable and reports all problems at once
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	check(validateAddr("listen", c.Listen))
+	check(validateAddr("metrics_listen", c.MetricsListen))
+	check(validateAddr("redis", c.Redis))
+	if _, err := connstring.Parse(c.MongoConnection); err != nil {
+		// connection string may contain credentials, so it is not echoed back
+		errs = append(errs, fmt.Sprintf("mongo_connection: malformed mongo URI: %s", strings.Replace(err.Error(), c.MongoConnection, redacted, -1)))
+	}
+	if c.MongoName == "" {
+		errs = append(errs, "mongo_dbname: must not be empty")
+	}
+	if c.Isolation != "prefix" && c.Isolation != "database" {
+		errs = append(errs, fmt.Sprintf("instance_isolation: %q is not one of prefix, database", c.Isolation))
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Sprintf("log_level: %s", err))
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		errs = append(errs, fmt.Sprintf("log_format: %q is not one of json, text", c.LogFormat))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, "tls_cert, tls_key: must be set together")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		errs = append(errs, "tls_client_ca: requires tls_cert and tls_key")
+	}
+	if c.TLSReload <= 0 {
+		errs = append(errs, "tls_reload: must be positive")
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" && c.CORSCredentials {
+			errs = append(errs, "cors_origins: wildcard origin can't be used with cors_credentials")
+		}
+	}
+	if c.CORSMaxAge < 0 {
+		errs = append(errs, "cors_max_age: must not be negative")
+	}
+	if c.TokenTTL <= 0 {
+		errs = append(errs, "token_ttl: must be positive")
+	}
+	if c.HealthTimeout <= 0 {
+		errs = append(errs, "health_timeout: must be positive")
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, "shutdown_timeout: must be positive")
+	}
+	if c.ReconnectAfter < 0 {
+		errs = append(errs, "reconnect_after: must not be negative")
+	}
+	if c.RetentionInterval < 0 {
+		errs = append(errs, "retention_interval: must not be negative")
+	}
+	if c.RetentionBatch <= 0 {
+		errs = append(errs, "retention_batch: must be positive")
+	}
+	if c.RetentionPause < 0 {
+		errs = append(errs, "retention_pause: must not be negative")
+	}
+	if c.PinsPerRoom <= 0 {
+		errs = append(errs, "pins_per_room: must be positive")
+	}
+	if c.SanctionInterval < 0 {
+		errs = append(errs, "sanction_interval: must not be negative")
+	}
+	if c.FilterHookURL != "" {
+		if u, err := url.Parse(c.FilterHookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("filter_hook_url: %q is not http or https URL", c.FilterHookURL))
+		}
+	}
+	if c.FilterHookTimeout <= 0 {
+		errs = append(errs, "filter_hook_timeout: must be positive")
+	}
+	if c.FilterHookFailure != "open" && c.FilterHookFailure != "closed" {
+		errs = append(errs, fmt.Sprintf("filter_hook_failure: %q is not one of open, closed", c.FilterHookFailure))
+	}
+	if c.ReportThreshold < 0 {
+		errs = append(errs, "report_threshold: must not be negative")
+	}
+	if c.UnfurlTimeout < 0 {
+		errs = append(errs, "unfurl_timeout: must not be negative")
+	}
+	if c.UnfurlMaxSize <= 0 {
+		errs = append(errs, "unfurl_max_size: must be positive")
+	}
+	if c.UnfurlCacheTTL <= 0 {
+		errs = append(errs, "unfurl_cache_ttl: must be positive")
+	}
+	if c.UnfurlConcurrency <= 0 {
+		errs = append(errs, "unfurl_concurrency: must be positive")
+	}
+	if c.NotifyQueueLimit <= 0 {
+		errs = append(errs, "notify_queue_limit: must be positive")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func validateAddr(key string, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: bad address %q: %s", key, addr, err)
+	}
+	if port == "" {
+		return fmt.Errorf("%s: bad address %q: missing port", key, addr)
+	}
+	if strings.ContainsAny(host, " /") {
+		return fmt.Errorf("%s: bad address %q: invalid host", key, addr)
+	}
+	return nil
+}
@@ -26,14 +26,52 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 func New(connection string, database string) (*mongo.Database, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connection))
 	if err != nil {
 		return nil, err
 	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return nil, err
+	}
 	return client.Database(database), nil
 }
+
+// Namespace resolves collections of one instance, either in its own database or by name prefix
+type Namespace struct {
+	database *mongo.Database
+	prefix   string
+}
+
+func NewNamespace(database *mongo.Database, prefix string) Namespace {
+	return Namespace{database: database, prefix: prefix}
+}
+
+func (n Namespace) Collection(name string) *mongo.Collection {
+	return n.database.Collection(n.prefix + name)
+}
+
+// Bucket opens GridFS bucket of namespace, its collections are <name>.files and <name>.chunks
+func (n Namespace) Bucket(name string) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(n.database, options.GridFSBucket().SetName(n.prefix+name))
+}
+
+// Drop removes given collections of namespace or whole database when namespace is not prefixed
+func (n Namespace) Drop(ctx context.Context, names ...string) error {
+	if n.prefix == "" {
+		return n.database.Drop(ctx)
+	}
+	for _, name := range names {
+		if err := n.Collection(name).Drop(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -30,6 +30,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/x/bsonx"
+
+	"github.com/neonxp/chatcloud/pkg/metrics"
 )
 
 type Manager struct {
@@ -42,54 +44,139 @@ type Index struct {
 }
 
 func NewManager(collection *mongo.Collection, indexes []Index) (*Manager, error) {
+	m := &Manager{collection: collection}
 	if indexes != nil {
-		indexModels := make([]mongo.IndexModel, 0, len(indexes))
-
-		for _, index := range indexes {
-			keys := bsonx.Doc{}
-			for _, field := range index.Fields {
-				keys.Append(field, bsonx.Int32(-1))
-			}
-			indexModels = append(indexModels, mongo.IndexModel{
-				Keys: keys,
-				Options: (options.Index()).
-					SetBackground(true).
-					SetSparse(true).
-					SetUnique(index.IsUnique),
-			})
-		}
-		_, err := collection.Indexes().CreateMany(context.Background(), indexModels)
-		if err != nil {
+		if err := m.EnsureIndexes(indexes); err != nil {
 			return nil, err
 		}
 	}
-	return &Manager{collection: collection}, nil
+	return m, nil
+}
+
+// EnsureIndexes creates missing indexes, existing ones are left untouched
+func (m *Manager) EnsureIndexes(indexes []Index) error {
+	indexModels := make([]mongo.IndexModel, 0, len(indexes))
+	for _, index := range indexes {
+		keys := bsonx.Doc{}
+		for _, field := range index.Fields {
+			keys = keys.Append(field, bsonx.Int32(-1))
+		}
+		indexModels = append(indexModels, mongo.IndexModel{
+			Keys: keys,
+			Options: (options.Index()).
+				SetBackground(true).
+				SetSparse(true).
+				SetUnique(index.IsUnique),
+		})
+	}
+	_, err := m.collection.Indexes().CreateMany(context.Background(), indexModels)
+	return err
 }
 
 func (m *Manager) Add(s interface{}) (interface{}, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
 	r, err := m.collection.InsertOne(ctx, s)
+	m.observe("insert", start, err)
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
 	return r.InsertedID, nil
 }
 
-func (m *Manager) Update(ID primitive.ObjectID, s interface{}) error {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+func (m *Manager) Update(ID interface{}, s interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
 	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": ID}, bson.M{"$set": s})
+	m.observe("update", start, err)
 	return err
 }
 
-func (m *Manager) Remove(ID primitive.ObjectID) error {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+func (m *Manager) Remove(ID interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
 	_, err := m.collection.DeleteOne(ctx, bson.M{"_id": ID})
+	m.observe("delete", start, err)
 	return err
 }
 
+// Upsert sets fields of document matching filter, inserting it when missing
+func (m *Manager) Upsert(filter bson.M, s interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := m.collection.UpdateOne(ctx, filter, bson.M{"$set": s}, options.Update().SetUpsert(true))
+	m.observe("upsert", start, err)
+	return err
+}
+
+// UpdateMany applies update operators to every document matching filter
+func (m *Manager) UpdateMany(filter bson.M, update bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	r, err := m.collection.UpdateMany(ctx, filter, update)
+	m.observe("update_many", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return r.ModifiedCount, nil
+}
+
+func (m *Manager) RemoveMany(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	r, err := m.collection.DeleteMany(ctx, filter)
+	m.observe("delete_many", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return r.DeletedCount, nil
+}
+
+func (m *Manager) Count(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	n, err := m.collection.CountDocuments(ctx, filter)
+	m.observe("count", start, err)
+	return n, err
+}
+
+// Distinct returns unique values of field among documents matching filter
+func (m *Manager) Distinct(field string, filter bson.M) ([]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	values, err := m.collection.Distinct(ctx, field, filter)
+	m.observe("distinct", start, err)
+	return values, err
+}
+
+// Aggregate runs pipeline and decodes all resulting documents into results slice
+func (m *Manager) Aggregate(pipeline []bson.M, results interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	cur, err := m.collection.Aggregate(ctx, pipeline)
+	m.observe("aggregate", start, err)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	return cur.All(ctx, results)
+}
+
 func (m *Manager) FindOne(filter bson.M, v interface{}) error {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
 	result := m.collection.FindOne(ctx, filter)
+	m.observe("find_one", start, result.Err())
 	if err := result.Err(); err != nil {
 		return err
 	}
@@ -100,7 +187,9 @@ func (m *Manager) FindOne(filter bson.M, v interface{}) error {
 }
 
 func (m *Manager) Find(filter bson.M, sort map[string]int, pagination Pagination) (*mongo.Cursor, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
 	result, err := m.collection.Find(
 		ctx,
 		filter,
@@ -109,6 +198,7 @@ func (m *Manager) Find(filter bson.M, sort map[string]int, pagination Pagination
 			SetSkip(pagination.Offset).
 			SetLimit(pagination.Limit),
 	)
+	m.observe("find", start, err)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -119,6 +209,15 @@ func (m *Manager) Find(filter bson.M, sort map[string]int, pagination Pagination
 	return result, nil
 }
 
+// observe records operation latency, not found results are not counted as errors
+func (m *Manager) observe(operation string, start time.Time, err error) {
+	collection := m.collection.Name()
+	metrics.MongoDuration.WithLabelValues(collection, operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != mongo.ErrNoDocuments {
+		metrics.MongoErrors.WithLabelValues(collection, operation).Inc()
+	}
+}
+
 type Pagination struct {
 	Offset int64
 	Limit  int64
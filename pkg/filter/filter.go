@@ -0,0 +1,294 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package filter checks text parts of messages against word lists, regular expressions
+// and external moderation hook before messages are stored
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/neonxp/chatcloud/pkg/metrics"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+// Scopes of filters, used as metrics label
+const (
+	ScopeInstance = "instance"
+	ScopeRoom     = "room"
+	ScopeHook     = "hook"
+)
+
+// patternCacheSize bounds number of compiled regular expressions kept between messages
+const patternCacheSize = 1000
+
+// ErrUnavailable is returned when moderation hook failed and it is set to fail closed
+var ErrUnavailable = errors.New("moderation service is unavailable")
+
+// RejectedError is returned when message is rejected by rule or moderation hook
+type RejectedError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("message rejected by %s filter: %s", e.Rule, e.Reason)
+	}
+	return fmt.Sprintf("message rejected by %s filter", e.Rule)
+}
+
+// Message is what filters see, the hook receives it as JSON
+type Message struct {
+	InstanceID string               `json:"instance_id"`
+	RoomID     string               `json:"room_id"`
+	UserID     string               `json:"user_id"`
+	MessageID  int64                `json:"message_id,omitempty"` // Set when message is edited.
+	Parts      []models.MessagePart `json:"parts"`
+}
+
+// Rule is compiled filter rule
+type Rule struct {
+	name   string
+	action string
+	words  map[string]bool
+	re     *regexp.Regexp
+}
+
+var (
+	patternsMu sync.Mutex
+	patterns   = map[string]*regexp.Regexp{}
+)
+
+// Compile checks rules and prepares them for matching, it is used to validate rules before they are saved
+func Compile(rules []models.FilterRule) ([]*Rule, error) {
+	compiled := make([]*Rule, 0, len(rules))
+	for _, r := range rules {
+		rule, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+func compile(r models.FilterRule) (*Rule, error) {
+	switch r.Action {
+	case models.FilterReject, models.FilterMask, models.FilterFlag:
+	default:
+		return nil, fmt.Errorf("unknown action %q", r.Action)
+	}
+	if (len(r.Words) == 0) == (r.Pattern == "") {
+		return nil, errors.New("either words or pattern must be set")
+	}
+	rule := &Rule{name: r.Name, action: r.Action}
+	if r.Pattern != "" {
+		re, err := compilePattern(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rule.re = re
+		return rule, nil
+	}
+	rule.words = make(map[string]bool, len(r.Words))
+	for _, w := range r.Words {
+		rule.words[strings.ToLower(w)] = true
+	}
+	return rule, nil
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	if re, ok := patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) >= patternCacheSize {
+		patterns = map[string]*regexp.Regexp{}
+	}
+	patterns[pattern] = re
+	return re, nil
+}
+
+// Match returns byte ranges of text matched by rule. Words match whole words of letters and digits ignoring case
+func (r *Rule) Match(text string) [][]int {
+	if r.re != nil {
+		return r.re.FindAllStringIndex(text, -1)
+	}
+	var matches [][]int
+	start := -1
+	check := func(end int) {
+		if start >= 0 && r.words[strings.ToLower(text[start:end])] {
+			matches = append(matches, []int{start, end})
+		}
+		start = -1
+	}
+	for i, c := range text {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		check(i)
+	}
+	check(len(text))
+	return matches
+}
+
+// Chain applies instance rules, then room rules, then asks moderation hook when it is configured
+type Chain struct {
+	hook *Hook
+}
+
+// NewChain creates chain, hook may be nil
+func NewChain(hook *Hook) *Chain {
+	return &Chain{hook: hook}
+}
+
+// Apply checks msg masking matched text in place and returns names of rules that flagged it for review.
+// Parts replaced by moderation hook are checked by rules again. Rejected messages return *RejectedError
+func (c *Chain) Apply(ctx context.Context, msg *Message, instanceRules []models.FilterRule, roomRules []models.FilterRule) ([]string, error) {
+	instance, err := Compile(instanceRules)
+	if err != nil {
+		return nil, err
+	}
+	room, err := Compile(roomRules)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := applyRules(msg.Parts, instance, room)
+	if err != nil || c.hook == nil {
+		return flags, err
+	}
+	verdict, err := c.hook.Check(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if verdict.Action != HookAllow {
+		metrics.FilterHits.WithLabelValues(ScopeHook, verdict.Action).Inc()
+	}
+	switch verdict.Action {
+	case models.FilterReject:
+		return nil, &RejectedError{Rule: ScopeHook, Reason: verdict.Reason}
+	case models.FilterFlag:
+		flags = append(flags, ScopeHook)
+	}
+	if len(verdict.Parts) == 0 {
+		return flags, nil
+	}
+	parts := make([]models.MessagePart, len(verdict.Parts))
+	for i, part := range verdict.Parts {
+		// Rendered markdown and previews are produced by server only, hook can't inject them
+		part.HTML, part.Text, part.Preview = "", "", msg.Parts[i].Preview
+		parts[i] = part
+	}
+	more, err := applyRules(parts, instance, room)
+	if err != nil {
+		return nil, err
+	}
+	msg.Parts = parts
+	return appendNew(flags, more), nil
+}
+
+// applyRules applies instance rules, then room rules to parts
+func applyRules(parts []models.MessagePart, instance []*Rule, room []*Rule) ([]string, error) {
+	var flags []string
+	scopes := []struct {
+		name  string
+		rules []*Rule
+	}{
+		{ScopeInstance, instance},
+		{ScopeRoom, room},
+	}
+	for _, scope := range scopes {
+		for _, rule := range scope.rules {
+			if !rule.apply(parts) {
+				continue
+			}
+			metrics.FilterHits.WithLabelValues(scope.name, rule.action).Inc()
+			switch rule.action {
+			case models.FilterReject:
+				return nil, &RejectedError{Rule: rule.name}
+			case models.FilterFlag:
+				flags = append(flags, rule.name)
+			}
+		}
+	}
+	return flags, nil
+}
+
+func appendNew(flags []string, more []string) []string {
+	for _, f := range more {
+		found := false
+		for _, g := range flags {
+			found = found || f == g
+		}
+		if !found {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+// apply matches rule against text parts, masking them when rule says so, and reports whether anything matched
+func (r *Rule) apply(parts []models.MessagePart) bool {
+	hit := false
+	for i := range parts {
+		if !strings.HasPrefix(parts[i].Type, "text/") {
+			continue
+		}
+		matches := r.Match(parts[i].Content)
+		if len(matches) == 0 {
+			continue
+		}
+		hit = true
+		if r.action == models.FilterMask {
+			parts[i].Content = mask(parts[i].Content, matches)
+		}
+	}
+	return hit
+}
+
+// mask replaces every rune of matched ranges with asterisk
+func mask(text string, matches [][]int) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	last := 0
+	for _, m := range matches {
+		b.WriteString(text[last:m[0]])
+		b.WriteString(strings.Repeat("*", len([]rune(text[m[0]:m[1]]))))
+		last = m[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
@@ -0,0 +1,297 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+func testLogger() logrus.FieldLogger {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return log
+}
+
+// testHook serves verdict to every request after delay
+func testHook(t *testing.T, verdict Verdict, delay time.Duration, failOpen bool) *Hook {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		_ = json.NewEncoder(w).Encode(verdict)
+	}))
+	t.Cleanup(srv.Close)
+	return NewHook(srv.URL, 20*time.Millisecond, failOpen, testLogger())
+}
+
+func textParts(contents ...string) []models.MessagePart {
+	parts := make([]models.MessagePart, 0, len(contents))
+	for _, c := range contents {
+		parts = append(parts, models.MessagePart{Type: "text/plain", Content: c})
+	}
+	return parts
+}
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		rule models.FilterRule
+		text string
+		want [][]int
+	}{
+		{"whole words", models.FilterRule{Words: []string{"bad"}}, "bad badly xbad bad", [][]int{{0, 3}, {15, 18}}},
+		{"ignores case", models.FilterRule{Words: []string{"Bad"}}, "BAD bAd", [][]int{{0, 3}, {4, 7}}},
+		{"punctuation is boundary", models.FilterRule{Words: []string{"bad"}}, "(bad),bad!", [][]int{{1, 4}, {6, 9}}},
+		{"digits are part of word", models.FilterRule{Words: []string{"bad"}}, "bad1 1bad", nil},
+		{"non latin", models.FilterRule{Words: []string{"плохо"}}, "очень ПЛОХО", [][]int{{11, 21}}},
+		{"pattern", models.FilterRule{Pattern: `\d{3}-\d{4}`}, "call 555-1234 now", [][]int{{5, 13}}},
+		{"no match", models.FilterRule{Words: []string{"bad"}}, "good", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Action = models.FilterMask
+			rules, err := Compile([]models.FilterRule{tt.rule})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rules[0].Match(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		rule models.FilterRule
+	}{
+		{"unknown action", models.FilterRule{Words: []string{"bad"}, Action: "drop"}},
+		{"no words or pattern", models.FilterRule{Action: models.FilterMask}},
+		{"both words and pattern", models.FilterRule{Words: []string{"bad"}, Pattern: "bad", Action: models.FilterMask}},
+		{"bad pattern", models.FilterRule{Pattern: "(", Action: models.FilterMask}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile([]models.FilterRule{tt.rule}); err == nil {
+				t.Error("error expected")
+			}
+		})
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		words []string
+		text  string
+		want  string
+	}{
+		{[]string{"bad"}, "so bad, bad!", "so ***, ***!"},
+		{[]string{"wörld"}, "héllo wörld", "héllo *****"},
+		{[]string{"плохо"}, "очень плохо", "очень *****"},
+		{[]string{"日本"}, "日本 語", "** 語"},
+	}
+	for _, tt := range tests {
+		rules, err := Compile([]models.FilterRule{{Words: tt.words, Action: models.FilterMask}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := textParts(tt.text)
+		if !rules[0].apply(parts) {
+			t.Errorf("%q: no match", tt.text)
+		}
+		if parts[0].Content != tt.want {
+			t.Errorf("%q masked to %q, want %q", tt.text, parts[0].Content, tt.want)
+		}
+	}
+}
+
+func TestChainRules(t *testing.T) {
+	mask := models.FilterRule{Name: "mask", Words: []string{"bad"}, Action: models.FilterMask}
+	reject := models.FilterRule{Name: "reject", Words: []string{"bad"}, Action: models.FilterReject}
+	flag := models.FilterRule{Name: "flag", Words: []string{"bad"}, Action: models.FilterFlag}
+	tests := []struct {
+		name     string
+		instance []models.FilterRule
+		room     []models.FilterRule
+		content  string
+		flags    []string
+		rejected string
+	}{
+		{"instance mask hides word from room reject", []models.FilterRule{mask}, []models.FilterRule{reject}, "***", nil, ""},
+		{"instance reject wins over room mask", []models.FilterRule{reject}, []models.FilterRule{mask}, "", nil, "reject"},
+		{"room reject after instance flag", []models.FilterRule{flag}, []models.FilterRule{reject}, "", nil, "reject"},
+		{"flags in order", []models.FilterRule{flag}, []models.FilterRule{{Name: "room", Words: []string{"bad"}, Action: models.FilterFlag}}, "bad", []string{"flag", "room"}, ""},
+		{"no rules", nil, nil, "bad", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Parts: textParts("bad")}
+			flags, err := NewChain(nil).Apply(context.Background(), msg, tt.instance, tt.room)
+			var rejected *RejectedError
+			if tt.rejected != "" {
+				if !errors.As(err, &rejected) || rejected.Rule != tt.rejected {
+					t.Fatalf("error %v, want rejection by %s", err, tt.rejected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(flags, tt.flags) {
+				t.Errorf("flags %v, want %v", flags, tt.flags)
+			}
+			if msg.Parts[0].Content != tt.content {
+				t.Errorf("content %q, want %q", msg.Parts[0].Content, tt.content)
+			}
+		})
+	}
+}
+
+func TestChainHook(t *testing.T) {
+	tests := []struct {
+		name    string
+		verdict Verdict
+		rules   []models.FilterRule
+		parts   []models.MessagePart
+		flags   []string
+		err     error
+	}{
+		{
+			name:    "allow",
+			verdict: Verdict{Action: HookAllow},
+			parts:   textParts("hello", "world"),
+		},
+		{
+			name:    "reject",
+			verdict: Verdict{Action: models.FilterReject, Reason: "spam"},
+			err:     &RejectedError{Rule: ScopeHook, Reason: "spam"},
+		},
+		{
+			name:    "flag",
+			verdict: Verdict{Action: models.FilterFlag},
+			parts:   textParts("hello", "world"),
+			flags:   []string{ScopeHook},
+		},
+		{
+			name: "replaced parts drop rendered fields",
+			verdict: Verdict{Action: HookAllow, Parts: []models.MessagePart{
+				{Type: "text/plain", Content: "h***o", HTML: "<script></script>", Text: "x"},
+				{Type: "text/plain", Content: "world", Preview: &models.LinkPreview{URL: "http://evil"}},
+			}},
+			parts: textParts("h***o", "world"),
+		},
+		{
+			name:    "replaced parts are checked by rules",
+			verdict: Verdict{Action: HookAllow, Parts: textParts("hello", "bad")},
+			rules:   []models.FilterRule{{Name: "reject", Words: []string{"bad"}, Action: models.FilterReject}},
+			err:     &RejectedError{Rule: "reject"},
+		},
+		{
+			name:    "replaced parts are flagged by rules",
+			verdict: Verdict{Action: models.FilterFlag, Parts: textParts("hello", "bad")},
+			rules:   []models.FilterRule{{Name: "flag", Words: []string{"bad"}, Action: models.FilterFlag}},
+			parts:   textParts("hello", "bad"),
+			flags:   []string{ScopeHook, "flag"},
+		},
+		{
+			name:    "part count changed",
+			verdict: Verdict{Action: HookAllow, Parts: textParts("hello")},
+			err:     ErrUnavailable,
+		},
+		{
+			name: "part type changed",
+			verdict: Verdict{Action: HookAllow, Parts: []models.MessagePart{
+				{Type: "text/plain", Content: "hello"},
+				{Type: "text/html", Content: "<b>world</b>"},
+			}},
+			err: ErrUnavailable,
+		},
+		{
+			name:    "unknown action",
+			verdict: Verdict{Action: "mask"},
+			err:     ErrUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := NewChain(testHook(t, tt.verdict, 0, false))
+			msg := &Message{Parts: textParts("hello", "world")}
+			flags, err := chain.Apply(context.Background(), msg, tt.rules, nil)
+			if tt.err != nil {
+				if !reflect.DeepEqual(err, tt.err) {
+					t.Fatalf("error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(flags, tt.flags) {
+				t.Errorf("flags %v, want %v", flags, tt.flags)
+			}
+			if len(msg.Parts) != len(tt.parts) {
+				t.Fatalf("%d parts, want %d", len(msg.Parts), len(tt.parts))
+			}
+			for i, p := range msg.Parts {
+				want := tt.parts[i]
+				if p.Type != want.Type || p.Content != want.Content || p.HTML != "" || p.Text != "" || p.Preview != nil {
+					t.Errorf("part %d is %+v, want %+v", i, p, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		failOpen bool
+		err      error
+	}{
+		{"fail open", true, nil},
+		{"fail closed", false, ErrUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := testHook(t, Verdict{Action: models.FilterReject}, 200*time.Millisecond, tt.failOpen)
+			msg := &Message{Parts: textParts("hello")}
+			flags, err := NewChain(hook).Apply(context.Background(), msg, nil, nil)
+			if err != tt.err {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if len(flags) != 0 || msg.Parts[0].Content != "hello" {
+				t.Errorf("message changed: flags %v, parts %+v", flags, msg.Parts)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package filter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/neonxp/chatcloud/pkg/metrics"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+// HookAllow is verdict of moderation hook that lets message through unchanged
+const HookAllow = "allow"
+
+// hookResponseLimit bounds size of moderation hook response
+const hookResponseLimit = 1 << 20
+
+// Verdict is response of moderation hook. Action is one of allow, reject or flag,
+// Parts replace parts of message when set so the service can mask text itself, they must keep number and types of parts
+type Verdict struct {
+	Action string               `json:"action"`
+	Reason string               `json:"reason,omitempty"`
+	Parts  []models.MessagePart `json:"parts,omitempty"`
+}
+
+// Hook asks external moderation service about every new or edited message before it is stored
+type Hook struct {
+	url      string
+	client   *http.Client
+	failOpen bool
+	log      logrus.FieldLogger
+}
+
+// NewHook creates hook, failOpen lets messages through when the service fails or times out
+func NewHook(url string, timeout time.Duration, failOpen bool, log logrus.FieldLogger) *Hook {
+	return &Hook{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		failOpen: failOpen,
+		log:      log,
+	}
+}
+
+// Check posts msg to moderation service, failures return ErrUnavailable unless hook fails open
+func (h *Hook) Check(ctx context.Context, msg *Message) (*Verdict, error) {
+	verdict, err := h.call(ctx, msg)
+	if err == nil {
+		metrics.FilterHookRequests.WithLabelValues("ok").Inc()
+		return verdict, nil
+	}
+	metrics.FilterHookRequests.WithLabelValues("failed").Inc()
+	h.log.WithError(err).WithField("fail_open", h.failOpen).Warn("moderation hook failed")
+	if h.failOpen {
+		return &Verdict{Action: HookAllow}, nil
+	}
+	return nil, ErrUnavailable
+}
+
+func (h *Hook) call(ctx context.Context, msg *Message) (*Verdict, error) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	verdict := new(Verdict)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, hookResponseLimit)).Decode(verdict); err != nil {
+		return nil, fmt.Errorf("bad response: %w", err)
+	}
+	switch verdict.Action {
+	case HookAllow, models.FilterReject, models.FilterFlag:
+	default:
+		return nil, fmt.Errorf("unknown action %q", verdict.Action)
+	}
+	if len(verdict.Parts) == 0 {
+		return verdict, nil
+	}
+	if len(verdict.Parts) != len(msg.Parts) {
+		return nil, fmt.Errorf("%d parts returned for %d", len(verdict.Parts), len(msg.Parts))
+	}
+	for i, part := range verdict.Parts {
+		if part.Type != msg.Parts[i].Type {
+			return nil, fmt.Errorf("part %d: type %q changed to %q", i, msg.Parts[i].Type, part.Type)
+		}
+	}
+	return verdict, nil
+}
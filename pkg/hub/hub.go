@@ -0,0 +1,227 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/neonxp/chatcloud/pkg/metrics"
+)
+
+const (
+	EventReconnect       = "reconnect"
+	EventNewMessage      = "new_message"
+	EventMessageEdited   = "message_edited"
+	EventMessageDeleted  = "message_deleted"
+	EventMessageHidden   = "message_hidden"
+	EventMessageUnhidden = "message_unhidden"
+	EventMessageUpdated  = "message_updated"
+	EventReactionAdded   = "reaction_added"
+	EventReactionRemoved = "reaction_removed"
+	EventThreadReply     = "thread_reply"
+	EventThreadCursor    = "thread_cursor_updated"
+	EventReadCursor      = "read_cursor_updated"
+	EventMessagePinned   = "message_pinned"
+	EventMessageUnpinned = "message_unpinned"
+	EventMention         = "mention"
+	EventAddedToRoom     = "added_to_room"
+	EventRoomUpdated     = "room_updated"
+	EventRoomArchived    = "room_archived"
+	EventRoomUnarchived  = "room_unarchived"
+	EventRemovedFromRoom = "removed_from_room"
+	EventMembersRemoved  = "members_removed"
+	EventModeration      = "moderation"
+
+	subscriptionBuffer = 16
+	finalEventTimeout  = time.Second
+)
+
+var ErrClosed = errors.New("hub is shutting down")
+
+// Event is a single item of SUBSCRIBE stream
+type Event struct {
+	Name      string      `json:"event_name"`
+	Data      interface{} `json:"data"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Hub delivers events between instances through redis pub/sub and tracks open subscriptions
+type Hub struct {
+	rds    *redis.Client
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func New(rds *redis.Client) *Hub {
+	return &Hub{
+		rds:  rds,
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+func RoomChannel(instanceID string, roomID string) string {
+	return instanceID + ":rooms:" + roomID
+}
+
+func UserChannel(instanceID string, userID string) string {
+	return instanceID + ":users:" + userID
+}
+
+// Publish sends event to all subscribers of channel on every instance
+func (h *Hub) Publish(channel string, name string, data interface{}) error {
+	b, err := json.Marshal(&Event{
+		Name:      name,
+		Data:      data,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return h.rds.Publish(channel, b).Err()
+}
+
+// Subscribe opens subscription to channels, resource is used as metrics label
+func (h *Hub) Subscribe(resource string, channels ...string) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+	ps := h.rds.Subscribe(channels...)
+	if _, err := ps.Receive(); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	s := &Subscription{
+		hub:      h,
+		ps:       ps,
+		resource: resource,
+		events:   make(chan *Event, subscriptionBuffer),
+		done:     make(chan struct{}),
+	}
+	h.subs[s] = struct{}{}
+	metrics.Subscriptions.WithLabelValues(resource).Inc()
+	go s.run()
+	return s, nil
+}
+
+// Shutdown sends reconnect hint to every open subscription and closes it
+func (h *Hub) Shutdown(retryAfter time.Duration) {
+	h.mu.Lock()
+	h.closed = true
+	subs := make([]*Subscription, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.mu.Unlock()
+
+	hint := &Event{
+		Name:      EventReconnect,
+		Data:      map[string]int64{"retry_after_ms": retryAfter.Milliseconds()},
+		Timestamp: time.Now(),
+	}
+	for _, s := range subs {
+		s.closeWith(hint)
+	}
+}
+
+func (h *Hub) remove(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		metrics.Subscriptions.WithLabelValues(s.resource).Dec()
+	}
+}
+
+// Subscription streams events until it is closed by client or hub shutdown
+type Subscription struct {
+	hub      *Hub
+	ps       *redis.PubSub
+	resource string
+	events   chan *Event
+	done     chan struct{}
+	once     sync.Once
+	final    *Event
+}
+
+// Events returns channel that is closed when subscription ends
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.closeWith(nil)
+}
+
+func (s *Subscription) closeWith(final *Event) {
+	s.once.Do(func() {
+		s.final = final
+		close(s.done)
+		_ = s.ps.Close()
+		s.hub.remove(s)
+	})
+}
+
+func (s *Subscription) run() {
+	defer close(s.events)
+	messages := s.ps.Channel()
+	for {
+		select {
+		case <-s.done:
+			s.sendFinal()
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				<-s.done
+				s.sendFinal()
+				return
+			}
+			event := new(Event)
+			if err := json.Unmarshal([]byte(msg.Payload), event); err != nil {
+				continue
+			}
+			select {
+			case s.events <- event:
+			case <-s.done:
+				s.sendFinal()
+				return
+			}
+		}
+	}
+}
+
+func (s *Subscription) sendFinal() {
+	if s.final == nil {
+		return
+	}
+	select {
+	case s.events <- s.final:
+	case <-time.After(finalEventTimeout):
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package hub
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// PresenceTTL is how long user counts as online after last heartbeat of its subscription
+const PresenceTTL = time.Minute
+
+func presenceKey(instanceID string) string {
+	return instanceID + ":presence"
+}
+
+// Touch marks user online, open user subscriptions call it periodically
+func (h *Hub) Touch(instanceID string, userID string) error {
+	now := time.Now()
+	key := presenceKey(instanceID)
+	_, err := h.rds.Pipelined(func(p redis.Pipeliner) error {
+		p.ZAdd(key, redis.Z{Score: float64(now.Unix()), Member: userID})
+		p.ZRemRangeByScore(key, "-inf", "("+strconv.FormatInt(now.Add(-PresenceTTL).Unix(), 10))
+		p.Expire(key, PresenceTTL)
+		return nil
+	})
+	return err
+}
+
+// Online returns those of userIDs that had open subscription within PresenceTTL
+func (h *Hub) Online(instanceID string, userIDs []string) ([]string, error) {
+	min := strconv.FormatInt(time.Now().Add(-PresenceTTL).Unix(), 10)
+	online, err := h.rds.ZRangeByScore(presenceKey(instanceID), redis.ZRangeBy{Min: min, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(online))
+	for _, id := range online {
+		seen[id] = true
+	}
+	var result []string
+	for _, id := range userIDs {
+		if seen[id] {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
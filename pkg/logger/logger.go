@@ -0,0 +1,104 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package logger
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+)
+
+const loggerCtxKey = "logger"
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New builds logger with given level (debug, info, warn, error) and output format (json, text)
+func New(level string, format string) (*logrus.Logger, error) {
+	l := logrus.New()
+	l.SetOutput(os.Stdout)
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	l.SetLevel(lvl)
+	switch format {
+	case FormatJSON:
+		l.SetFormatter(&logrus.JSONFormatter{})
+	case FormatText:
+		l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return l, nil
+}
+
+// scoped holds request logger, middlewares running later add fields to it in place
+// so they also show up in request completion log
+type scoped struct {
+	mu    sync.Mutex
+	entry *logrus.Entry
+}
+
+// WithLogger stores request scoped logger in context
+func WithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerCtxKey, &scoped{entry: entry})
+}
+
+// AddFields adds fields to logger stored in context, it does nothing when there is none
+func AddFields(ctx context.Context, fields logrus.Fields) {
+	if s, ok := ctx.Value(loggerCtxKey).(*scoped); ok {
+		s.mu.Lock()
+		s.entry = s.entry.WithFields(fields)
+		s.mu.Unlock()
+	}
+}
+
+// FromContext returns logger stored in context or standard logger if there is none
+func FromContext(ctx context.Context) *logrus.Entry {
+	if s, ok := ctx.Value(loggerCtxKey).(*scoped); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// FromRequest returns request logger enriched with user and room from matched route.
+// user_id field is set by auth middleware from token, user of the route is logged as target_user_id
+func FromRequest(r *http.Request) *logrus.Entry {
+	entry := FromContext(r.Context())
+	if uid := chi.URLParam(r, "user_id"); uid != "" {
+		entry = entry.WithField("target_user_id", uid)
+	}
+	if rid := chi.URLParam(r, "room_id"); rid != "" {
+		entry = entry.WithField("room_id", rid)
+	}
+	return entry
+}
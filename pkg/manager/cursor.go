@@ -0,0 +1,140 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package manager
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var cursorIndexes = []db.Index{
+	{Fields: []string{"room_id", "user_id", "cursor_type"}, IsUnique: true},
+	{Fields: []string{"user_id"}},
+}
+
+var threadCursorIndexes = []db.Index{
+	{Fields: []string{"thread_id", "user_id"}, IsUnique: true},
+}
+
+// Cursor manages read cursors of rooms and, in separate collection, of message threads
+type Cursor struct {
+	manager *db.Manager
+	threads *db.Manager
+}
+
+func NewCursor(collection *mongo.Collection, threads *mongo.Collection) (*Cursor, error) {
+	manager, err := db.NewManager(collection, nil)
+	if err != nil {
+		return nil, err
+	}
+	threadManager, err := db.NewManager(threads, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{manager: manager, threads: threadManager}, nil
+}
+
+func (m *Cursor) EnsureIndexes() error {
+	if err := m.manager.EnsureIndexes(cursorIndexes); err != nil {
+		return err
+	}
+	return m.threads.EnsureIndexes(threadCursorIndexes)
+}
+
+// Set stores cursor replacing previous position of user in room
+func (m *Cursor) Set(c *models.Cursor) error {
+	return m.manager.Upsert(bson.M{
+		"room_id":     c.RoomID,
+		"user_id":     c.UserID,
+		"cursor_type": c.CursorType,
+	}, c)
+}
+
+func (m *Cursor) Find(roomID string, userID string) (*models.Cursor, error) {
+	c := new(models.Cursor)
+	return c, m.manager.FindOne(bson.M{
+		"room_id":     roomID,
+		"user_id":     userID,
+		"cursor_type": models.CursorTypeRead,
+	}, c)
+}
+
+// SetThread stores read position of user in thread
+func (m *Cursor) SetThread(c *models.Cursor) error {
+	return m.threads.Upsert(bson.M{
+		"thread_id": c.ThreadID,
+		"user_id":   c.UserID,
+	}, c)
+}
+
+func (m *Cursor) FindThread(threadID int64, userID string) (*models.Cursor, error) {
+	c := new(models.Cursor)
+	return c, m.threads.FindOne(bson.M{"thread_id": threadID, "user_id": userID}, c)
+}
+
+func (m *Cursor) ByRoom(roomID string) ([]*models.Cursor, error) {
+	return m.find(bson.M{"room_id": roomID, "cursor_type": models.CursorTypeRead})
+}
+
+func (m *Cursor) ByUser(userID string) ([]*models.Cursor, error) {
+	return m.find(bson.M{"user_id": userID, "cursor_type": models.CursorTypeRead})
+}
+
+// RemoveByRoom drops read cursors of room and cursors of its threads
+func (m *Cursor) RemoveByRoom(roomID string, threadIDs []int64) error {
+	if _, err := m.manager.RemoveMany(bson.M{"room_id": roomID}); err != nil {
+		return err
+	}
+	if len(threadIDs) == 0 {
+		return nil
+	}
+	_, err := m.threads.RemoveMany(bson.M{"thread_id": bson.M{"$in": threadIDs}})
+	return err
+}
+
+func (m *Cursor) find(filter bson.M) ([]*models.Cursor, error) {
+	cur, err := m.manager.Find(filter, map[string]int{"updated_at": -1}, db.Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	if cur == nil {
+		return nil, nil
+	}
+	defer cur.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var cursors []*models.Cursor
+	for cur.Next(ctx) {
+		c := new(models.Cursor)
+		if err := cur.Decode(c); err != nil {
+			return nil, err
+		}
+		cursors = append(cursors, c)
+	}
+	return cursors, cur.Err()
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package manager
+
+import (
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var exportIndexes = []db.Index{
+	{Fields: []string{"room_id"}},
+}
+
+// Export manages transcript jobs and their files
+type Export struct {
+	manager *db.Manager
+	bucket  *gridfs.Bucket
+}
+
+func NewExport(collection *mongo.Collection, bucket *gridfs.Bucket) (*Export, error) {
+	manager, err := db.NewManager(collection, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Export{manager: manager, bucket: bucket}, nil
+}
+
+func (m *Export) EnsureIndexes() error {
+	return m.manager.EnsureIndexes(exportIndexes)
+}
+
+func (m *Export) Create(roomID primitive.ObjectID, format string, requestedBy string) (*models.Export, error) {
+	e := &models.Export{
+		ID:          primitive.NewObjectID(),
+		RoomID:      roomID,
+		Format:      format,
+		Status:      models.ExportPending,
+		RequestedBy: requestedBy,
+		CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := m.manager.Add(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (m *Export) FindByID(roomID primitive.ObjectID, id string) (*models.Export, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	e := new(models.Export)
+	return e, m.manager.FindOne(bson.M{"_id": oid, "room_id": roomID}, e)
+}
+
+func (m *Export) SetRunning(id primitive.ObjectID) error {
+	return m.manager.Update(id, bson.M{"status": models.ExportRunning})
+}
+
+func (m *Export) SetFailed(id primitive.ObjectID, cause error) error {
+	return m.manager.Update(id, bson.M{
+		"status":      models.ExportFailed,
+		"error":       cause.Error(),
+		"finished_at": primitive.NewDateTimeFromTime(time.Now()),
+	})
+}
+
+func (m *Export) SetDone(id primitive.ObjectID, fileID primitive.ObjectID, size int64, messages int64) error {
+	return m.manager.Update(id, bson.M{
+		"status":      models.ExportDone,
+		"file_id":     fileID,
+		"size":        size,
+		"messages":    messages,
+		"finished_at": primitive.NewDateTimeFromTime(time.Now()),
+	})
+}
+
+// Upload opens stream for transcript file, caller must Close it to commit or Abort on failure
+func (m *Export) Upload(filename string) (*gridfs.UploadStream, error) {
+	return m.bucket.OpenUploadStream(filename)
+}
+
+// Open returns reader of finished transcript and its size
+func (m *Export) Open(e *models.Export) (io.ReadCloser, int64, error) {
+	ds, err := m.bucket.OpenDownloadStream(e.FileID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ds, ds.GetFile().Length, nil
+}
+
+// RemoveByRoom drops export jobs of room together with their transcript files
+func (m *Export) RemoveByRoom(roomID primitive.ObjectID) error {
+	values, err := m.manager.Distinct("file_id", bson.M{"room_id": roomID, "file_id": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		id, ok := v.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		if err := m.bucket.Delete(id); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+	_, err = m.manager.RemoveMany(bson.M{"room_id": roomID})
+	return err
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package manager
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+)
+
+var importIDIndexes = []db.Index{
+	{Fields: []string{"kind", "old_num"}},
+}
+
+// importedID maps id of imported entity to id it got here
+type importedID struct {
+	ID     string `bson:"_id"`
+	Kind   string `bson:"kind"`
+	Old    string `bson:"old"`
+	OldNum int64  `bson:"old_num,omitempty"`
+	New    string `bson:"new,omitempty"`
+	NewNum int64  `bson:"new_num,omitempty"`
+}
+
+type importProgress struct {
+	Source    string    `bson:"_id"`
+	Offset    int64     `bson:"offset"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Import keeps id mapping and progress of data imports, so interrupted import can be resumed
+type Import struct {
+	ids      *db.Manager
+	progress *db.Manager
+}
+
+func NewImport(ids *mongo.Collection, progress *mongo.Collection) (*Import, error) {
+	im, err := db.NewManager(ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := db.NewManager(progress, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Import{ids: im, progress: pm}, nil
+}
+
+func (m *Import) EnsureIndexes() error {
+	return m.ids.EnsureIndexes(importIDIndexes)
+}
+
+func (m *Import) MapID(kind string, old string, new string) error {
+	_, err := m.ids.Add(&importedID{ID: kind + ":" + old, Kind: kind, Old: old, New: new})
+	return err
+}
+
+// LookupID returns mongo.ErrNoDocuments when entity was not imported yet
+func (m *Import) LookupID(kind string, old string) (string, error) {
+	id := new(importedID)
+	if err := m.ids.FindOne(bson.M{"_id": kind + ":" + old}, id); err != nil {
+		return "", err
+	}
+	return id.New, nil
+}
+
+func (m *Import) MapNumericID(kind string, old int64, new int64) error {
+	s := strconv.FormatInt(old, 10)
+	_, err := m.ids.Add(&importedID{ID: kind + ":" + s, Kind: kind, Old: s, OldNum: old, NewNum: new})
+	return err
+}
+
+func (m *Import) LookupNumericID(kind string, old int64) (int64, error) {
+	id := new(importedID)
+	if err := m.ids.FindOne(bson.M{"_id": kind + ":" + strconv.FormatInt(old, 10)}, id); err != nil {
+		return 0, err
+	}
+	return id.NewNum, nil
+}
+
+// LookupNumericIDAtOrBefore maps old id to id of closest imported entity not after it,
+// used for positions pointing at entities that were not exported
+func (m *Import) LookupNumericIDAtOrBefore(kind string, old int64) (int64, error) {
+	cur, err := m.ids.Find(
+		bson.M{"kind": kind, "old_num": bson.M{"$lte": old}},
+		map[string]int{"old_num": -1},
+		db.Pagination{Limit: 1},
+	)
+	if err != nil {
+		return 0, err
+	}
+	if cur == nil {
+		return 0, mongo.ErrNoDocuments
+	}
+	ctx := context.Background()
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		if err := cur.Err(); err != nil {
+			return 0, err
+		}
+		return 0, mongo.ErrNoDocuments
+	}
+	id := new(importedID)
+	if err := cur.Decode(id); err != nil {
+		return 0, err
+	}
+	return id.NewNum, nil
+}
+
+// Progress returns number of records of source already imported
+func (m *Import) Progress(source string) (int64, error) {
+	p := new(importProgress)
+	err := m.progress.FindOne(bson.M{"_id": source}, p)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return p.Offset, err
+}
+
+func (m *Import) SetProgress(source string, offset int64) error {
+	return m.progress.Upsert(bson.M{"_id": source}, bson.M{"offset": offset, "updated_at": time.Now()})
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package manager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+type Instance struct {
+	manager *db.Manager
+}
+
+func NewInstance(collection *mongo.Collection) (*Instance, error) {
+	manager, err := db.NewManager(collection, []db.Index{{Fields: []string{"key_id"}, IsUnique: true}})
+	if err != nil {
+		return nil, err
+	}
+	return &Instance{
+		manager: manager,
+	}, nil
+}
+
+// CreateInstance stores new instance with freshly generated key and secret
+func (m *Instance) CreateInstance(id string, name string, database string, prefix string, corsOrigins []string) (*models.Instance, error) {
+	keyID, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	i := &models.Instance{
+		ID:          id,
+		Name:        name,
+		KeyID:       keyID,
+		Secret:      secret,
+		Database:    database,
+		Prefix:      prefix,
+		CORSOrigins: corsOrigins,
+		CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := m.manager.Add(i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (m *Instance) FindByID(id string) (*models.Instance, error) {
+	i := new(models.Instance)
+	return i, m.manager.FindOne(bson.M{"_id": id}, i)
+}
+
+// FindLegacy returns instance mapped to unprefixed collections of control database
+func (m *Instance) FindLegacy() (*models.Instance, error) {
+	i := new(models.Instance)
+	return i, m.manager.FindOne(bson.M{"database": bson.M{"$exists": false}, "prefix": bson.M{"$exists": false}}, i)
+}
+
+func (m *Instance) Find() ([]*models.Instance, error) {
+	cur, err := m.manager.Find(bson.M{}, map[string]int{"created_at": 1}, db.Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	if cur == nil {
+		return nil, nil
+	}
+	defer cur.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var instances []*models.Instance
+	for cur.Next(ctx) {
+		i := new(models.Instance)
+		if err := cur.Decode(i); err != nil {
+			return nil, err
+		}
+		instances = append(instances, i)
+	}
+	return instances, nil
+}
+
+// SetRetention sets default retention policy of instance rooms, nil disables retention
+func (m *Instance) SetRetention(id string, retention *models.Retention) error {
+	update := bson.M{"$set": bson.M{"retention": retention}}
+	if retention == nil {
+		update = bson.M{"$unset": bson.M{"retention": ""}}
+	}
+	n, err := m.manager.UpdateMany(bson.M{"_id": id}, update)
+	if err == nil && n == 0 {
+		_, err = m.FindByID(id)
+	}
+	return err
+}
+
+// SetFilters replaces message filter rules applied in every room of instance, empty rules remove them
+func (m *Instance) SetFilters(id string, rules []models.FilterRule) error {
+	update := bson.M{"$set": bson.M{"filters": rules}}
+	if len(rules) == 0 {
+		update = bson.M{"$unset": bson.M{"filters": ""}}
+	}
+	n, err := m.manager.UpdateMany(bson.M{"_id": id}, update)
+	if err == nil && n == 0 {
+		_, err = m.FindByID(id)
+	}
+	return err
+}
+
+// MarkDeleted flags instance as being deleted, its data is dropped afterwards
+func (m *Instance) MarkDeleted(id string) error {
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"deleted_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	return err
+}
+
+func (m *Instance) Remove(id string) error {
+	return m.manager.Remove(id)
+}
+
+// NewID generates random instance id
+func NewID() (string, error) {
+	return randomHex(16)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -22,15 +22,49 @@ THE SOFTWARE.
 package manager
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
 )
 
+var messageIndexes = []db.Index{
+	{Fields: []string{"room_id", "_id"}},
+	{Fields: []string{"room_id", "created_at"}},
+	{Fields: []string{"parent_id", "_id"}},
+	{Fields: []string{"room_id", "pinned_at"}},
+	{Fields: []string{"mentions", "_id"}},
+	{Fields: []string{"flags", "room_id"}},
+}
+
+// nextIDScript increments counter but never returns id at or below floor, so ids stay unique if counter is lost
+var nextIDScript = redis.NewScript(`
+local id = redis.call("INCR", KEYS[1])
+local floor = tonumber(ARGV[1])
+if id <= floor then
+	id = floor + 1
+	redis.call("SET", KEYS[1], id)
+end
+return id
+`)
+
 type Message struct {
 	manager *db.Manager
 	rds     *redis.Client
+	idKey   string
+
+	mu     sync.Mutex
+	loaded bool
+	floor  int64
 }
 
 func NewMessage(collection *mongo.Collection, rds *redis.Client) (*Message, error) {
@@ -38,5 +72,526 @@ func NewMessage(collection *mongo.Collection, rds *redis.Client) (*Message, erro
 	if err != nil {
 		return nil, err
 	}
-	return &Message{manager: manager, rds: rds}, nil
+	return &Message{
+		manager: manager,
+		rds:     rds,
+		idKey:   "message_id:" + collection.Database().Name() + "." + collection.Name(),
+	}, nil
+}
+
+func (m *Message) EnsureIndexes() error {
+	return m.manager.EnsureIndexes(messageIndexes)
+}
+
+// NextID allocates message id. Ids grow monotonically within instance, so they order room history
+func (m *Message) NextID() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.loaded {
+		last, err := m.lastID()
+		if err != nil {
+			return 0, err
+		}
+		m.floor, m.loaded = last, true
+	}
+	id, err := nextIDScript.Run(m.rds, []string{m.idKey}, m.floor).Int64()
+	if err != nil {
+		return 0, err
+	}
+	m.floor = id
+	return id, nil
+}
+
+// Insert stores message as is, keeping its id and timestamps
+func (m *Message) Insert(msg *models.Message) error {
+	_, err := m.manager.Add(msg)
+	return err
+}
+
+func (m *Message) FindByID(id int64) (*models.Message, error) {
+	msg := new(models.Message)
+	return msg, m.manager.FindOne(bson.M{"_id": id}, msg)
+}
+
+// FindByIDs returns up to 100 messages with given ids
+func (m *Message) FindByIDs(ids []int64) ([]*models.Message, error) {
+	return m.page(bson.M{"_id": bson.M{"$in": ids}}, 0, false, 100)
+}
+
+func (m *Message) FindInRoom(roomID primitive.ObjectID, id int64) (*models.Message, error) {
+	msg := new(models.Message)
+	return msg, m.manager.FindOne(bson.M{"_id": id, "room_id": roomID}, msg)
+}
+
+// History returns up to limit room messages next to fromID: older ones newest first or newer ones oldest first.
+// Zero fromID starts from the newest or the oldest message. Thread replies are listed by Replies only, hidden messages are left out
+func (m *Message) History(roomID primitive.ObjectID, fromID int64, newer bool, limit int) ([]*models.Message, error) {
+	return m.page(bson.M{"room_id": roomID, "parent_id": bson.M{"$exists": false}, "hidden_at": bson.M{"$exists": false}}, fromID, newer, limit)
+}
+
+// Replies pages through thread of parent message the same way History does
+func (m *Message) Replies(parentID int64, fromID int64, newer bool, limit int) ([]*models.Message, error) {
+	return m.page(bson.M{"parent_id": parentID, "hidden_at": bson.M{"$exists": false}}, fromID, newer, limit)
+}
+
+// Mentioning pages through messages that mention user in any room, newest first
+func (m *Message) Mentioning(userID string, fromID int64, limit int) ([]*models.Message, error) {
+	return m.page(bson.M{"mentions": userID, "hidden_at": bson.M{"$exists": false}}, fromID, false, limit)
+}
+
+// CountUnread returns number of room messages after position sent by others, thread replies are not counted
+func (m *Message) CountUnread(roomID primitive.ObjectID, position int64, userID string) (int64, error) {
+	return m.manager.Count(bson.M{
+		"room_id":   roomID,
+		"_id":       bson.M{"$gt": position},
+		"user_id":   bson.M{"$ne": userID},
+		"parent_id": bson.M{"$exists": false},
+	})
+}
+
+// CountUnreadMentions returns number of room messages after position that mention user, replies included
+func (m *Message) CountUnreadMentions(roomID primitive.ObjectID, position int64, userID string) (int64, error) {
+	return m.manager.Count(bson.M{
+		"room_id":  roomID,
+		"_id":      bson.M{"$gt": position},
+		"mentions": userID,
+	})
+}
+
+// UnreadCounts is number of unread messages and unread mentions in room
+type UnreadCounts struct {
+	RoomID   primitive.ObjectID `bson:"_id"`
+	Unread   int64              `bson:"unread"`
+	Mentions int64              `bson:"mentions"`
+}
+
+// CountUnreadByRooms does CountUnread and CountUnreadMentions for many rooms in one aggregation,
+// positions maps room to read position of user. Rooms without unread messages are missing in result
+func (m *Message) CountUnreadByRooms(positions map[primitive.ObjectID]int64, userID string) (map[primitive.ObjectID]UnreadCounts, error) {
+	result := make(map[primitive.ObjectID]UnreadCounts, len(positions))
+	if len(positions) == 0 {
+		return result, nil
+	}
+	roomIDs := make([]primitive.ObjectID, 0, len(positions))
+	after := make([]bson.M, 0, len(positions))
+	for roomID, position := range positions {
+		roomIDs = append(roomIDs, roomID)
+		after = append(after, bson.M{"room_id": roomID, "_id": bson.M{"$gt": position}})
+	}
+	unread := bson.M{"$and": []interface{}{
+		bson.M{"$ne": []interface{}{"$user_id", userID}},
+		bson.M{"$eq": []interface{}{bson.M{"$type": "$parent_id"}, "missing"}},
+	}}
+	mentioned := bson.M{"$in": []interface{}{userID, bson.M{"$ifNull": []interface{}{"$mentions", bson.A{}}}}}
+	var counts []UnreadCounts
+	err := m.manager.Aggregate([]bson.M{
+		{"$match": bson.M{"room_id": bson.M{"$in": roomIDs}, "$or": after}},
+		{"$group": bson.M{
+			"_id":      "$room_id",
+			"unread":   bson.M{"$sum": bson.M{"$cond": []interface{}{unread, 1, 0}}},
+			"mentions": bson.M{"$sum": bson.M{"$cond": []interface{}{mentioned, 1, 0}}},
+		}},
+	}, &counts)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		result[c.RoomID] = c
+	}
+	return result, nil
+}
+
+// CountReplies returns number of thread replies after position
+func (m *Message) CountReplies(parentID int64, position int64) (int64, error) {
+	return m.manager.Count(bson.M{"parent_id": parentID, "_id": bson.M{"$gt": position}})
+}
+
+// AddReply updates reply counter and last reply time denormalized on parent message
+func (m *Message) AddReply(parentID int64, at primitive.DateTime) error {
+	_, err := m.manager.UpdateMany(bson.M{"_id": parentID}, bson.M{
+		"$inc": bson.M{"reply_count": 1},
+		"$max": bson.M{"last_reply_at": at},
+	})
+	return err
+}
+
+// ParentIDs returns thread parents of messages that are replies
+func (m *Message) ParentIDs(ids []int64) ([]int64, error) {
+	values, err := m.manager.Distinct("parent_id", bson.M{"_id": bson.M{"$in": ids}, "parent_id": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	parentIDs := make([]int64, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(int64); ok {
+			parentIDs = append(parentIDs, id)
+		}
+	}
+	return parentIDs, nil
+}
+
+// RecountReplies sets reply counter and last reply time of parents from replies left in their threads
+func (m *Message) RecountReplies(parentIDs []int64) error {
+	for _, id := range parentIDs {
+		n, err := m.manager.Count(bson.M{"parent_id": id})
+		if err != nil {
+			return err
+		}
+		update := bson.M{"$unset": bson.M{"reply_count": "", "last_reply_at": ""}}
+		if n > 0 {
+			last, err := m.page(bson.M{"parent_id": id}, 0, false, 1)
+			if err != nil {
+				return err
+			}
+			set := bson.M{"reply_count": n}
+			if len(last) > 0 {
+				set["last_reply_at"] = last[0].CreatedAt
+			}
+			update = bson.M{"$set": set}
+		}
+		if _, err := m.manager.UpdateMany(bson.M{"_id": id}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Message) page(filter bson.M, fromID int64, newer bool, limit int) ([]*models.Message, error) {
+	order := -1
+	if newer {
+		order = 1
+	}
+	if fromID > 0 {
+		if newer {
+			filter["_id"] = bson.M{"$gt": fromID}
+		} else {
+			filter["_id"] = bson.M{"$lt": fromID}
+		}
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	cur, err := m.manager.Find(filter, map[string]int{"_id": order}, db.Pagination{Limit: int64(limit)})
+	if err != nil || cur == nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer cur.Close(ctx)
+	messages := []*models.Message{}
+	for cur.Next(ctx) {
+		msg := new(models.Message)
+		if err := cur.Decode(msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, cur.Err()
+}
+
+// AddReaction records reaction of user, returns false when user already reacted with the same emoji
+func (m *Message) AddReaction(id int64, reaction string, userID string) (bool, error) {
+	// Either emoji is already on message and user is counted in, or it is new and pushed as a whole.
+	// Second step fails when concurrent request pushed the same emoji first, so both are retried
+	for attempt := 0; attempt < 3; attempt++ {
+		n, err := m.manager.UpdateMany(bson.M{
+			"_id":       id,
+			"reactions": bson.M{"$elemMatch": bson.M{"reaction": reaction, "user_ids": bson.M{"$ne": userID}}},
+		}, bson.M{
+			"$inc":      bson.M{"reactions.$.count": 1},
+			"$addToSet": bson.M{"reactions.$.user_ids": userID},
+		})
+		if err != nil || n > 0 {
+			return n > 0, err
+		}
+		n, err = m.manager.UpdateMany(bson.M{
+			"_id":                id,
+			"reactions.reaction": bson.M{"$ne": reaction},
+		}, bson.M{
+			"$push": bson.M{"reactions": models.Reaction{Reaction: reaction, Count: 1, UserIDs: []string{userID}}},
+		})
+		if err != nil || n > 0 {
+			return n > 0, err
+		}
+		reacted, err := m.manager.Count(bson.M{
+			"_id":       id,
+			"reactions": bson.M{"$elemMatch": bson.M{"reaction": reaction, "user_ids": userID}},
+		})
+		if err != nil || reacted > 0 {
+			return false, err
+		}
+	}
+	return false, errors.New("reaction is contended, try again")
+}
+
+// RemoveReaction forgets reaction of user, returns false when user has not reacted with emoji
+func (m *Message) RemoveReaction(id int64, reaction string, userID string) (bool, error) {
+	n, err := m.manager.UpdateMany(bson.M{
+		"_id":       id,
+		"reactions": bson.M{"$elemMatch": bson.M{"reaction": reaction, "user_ids": userID}},
+	}, bson.M{
+		"$inc":  bson.M{"reactions.$.count": -1},
+		"$pull": bson.M{"reactions.$.user_ids": userID},
+	})
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = m.manager.UpdateMany(bson.M{"_id": id}, bson.M{
+		"$pull": bson.M{"reactions": bson.M{"count": bson.M{"$lte": 0}}},
+	})
+	return true, err
+}
+
+// Edit replaces parts and mentions of message, flags are only added so editing can't take message out of review
+func (m *Message) Edit(id int64, parts []models.MessagePart, mentions []string, broadcast string, flags []string, at primitive.DateTime) error {
+	set := bson.M{"parts": parts, "updated_at": at}
+	unset := bson.M{}
+	if len(mentions) > 0 {
+		set["mentions"] = mentions
+	} else {
+		unset["mentions"] = ""
+	}
+	if broadcast != "" {
+		set["mention_broadcast"] = broadcast
+	} else {
+		unset["mention_broadcast"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(flags) > 0 {
+		update["$addToSet"] = bson.M{"flags": bson.M{"$each": flags}}
+	}
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, update)
+	return err
+}
+
+// Hide leaves message out of history until moderator reviews it, returns false when it is already hidden
+func (m *Message) Hide(id int64) (bool, error) {
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, "hidden_at": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"hidden_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	return n > 0, err
+}
+
+// Unhide returns message to history, returns false when it is not hidden
+func (m *Message) Unhide(id int64) (bool, error) {
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, "hidden_at": bson.M{"$exists": true}}, bson.M{
+		"$unset": bson.M{"hidden_at": ""},
+	})
+	return n > 0, err
+}
+
+// SetPreview stores link preview on part at index, returns false when message is deleted
+// or the part no longer has url because message was edited meanwhile
+func (m *Message) SetPreview(id int64, index int, url string, preview *models.LinkPreview) (bool, error) {
+	part := "parts." + strconv.Itoa(index)
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, part + ".url": url, "deleted_at": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{part + ".preview": preview},
+	})
+	return n > 0, err
+}
+
+// Delete drops content of message and marks it deleted, it stays in history so threads and cursors stay consistent.
+// Returns false when message is already deleted
+func (m *Message) Delete(id int64) (bool, error) {
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, "deleted_at": bson.M{"$exists": false}}, bson.M{
+		"$set":   bson.M{"parts": []models.MessagePart{}, "deleted_at": primitive.NewDateTimeFromTime(time.Now())},
+		"$unset": bson.M{"hidden_at": "", "pinned_at": "", "pinned_by": ""},
+	})
+	return n > 0, err
+}
+
+// Flagged returns messages of room held for review by filters, older than fromID newest first
+func (m *Message) Flagged(roomID primitive.ObjectID, fromID int64, limit int) ([]*models.Message, error) {
+	return m.page(bson.M{"room_id": roomID, "flags": bson.M{"$exists": true}}, fromID, false, limit)
+}
+
+// ClearFlags marks message as reviewed, returns false when it wasn't flagged
+func (m *Message) ClearFlags(id int64) (bool, error) {
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, "flags": bson.M{"$exists": true}}, bson.M{
+		"$unset": bson.M{"flags": ""},
+	})
+	return n > 0, err
+}
+
+// Pin marks message pinned by user, returns false when it is already pinned
+func (m *Message) Pin(id int64, by string) (bool, error) {
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, "pinned_at": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"pinned_at": primitive.NewDateTimeFromTime(time.Now()), "pinned_by": by},
+	})
+	return n > 0, err
+}
+
+// Unpin returns false when message is not pinned
+func (m *Message) Unpin(id int64) (bool, error) {
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, "pinned_at": bson.M{"$exists": true}}, bson.M{
+		"$unset": bson.M{"pinned_at": "", "pinned_by": ""},
+	})
+	return n > 0, err
+}
+
+// Pinned returns pinned messages of room, recently pinned first. Hidden messages are left out until reviewed
+func (m *Message) Pinned(roomID primitive.ObjectID) ([]*models.Message, error) {
+	cur, err := m.manager.Find(bson.M{"room_id": roomID, "pinned_at": bson.M{"$exists": true}, "hidden_at": bson.M{"$exists": false}}, map[string]int{"pinned_at": -1}, db.Pagination{})
+	if err != nil || cur == nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer cur.Close(ctx)
+	messages := []*models.Message{}
+	for cur.Next(ctx) {
+		msg := new(models.Message)
+		if err := cur.Decode(msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, cur.Err()
+}
+
+// PinnedIDs returns ids of all pinned messages of room, hidden ones included
+func (m *Message) PinnedIDs(roomID primitive.ObjectID) ([]int64, error) {
+	values, err := m.manager.Distinct("_id", bson.M{"room_id": roomID, "pinned_at": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(int64); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// ForEachInRoom calls fn for every message of room, oldest first, deleted ones included
+func (m *Message) ForEachInRoom(ctx context.Context, roomID primitive.ObjectID, fn func(msg *models.Message) error) error {
+	cur, err := m.manager.Find(bson.M{"room_id": roomID}, map[string]int{"_id": 1}, db.Pagination{})
+	if err != nil || cur == nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+	for cur.Next(ctx) {
+		msg := new(models.Message)
+		if err := cur.Decode(msg); err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (m *Message) lastID() (int64, error) {
+	cur, err := m.manager.Find(bson.M{}, map[string]int{"_id": -1}, db.Pagination{Limit: 1})
+	if err != nil || cur == nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		return 0, cur.Err()
+	}
+	msg := new(models.Message)
+	if err := cur.Decode(msg); err != nil {
+		return 0, err
+	}
+	return msg.ID, nil
+}
+
+// RoomIDs returns ids of all rooms that have messages
+func (m *Message) RoomIDs() ([]primitive.ObjectID, error) {
+	values, err := m.manager.Distinct("room_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// CountByUsers counts messages of users in room
+func (m *Message) CountByUsers(roomID primitive.ObjectID, userIDs []string) (int64, error) {
+	return m.manager.Count(bson.M{"room_id": roomID, "user_id": bson.M{"$in": userIDs}})
+}
+
+// ThreadIDs returns ids of room messages that have replies
+func (m *Message) ThreadIDs(roomID primitive.ObjectID) ([]int64, error) {
+	values, err := m.manager.Distinct("_id", bson.M{"room_id": roomID, "reply_count": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(int64); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// RemoveByRooms deletes messages of rooms, messages of exceptUsers are kept
+func (m *Message) RemoveByRooms(roomIDs []primitive.ObjectID, exceptUsers []string) (int64, error) {
+	filter := bson.M{"room_id": bson.M{"$in": roomIDs}}
+	if len(exceptUsers) > 0 {
+		filter["user_id"] = bson.M{"$nin": exceptUsers}
+	}
+	return m.manager.RemoveMany(filter)
+}
+
+// ExpiredIDs returns up to limit ids of room messages created before cutoff, skipping messages of exceptUsers.
+// For deletion thread parents that still have replies are skipped, so replies are never left without parent.
+// Otherwise already redacted messages are skipped
+func (m *Message) ExpiredIDs(roomID primitive.ObjectID, cutoff time.Time, exceptUsers []string, deleting bool, limit int) ([]int64, error) {
+	filter := bson.M{
+		"room_id":    roomID,
+		"created_at": bson.M{"$lt": primitive.NewDateTimeFromTime(cutoff)},
+	}
+	if len(exceptUsers) > 0 {
+		filter["user_id"] = bson.M{"$nin": exceptUsers}
+	}
+	if deleting {
+		filter["reply_count"] = bson.M{"$not": bson.M{"$gt": 0}}
+	} else {
+		filter["redacted_at"] = bson.M{"$exists": false}
+	}
+	cur, err := m.manager.Find(filter, map[string]int{"created_at": 1}, db.Pagination{Limit: int64(limit)})
+	if err != nil || cur == nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer cur.Close(ctx)
+	var ids []int64
+	for cur.Next(ctx) {
+		msg := new(models.Message)
+		if err := cur.Decode(msg); err != nil {
+			return nil, err
+		}
+		ids = append(ids, msg.ID)
+	}
+	return ids, cur.Err()
+}
+
+func (m *Message) RemoveByIDs(ids []int64) (int64, error) {
+	return m.manager.RemoveMany(bson.M{"_id": bson.M{"$in": ids}})
+}
+
+// Redact drops content of messages but keeps them in history so threads and cursors stay consistent
+func (m *Message) Redact(ids []int64) (int64, error) {
+	return m.manager.UpdateMany(bson.M{"_id": bson.M{"$in": ids}}, bson.M{
+		"$set": bson.M{
+			"parts":       []models.MessagePart{},
+			"redacted_at": primitive.NewDateTimeFromTime(time.Now()),
+		},
+	})
 }
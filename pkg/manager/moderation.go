@@ -0,0 +1,189 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package manager
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var (
+	sanctionIndexes = []db.Index{
+		{Fields: []string{"room_id", "user_id", "kind"}, IsUnique: true},
+		{Fields: []string{"expires_at"}},
+	}
+	moderationLogIndexes = []db.Index{
+		{Fields: []string{"room_id", "_id"}},
+	}
+)
+
+// Moderation keeps bans and mutes of room members along with log of moderation actions
+type Moderation struct {
+	sanctions *db.Manager
+	log       *db.Manager
+}
+
+func NewModeration(sanctions *mongo.Collection, log *mongo.Collection) (*Moderation, error) {
+	sanctionManager, err := db.NewManager(sanctions, nil)
+	if err != nil {
+		return nil, err
+	}
+	logManager, err := db.NewManager(log, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Moderation{sanctions: sanctionManager, log: logManager}, nil
+}
+
+func (m *Moderation) EnsureIndexes() error {
+	if err := m.sanctions.EnsureIndexes(sanctionIndexes); err != nil {
+		return err
+	}
+	return m.log.EnsureIndexes(moderationLogIndexes)
+}
+
+// Impose stores sanction replacing previous one of the same kind, so repeated ban updates its reason and expiry
+func (m *Moderation) Impose(s *models.Sanction) error {
+	filter := bson.M{"room_id": s.RoomID, "user_id": s.UserID, "kind": s.Kind}
+	if s.ExpiresAt == 0 {
+		if _, err := m.sanctions.UpdateMany(filter, bson.M{"$unset": bson.M{"expires_at": ""}}); err != nil {
+			return err
+		}
+	}
+	return m.sanctions.Upsert(filter, s)
+}
+
+// Lift removes sanction, returns false when user had none of this kind
+func (m *Moderation) Lift(roomID primitive.ObjectID, userID string, kind string) (bool, error) {
+	n, err := m.sanctions.RemoveMany(bson.M{"room_id": roomID, "user_id": userID, "kind": kind})
+	return n > 0, err
+}
+
+// Active returns sanctions of user in room that haven't expired yet, ban goes first
+func (m *Moderation) Active(roomID primitive.ObjectID, userID string) ([]*models.Sanction, error) {
+	return m.findSanctions(activeFilter(bson.M{"room_id": roomID, "user_id": userID}), map[string]int{"kind": 1}, 0)
+}
+
+// Banned reports whether user has active ban in room
+func (m *Moderation) Banned(roomID primitive.ObjectID, userID string) (bool, error) {
+	n, err := m.sanctions.Count(activeFilter(bson.M{"room_id": roomID, "user_id": userID, "kind": models.SanctionBan}))
+	return n > 0, err
+}
+
+// Sanctions lists active sanctions of kind in room, oldest first
+func (m *Moderation) Sanctions(roomID primitive.ObjectID, kind string) ([]*models.Sanction, error) {
+	return m.findSanctions(activeFilter(bson.M{"room_id": roomID, "kind": kind}), map[string]int{"created_at": 1}, 0)
+}
+
+// Expired returns up to limit sanctions whose expiry passed
+func (m *Moderation) Expired(now time.Time, limit int) ([]*models.Sanction, error) {
+	return m.findSanctions(bson.M{
+		"expires_at": bson.M{"$lte": primitive.NewDateTimeFromTime(now)},
+	}, map[string]int{"expires_at": 1}, int64(limit))
+}
+
+// Expire removes sanction unless it was prolonged after it was loaded, returns false in that case
+func (m *Moderation) Expire(s *models.Sanction) (bool, error) {
+	n, err := m.sanctions.RemoveMany(bson.M{"_id": s.ID, "expires_at": bson.M{"$lte": s.ExpiresAt}})
+	return n > 0, err
+}
+
+// Record appends action to moderation log of room
+func (m *Moderation) Record(e *models.ModerationEntry) error {
+	e.ID = primitive.NewObjectID()
+	e.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	_, err := m.log.Add(e)
+	return err
+}
+
+// Log pages through moderation log of room newest first starting before beforeID
+func (m *Moderation) Log(roomID primitive.ObjectID, beforeID primitive.ObjectID, limit int) ([]*models.ModerationEntry, error) {
+	filter := bson.M{"room_id": roomID}
+	if !beforeID.IsZero() {
+		filter["_id"] = bson.M{"$lt": beforeID}
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	cur, err := m.log.Find(filter, map[string]int{"_id": -1}, db.Pagination{Limit: int64(limit)})
+	if err != nil || cur == nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer cur.Close(ctx)
+	entries := []*models.ModerationEntry{}
+	for cur.Next(ctx) {
+		e := new(models.ModerationEntry)
+		if err := cur.Decode(e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, cur.Err()
+}
+
+// RemoveByRooms drops sanctions and moderation log of rooms, used when rooms are deleted
+func (m *Moderation) RemoveByRooms(roomIDs []primitive.ObjectID) error {
+	filter := bson.M{"room_id": bson.M{"$in": roomIDs}}
+	if _, err := m.sanctions.RemoveMany(filter); err != nil {
+		return err
+	}
+	_, err := m.log.RemoveMany(filter)
+	return err
+}
+
+func (m *Moderation) findSanctions(filter bson.M, sort map[string]int, limit int64) ([]*models.Sanction, error) {
+	cur, err := m.sanctions.Find(filter, sort, db.Pagination{Limit: limit})
+	if err != nil || cur == nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer cur.Close(ctx)
+	sanctions := []*models.Sanction{}
+	for cur.Next(ctx) {
+		s := new(models.Sanction)
+		if err := cur.Decode(s); err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, s)
+	}
+	return sanctions, cur.Err()
+}
+
+// activeFilter narrows filter to sanctions without expiry or expiring in future,
+// expired ones may linger until the worker lifts them
+func activeFilter(filter bson.M) bson.M {
+	filter["$or"] = bson.A{
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		bson.M{"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}},
+	}
+	return filter
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package manager
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var reportIndexes = []db.Index{
+	{Fields: []string{"message_id", "reporter_id"}, IsUnique: true},
+	{Fields: []string{"room_id", "status", "_id"}},
+	{Fields: []string{"status", "_id"}},
+}
+
+// Report keeps reports of messages made by room members
+type Report struct {
+	manager *db.Manager
+}
+
+func NewReport(collection *mongo.Collection) (*Report, error) {
+	manager, err := db.NewManager(collection, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{manager: manager}, nil
+}
+
+func (m *Report) EnsureIndexes() error {
+	return m.manager.EnsureIndexes(reportIndexes)
+}
+
+// Add stores open report, duplicate key error means reporter already reported the message
+func (m *Report) Add(r *models.Report) error {
+	r.ID = primitive.NewObjectID()
+	r.Status = models.ReportOpen
+	r.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	_, err := m.manager.Add(r)
+	return err
+}
+
+func (m *Report) FindInRoom(roomID primitive.ObjectID, id string) (*models.Report, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	r := new(models.Report)
+	return r, m.manager.FindOne(bson.M{"_id": oid, "room_id": roomID}, r)
+}
+
+// Reported reports whether user already reported message, resolved reports included
+func (m *Report) Reported(messageID int64, reporterID string) (bool, error) {
+	n, err := m.manager.Count(bson.M{"message_id": messageID, "reporter_id": reporterID})
+	return n > 0, err
+}
+
+// CountOpen returns number of users with open reports of message, so duplicates that got past
+// the unique index of instances created before it can't hide message on their own
+func (m *Report) CountOpen(messageID int64) (int64, error) {
+	reporters, err := m.manager.Distinct("reporter_id", bson.M{"message_id": messageID, "status": models.ReportOpen})
+	return int64(len(reporters)), err
+}
+
+// Find pages through reports with status newest first starting before beforeID, roomID filters by room unless it is nil
+func (m *Report) Find(roomID primitive.ObjectID, status string, beforeID primitive.ObjectID, limit int) ([]*models.Report, error) {
+	filter := bson.M{"status": status}
+	if !roomID.IsZero() {
+		filter["room_id"] = roomID
+	}
+	if !beforeID.IsZero() {
+		filter["_id"] = bson.M{"$lt": beforeID}
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	cur, err := m.manager.Find(filter, map[string]int{"_id": -1}, db.Pagination{Limit: int64(limit)})
+	if err != nil || cur == nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer cur.Close(ctx)
+	reports := []*models.Report{}
+	for cur.Next(ctx) {
+		r := new(models.Report)
+		if err := cur.Decode(r); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, cur.Err()
+}
+
+// Resolve closes every open report of message with resolution at time at and returns how many were closed,
+// zero means another moderator resolved them first
+func (m *Report) Resolve(messageID int64, resolution string, by string, at primitive.DateTime) (int64, error) {
+	return m.manager.UpdateMany(bson.M{"message_id": messageID, "status": models.ReportOpen}, bson.M{
+		"$set": bson.M{
+			"status":      models.ReportResolved,
+			"resolution":  resolution,
+			"resolved_by": by,
+			"resolved_at": at,
+		},
+	})
+}
+
+// Reopen undoes Resolve made at time at, used when resolution action failed
+func (m *Report) Reopen(messageID int64, at primitive.DateTime) error {
+	_, err := m.manager.UpdateMany(bson.M{"message_id": messageID, "status": models.ReportResolved, "resolved_at": at}, bson.M{
+		"$set":   bson.M{"status": models.ReportOpen},
+		"$unset": bson.M{"resolution": "", "resolved_by": "", "resolved_at": ""},
+	})
+	return err
+}
+
+// RemoveByRooms drops reports of rooms, used when rooms are deleted
+func (m *Report) RemoveByRooms(roomIDs []primitive.ObjectID) error {
+	_, err := m.manager.RemoveMany(bson.M{"room_id": bson.M{"$in": roomIDs}})
+	return err
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package manager
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var retentionIndexes = []db.Index{
+	{Fields: []string{"room_id", "_id"}},
+}
+
+// Retention keeps audit trail of messages purged by retention policies
+type Retention struct {
+	manager *db.Manager
+}
+
+func NewRetention(collection *mongo.Collection) (*Retention, error) {
+	manager, err := db.NewManager(collection, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Retention{manager: manager}, nil
+}
+
+func (m *Retention) EnsureIndexes() error {
+	return m.manager.EnsureIndexes(retentionIndexes)
+}
+
+// Record writes pending audit record of batch about to be purged
+func (m *Retention) Record(roomID primitive.ObjectID, policy *models.Retention, cutoff time.Time, ids []int64) (*models.RetentionAudit, error) {
+	a := &models.RetentionAudit{
+		ID:         primitive.NewObjectID(),
+		RoomID:     roomID,
+		Action:     policy.Action,
+		Days:       policy.Days,
+		Cutoff:     primitive.NewDateTimeFromTime(cutoff),
+		MessageIDs: ids,
+		Status:     models.RetentionAuditPending,
+		CreatedAt:  primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := m.manager.Add(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Confirm marks batch of audit record purged, count is number of messages actually deleted or redacted
+func (m *Retention) Confirm(id primitive.ObjectID, count int64) error {
+	return m.manager.Update(id, bson.M{
+		"status":       models.RetentionAuditDone,
+		"count":        count,
+		"completed_at": primitive.NewDateTimeFromTime(time.Now()),
+	})
+}
+
+// Find pages through audit records newest first starting before beforeID, roomID filters by room unless it is nil
+func (m *Retention) Find(roomID primitive.ObjectID, beforeID primitive.ObjectID, limit int) ([]*models.RetentionAudit, error) {
+	filter := bson.M{}
+	if !roomID.IsZero() {
+		filter["room_id"] = roomID
+	}
+	if !beforeID.IsZero() {
+		filter["_id"] = bson.M{"$lt": beforeID}
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	cur, err := m.manager.Find(filter, map[string]int{"_id": -1}, db.Pagination{Limit: int64(limit)})
+	if err != nil || cur == nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer cur.Close(ctx)
+	records := []*models.RetentionAudit{}
+	for cur.Next(ctx) {
+		a := new(models.RetentionAudit)
+		if err := cur.Decode(a); err != nil {
+			return nil, err
+		}
+		records = append(records, a)
+	}
+	return records, cur.Err()
+}
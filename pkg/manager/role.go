@@ -0,0 +1,266 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package manager
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var (
+	ErrRoleExists = errors.New("role already exists")
+	ErrRoleScope  = errors.New("role scope must be global or room")
+)
+
+var (
+	roleIndexes = []db.Index{
+		{Fields: []string{"name", "scope"}, IsUnique: true},
+	}
+	userRoleIndexes = []db.Index{
+		{Fields: []string{"user_id", "room_id"}, IsUnique: true},
+	}
+)
+
+// Role manages roles and their assignments to users. User has at most one global role and one role per room
+type Role struct {
+	roles     *db.Manager
+	userRoles *db.Manager
+}
+
+func NewRole(roles *mongo.Collection, userRoles *mongo.Collection) (*Role, error) {
+	rm, err := db.NewManager(roles, nil)
+	if err != nil {
+		return nil, err
+	}
+	urm, err := db.NewManager(userRoles, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Role{roles: rm, userRoles: urm}, nil
+}
+
+func (m *Role) EnsureIndexes() error {
+	if err := m.roles.EnsureIndexes(roleIndexes); err != nil {
+		return err
+	}
+	return m.userRoles.EnsureIndexes(userRoleIndexes)
+}
+
+func (m *Role) CreateRole(name string, scope string, permissions []string) (*models.Role, error) {
+	if scope != models.RoleScopeGlobal && scope != models.RoleScopeRoom {
+		return nil, ErrRoleScope
+	}
+	if _, err := m.FindRole(name, scope); err == nil {
+		return nil, ErrRoleExists
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if permissions == nil {
+		permissions = []string{}
+	}
+	r := &models.Role{Name: name, Scope: scope, Permissions: permissions}
+	if _, err := m.roles.Add(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (m *Role) FindRole(name string, scope string) (*models.Role, error) {
+	r := new(models.Role)
+	return r, m.roles.FindOne(bson.M{"name": name, "scope": scope}, r)
+}
+
+func (m *Role) FindRoles() ([]*models.Role, error) {
+	cur, err := m.roles.Find(bson.M{}, map[string]int{"name": 1}, db.Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	if cur == nil {
+		return nil, nil
+	}
+	defer cur.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var roles []*models.Role
+	for cur.Next(ctx) {
+		r := new(models.Role)
+		if err := cur.Decode(r); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, cur.Err()
+}
+
+// RemoveRole deletes role together with all its assignments
+func (m *Role) RemoveRole(name string, scope string) error {
+	n, err := m.roles.RemoveMany(bson.M{"name": name, "scope": scope})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = m.userRoles.RemoveMany(bson.M{"role_name": name, "scope": scope})
+	return err
+}
+
+func (m *Role) UpdatePermissions(name string, scope string, add []string, remove []string) (*models.Role, error) {
+	if len(add) > 0 {
+		if _, err := m.roles.UpdateMany(
+			bson.M{"name": name, "scope": scope},
+			bson.M{"$addToSet": bson.M{"permissions": bson.M{"$each": add}}},
+		); err != nil {
+			return nil, err
+		}
+	}
+	if len(remove) > 0 {
+		if _, err := m.roles.UpdateMany(
+			bson.M{"name": name, "scope": scope},
+			bson.M{"$pull": bson.M{"permissions": bson.M{"$in": remove}}},
+		); err != nil {
+			return nil, err
+		}
+	}
+	return m.FindRole(name, scope)
+}
+
+// Assign gives user a role, room scoped when roomID is set. Previous role in the same scope is replaced
+func (m *Role) Assign(userID string, roleName string, roomID string) (*models.UserRole, error) {
+	scope := models.RoleScopeGlobal
+	if roomID != "" {
+		scope = models.RoleScopeRoom
+	}
+	if _, err := m.FindRole(roleName, scope); err != nil {
+		return nil, err
+	}
+	if err := m.Unassign(userID, roomID); err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	ur := &models.UserRole{UserID: userID, RoleName: roleName, Scope: scope, RoomID: roomID}
+	if _, err := m.userRoles.Add(ur); err != nil {
+		return nil, err
+	}
+	return ur, nil
+}
+
+// Unassign removes user role in room, or global role when roomID is empty
+func (m *Role) Unassign(userID string, roomID string) error {
+	filter := bson.M{"user_id": userID, "room_id": bson.M{"$exists": false}}
+	if roomID != "" {
+		filter["room_id"] = roomID
+	}
+	n, err := m.userRoles.RemoveMany(filter)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// UnassignAll removes every role of user, used when user is deleted
+func (m *Role) UnassignAll(userID string) error {
+	_, err := m.userRoles.RemoveMany(bson.M{"user_id": userID})
+	return err
+}
+
+// UnassignRooms removes role assignments in rooms, used when rooms are deleted
+func (m *Role) UnassignRooms(roomIDs []string) error {
+	_, err := m.userRoles.RemoveMany(bson.M{"room_id": bson.M{"$in": roomIDs}})
+	return err
+}
+
+// Can reports whether user has permission in room. Room role of user takes precedence over global one
+func (m *Role) Can(userID string, roomID string, permission string) (bool, error) {
+	assigned, err := m.findUserRoles(bson.M{
+		"user_id": userID,
+		"$or": []bson.M{
+			{"room_id": roomID},
+			{"room_id": bson.M{"$exists": false}},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	var effective *models.UserRole
+	for _, ur := range assigned {
+		if effective == nil || ur.Scope == models.RoleScopeRoom {
+			effective = ur
+		}
+	}
+	if effective == nil {
+		return false, nil
+	}
+	role, err := m.FindRole(effective.RoleName, effective.Scope)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, p := range role.Permissions {
+		if p == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Role) UserRoles(userID string) ([]*models.UserRole, error) {
+	return m.findUserRoles(bson.M{"user_id": userID})
+}
+
+// Assignments returns every role assignment of instance
+func (m *Role) Assignments() ([]*models.UserRole, error) {
+	return m.findUserRoles(bson.M{})
+}
+
+func (m *Role) findUserRoles(filter bson.M) ([]*models.UserRole, error) {
+	cur, err := m.userRoles.Find(filter, map[string]int{"room_id": 1}, db.Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	if cur == nil {
+		return nil, nil
+	}
+	defer cur.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var roles []*models.UserRole
+	for cur.Next(ctx) {
+		r := new(models.UserRole)
+		if err := cur.Decode(r); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, cur.Err()
+}
@@ -22,11 +22,30 @@ THE SOFTWARE.
 package manager
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/models"
 )
 
+var roomIndexes = []db.Index{
+	{Fields: []string{"member_user_ids"}},
+	{Fields: []string{"private"}},
+	{Fields: []string{"dm_key"}, IsUnique: true},
+}
+
 type Room struct {
 	manager *db.Manager
 }
@@ -40,3 +59,337 @@ func NewRoom(collection *mongo.Collection) (*Room, error) {
 		manager: manager,
 	}, nil
 }
+
+func (m *Room) EnsureIndexes() error {
+	return m.manager.EnsureIndexes(roomIndexes)
+}
+
+// CreateRoom creates room, creator is always a member
+func (m *Room) CreateRoom(creatorID string, name string, private bool, pushNotificationTitleOverride string, customData interface{}, userIDs []string) (*models.Room, error) {
+	bCustomData, err := json.Marshal(customData)
+	if err != nil {
+		return nil, err
+	}
+	members := []string{creatorID}
+	for _, id := range userIDs {
+		if id != creatorID {
+			members = append(members, id)
+		}
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	r := &models.Room{
+		ID:                            primitive.NewObjectID(),
+		Name:                          name,
+		Private:                       private,
+		PushNotificationTitleOverride: pushNotificationTitleOverride,
+		CreatedByID:                   creatorID,
+		CreatedAt:                     now,
+		UpdatedAt:                     now,
+		CustomData:                    bCustomData,
+		MemberUserIDs:                 members,
+	}
+	if _, err := m.manager.Add(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// FindOrCreateDM returns direct message room of exactly these members, creating it when missing.
+// Unique index on member set key makes concurrent calls end up with the same room
+func (m *Room) FindOrCreateDM(creatorID string, memberIDs []string) (*models.Room, bool, error) {
+	members := dmMembers(creatorID, memberIDs)
+	key := dmKey(members)
+	r := new(models.Room)
+	err := m.manager.FindOne(bson.M{"dm_key": key}, r)
+	if err == nil {
+		return r, false, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, false, err
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	r = &models.Room{
+		ID:            primitive.NewObjectID(),
+		Private:       true,
+		CreatedByID:   creatorID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		CustomData:    json.RawMessage("null"),
+		MemberUserIDs: members,
+		DM:            true,
+		DMKey:         key,
+	}
+	if _, err := m.manager.Add(r); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, false, err
+		}
+		existing := new(models.Room)
+		return existing, false, m.manager.FindOne(bson.M{"dm_key": key}, existing)
+	}
+	return r, true, nil
+}
+
+// dmMembers returns sorted unique member ids, creator included
+func dmMembers(creatorID string, memberIDs []string) []string {
+	seen := map[string]bool{creatorID: true}
+	members := []string{creatorID}
+	for _, id := range memberIDs {
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, id)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+func dmKey(members []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(members, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Insert stores room as is, keeping its timestamps
+func (m *Room) Insert(r *models.Room) error {
+	_, err := m.manager.Add(r)
+	return err
+}
+
+// Update changes room fields, empty name, nil pointers and nil custom data are left as is
+func (m *Room) Update(id primitive.ObjectID, name string, private *bool, pushNotificationTitleOverride *string, customData interface{}) error {
+	set := bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())}
+	if name != "" {
+		set["name"] = name
+	}
+	if private != nil {
+		set["private"] = *private
+	}
+	if pushNotificationTitleOverride != nil {
+		set["push_notification_title_override"] = *pushNotificationTitleOverride
+	}
+	if customData != nil {
+		bCustomData, err := json.Marshal(customData)
+		if err != nil {
+			return err
+		}
+		set["custom_data"] = json.RawMessage(bCustomData)
+	}
+	return m.manager.Update(id, set)
+}
+
+func (m *Room) FindByID(id string) (*models.Room, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	r := new(models.Room)
+	return r, m.manager.FindOne(bson.M{"_id": oid}, r)
+}
+
+func (m *Room) FindByIDs(ids []primitive.ObjectID) ([]*models.Room, error) {
+	return m.find(bson.M{"_id": bson.M{"$in": ids}}, db.Pagination{})
+}
+
+// RoomFilter selects rooms for List, Name matches rooms whose name contains it ignoring case
+type RoomFilter struct {
+	FromID          string
+	Limit           int
+	IncludePrivate  bool
+	IncludeArchived bool
+	Name            string
+}
+
+// Find pages through rooms in creation order starting after fromID, archived rooms included
+func (m *Room) Find(fromID string, limit int, includePrivate bool) ([]*models.Room, error) {
+	return m.List(RoomFilter{FromID: fromID, Limit: limit, IncludePrivate: includePrivate, IncludeArchived: true})
+}
+
+// List pages through rooms matching filter in creation order
+func (m *Room) List(f RoomFilter) ([]*models.Room, error) {
+	filter := bson.M{}
+	if f.FromID != "" {
+		oid, err := primitive.ObjectIDFromHex(f.FromID)
+		if err != nil {
+			return nil, err
+		}
+		filter["_id"] = bson.M{"$gt": oid}
+	}
+	if !f.IncludePrivate {
+		filter["private"] = false
+	}
+	if !f.IncludeArchived {
+		filter["archived_at"] = bson.M{"$exists": false}
+	}
+	if f.Name != "" {
+		filter["name"] = primitive.Regex{Pattern: regexp.QuoteMeta(f.Name), Options: "i"}
+	}
+	limit := f.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	return m.find(filter, db.Pagination{Limit: int64(limit)})
+}
+
+func (m *Room) JoinedRooms(userID string) ([]*models.Room, error) {
+	return m.find(bson.M{"member_user_ids": userID}, db.Pagination{})
+}
+
+// JoinableRooms returns public rooms user is not member of
+func (m *Room) JoinableRooms(userID string) ([]*models.Room, error) {
+	return m.find(bson.M{
+		"private":         false,
+		"dm":              bson.M{"$ne": true},
+		"archived_at":     bson.M{"$exists": false},
+		"member_user_ids": bson.M{"$ne": userID},
+	}, db.Pagination{})
+}
+
+func (m *Room) Remove(id primitive.ObjectID) error {
+	return m.manager.Remove(id)
+}
+
+// TouchLastMessage moves last message time of room forward
+func (m *Room) TouchLastMessage(id primitive.ObjectID, at primitive.DateTime) error {
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, bson.M{"$max": bson.M{"last_message_at": at}})
+	return err
+}
+
+// Archive makes room read only, returns false when it is already archived
+func (m *Room) Archive(id primitive.ObjectID, by string) (bool, error) {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, "archived_at": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"archived_at": now, "archived_by": by, "updated_at": now},
+	})
+	return n > 0, err
+}
+
+// Unarchive restores room, returns false when it is not archived
+func (m *Room) Unarchive(id primitive.ObjectID) (bool, error) {
+	n, err := m.manager.UpdateMany(bson.M{"_id": id, "archived_at": bson.M{"$exists": true}}, bson.M{
+		"$unset": bson.M{"archived_at": "", "archived_by": ""},
+		"$set":   bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	return n > 0, err
+}
+
+// SetFilters replaces message filter rules of room, empty rules remove them
+func (m *Room) SetFilters(id primitive.ObjectID, rules []models.FilterRule) error {
+	update := bson.M{"$set": bson.M{"filters": rules}}
+	if len(rules) == 0 {
+		update = bson.M{"$unset": bson.M{"filters": ""}}
+	}
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, update)
+	return err
+}
+
+// SetRetention overrides instance retention policy for room, nil policy falls back to instance one
+func (m *Room) SetRetention(id primitive.ObjectID, retention *models.Retention) error {
+	update := bson.M{"$set": bson.M{"retention": retention}}
+	if retention == nil {
+		update = bson.M{"$unset": bson.M{"retention": ""}}
+	}
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, update)
+	return err
+}
+
+// SetLegalHold exempts room messages from retention
+func (m *Room) SetLegalHold(id primitive.ObjectID, hold bool) error {
+	update := bson.M{"$set": bson.M{"legal_hold": true}}
+	if !hold {
+		update = bson.M{"$unset": bson.M{"legal_hold": ""}}
+	}
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, update)
+	return err
+}
+
+func (m *Room) AddMembers(id primitive.ObjectID, userIDs []string) error {
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, bson.M{
+		"$addToSet": bson.M{"member_user_ids": bson.M{"$each": userIDs}},
+		"$set":      bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	return err
+}
+
+func (m *Room) RemoveMembers(id primitive.ObjectID, userIDs []string) error {
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, bson.M{
+		"$pull": bson.M{"member_user_ids": bson.M{"$in": userIDs}},
+		"$set":  bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	return err
+}
+
+// RemoveUserEverywhere drops user from members of all rooms, used when user is deleted
+func (m *Room) RemoveUserEverywhere(userID string) error {
+	_, err := m.manager.UpdateMany(
+		bson.M{"member_user_ids": userID},
+		bson.M{"$pull": bson.M{"member_user_ids": userID}},
+	)
+	return err
+}
+
+// InitPins fills pin slots of room pinned before slots were kept on room, rooms that already have slots are left as is
+func (m *Room) InitPins(id primitive.ObjectID, messageIDs []int64) error {
+	_, err := m.manager.UpdateMany(bson.M{"_id": id, "pinned_message_ids": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"pinned_message_ids": messageIDs},
+	})
+	return err
+}
+
+// ReservePin takes pin slot of room for message, returns false when message already has one or all limit slots are taken
+func (m *Room) ReservePin(id primitive.ObjectID, messageID int64, limit int) (bool, error) {
+	if limit <= 0 {
+		return false, nil
+	}
+	n, err := m.manager.UpdateMany(bson.M{
+		"_id":                id,
+		"pinned_message_ids": bson.M{"$ne": messageID},
+		"pinned_message_ids." + strconv.Itoa(limit-1): bson.M{"$exists": false},
+	}, bson.M{
+		"$push": bson.M{"pinned_message_ids": messageID},
+	})
+	return n > 0, err
+}
+
+// ReleasePins frees pin slots of messages
+func (m *Room) ReleasePins(id primitive.ObjectID, messageIDs []int64) error {
+	_, err := m.manager.UpdateMany(bson.M{"_id": id}, bson.M{
+		"$pull": bson.M{"pinned_message_ids": bson.M{"$in": messageIDs}},
+	})
+	return err
+}
+
+// MemberIDs returns ids of all users that are members of at least one room
+func (m *Room) MemberIDs() ([]string, error) {
+	values, err := m.manager.Distinct("member_user_ids", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *Room) find(filter bson.M, pagination db.Pagination) ([]*models.Room, error) {
+	cur, err := m.manager.Find(filter, map[string]int{"_id": 1}, pagination)
+	if err != nil {
+		return nil, err
+	}
+	if cur == nil {
+		return nil, nil
+	}
+	defer cur.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var rooms []*models.Room
+	for cur.Next(ctx) {
+		r := new(models.Room)
+		if err := cur.Decode(r); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, r)
+	}
+	return rooms, cur.Err()
+}
@@ -34,6 +34,10 @@ import (
 	"github.com/neonxp/chatcloud/pkg/models"
 )
 
+var userIndexes = []db.Index{
+	{Fields: []string{"created_at"}},
+}
+
 type User struct {
 	manager *db.Manager
 }
@@ -48,6 +52,10 @@ func NewUser(collection *mongo.Collection) (*User, error) {
 	}, nil
 }
 
+func (m *User) EnsureIndexes() error {
+	return m.manager.EnsureIndexes(userIndexes)
+}
+
 func (m *User) CreateUser(id string, name string, avatarUrl string, customData interface{}) (*models.User, error) {
 	bCustomData, err := json.Marshal(customData)
 	if err != nil {
@@ -67,13 +75,19 @@ func (m *User) CreateUser(id string, name string, avatarUrl string, customData i
 	return u, nil
 }
 
+// Insert stores user as is, keeping its timestamps
+func (m *User) Insert(u *models.User) error {
+	_, err := m.manager.Add(u)
+	return err
+}
+
 func (m *User) FindByID(id string) (*models.User, error) {
 	u := new(models.User)
 	return u, m.manager.FindOne(bson.M{"_id": id}, u)
 }
 func (m *User) FindByIDs(ids []string) ([]*models.User, error) {
 	cur, err := m.manager.Find(
-		bson.M{"_id": ids},
+		bson.M{"_id": bson.M{"$in": ids}},
 		map[string]int{"created_at": -1},
 		db.Pagination{Offset: 0, Limit: 0},
 	)
@@ -109,9 +123,10 @@ func (m *User) Find(fromTS time.Time, limit int) ([]*models.User, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 20
 	}
+	// Ascending order lets clients page forward with the last seen created_at
 	cur, err := m.manager.Find(
 		filter,
-		map[string]int{"created_at": -1},
+		map[string]int{"created_at": 1},
 		db.Pagination{
 			Offset: 0,
 			Limit:  int64(limit),
@@ -136,3 +151,53 @@ func (m *User) Find(fromTS time.Time, limit int) ([]*models.User, error) {
 	}
 	return users, nil
 }
+
+// Update changes user fields, empty strings and nil custom data are left as is
+func (m *User) Update(id string, name string, avatarURL string, customData interface{}) error {
+	set := bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())}
+	if name != "" {
+		set["name"] = name
+	}
+	if avatarURL != "" {
+		set["avatar_url"] = avatarURL
+	}
+	if customData != nil {
+		bCustomData, err := json.Marshal(customData)
+		if err != nil {
+			return err
+		}
+		set["custom_data"] = json.RawMessage(bCustomData)
+	}
+	return m.manager.Update(id, set)
+}
+
+func (m *User) Remove(id string) error {
+	return m.manager.Remove(id)
+}
+
+func (m *User) SetLegalHold(id string, hold bool) error {
+	update := bson.M{"$set": bson.M{"legal_hold": true}}
+	if !hold {
+		update = bson.M{"$unset": bson.M{"legal_hold": ""}}
+	}
+	n, err := m.manager.UpdateMany(bson.M{"_id": id}, update)
+	if err == nil && n == 0 {
+		_, err = m.FindByID(id)
+	}
+	return err
+}
+
+// HeldIDs returns ids of users whose messages are exempt from retention
+func (m *User) HeldIDs() ([]string, error) {
+	values, err := m.manager.Distinct("_id", bson.M{"legal_hold": true})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxQuoteDepth = 5
+
+var (
+	fenceRe   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ ]*(.*)$")
+	quoteRe   = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	bulletRe  = regexp.MustCompile(`^ {0,3}([-*+]) +(\S.*)$`)
+	orderedRe = regexp.MustCompile(`^ {0,3}(\d{1,9})([.)]) +(\S.*)$`)
+	langRe    = regexp.MustCompile(`^[A-Za-z0-9_+#.-]{1,32}$`)
+)
+
+// blocks renders lines as sequence of blocks, text of blocks is separated with empty line
+func blocks(lines []string, depth int) (string, string, error) {
+	var htmlOut strings.Builder
+	var texts []string
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		var h, t string
+		var err error
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+			continue
+		case fenceRe.MatchString(line):
+			h, t, i = fence(lines, i)
+		case quoteRe.MatchString(line):
+			var inner []string
+			for ; i < len(lines) && quoteRe.MatchString(lines[i]); i++ {
+				inner = append(inner, quoteRe.FindStringSubmatch(lines[i])[1])
+			}
+			h, t, err = quote(inner, depth)
+		case bulletRe.MatchString(line) || orderedRe.MatchString(line):
+			h, t, i, err = list(lines, i)
+		default:
+			start := i
+			for i++; i < len(lines) && !interrupts(lines[i]); i++ {
+			}
+			h, t, err = paragraph(lines[start:i])
+		}
+		if err != nil {
+			return "", "", err
+		}
+		htmlOut.WriteString(h)
+		texts = append(texts, t)
+	}
+	return htmlOut.String(), strings.Join(texts, "\n\n"), nil
+}
+
+// interrupts reports whether line ends paragraph, ordered list interrupts it only when it starts with 1
+// so that numbers like years at line start stay text
+func interrupts(line string) bool {
+	if strings.TrimSpace(line) == "" || fenceRe.MatchString(line) || quoteRe.MatchString(line) || bulletRe.MatchString(line) {
+		return true
+	}
+	m := orderedRe.FindStringSubmatch(line)
+	return m != nil && m[1] == "1"
+}
+
+func paragraph(lines []string) (string, string, error) {
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	h, t, err := inline(strings.Join(lines, "\n"))
+	if err != nil {
+		return "", "", err
+	}
+	return "<p>" + h + "</p>", t, nil
+}
+
+// fence renders fenced code block starting at lines[i], unclosed block runs to the end of content
+func fence(lines []string, i int) (string, string, int) {
+	m := fenceRe.FindStringSubmatch(lines[i])
+	marker := m[1]
+	var lang string
+	if info := strings.Fields(m[2]); len(info) > 0 && langRe.MatchString(info[0]) {
+		lang = info[0]
+	}
+	var code []string
+	for i++; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, marker) && strings.Trim(trimmed, marker[:1]) == "" && len(lines[i])-len(strings.TrimLeft(lines[i], " ")) <= 3 {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+	text := strings.Join(code, "\n")
+	var h strings.Builder
+	h.WriteString("<pre><code")
+	if lang != "" {
+		h.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+	}
+	h.WriteString(">")
+	if len(code) > 0 {
+		h.WriteString(html.EscapeString(text) + "\n")
+	}
+	h.WriteString("</code></pre>")
+	return h.String(), text, i
+}
+
+func quote(lines []string, depth int) (string, string, error) {
+	if depth >= maxQuoteDepth {
+		return "", "", unsafe("block quotes are nested deeper than %d levels", maxQuoteDepth)
+	}
+	h, t, err := blocks(lines, depth+1)
+	if err != nil {
+		return "", "", err
+	}
+	quoted := strings.Split(t, "\n")
+	for i, line := range quoted {
+		quoted[i] = strings.TrimRight("> "+line, " ")
+	}
+	return "<blockquote>" + h + "</blockquote>", strings.Join(quoted, "\n"), nil
+}
+
+// list renders items of the same list starting at lines[i], lines that don't start new block continue last item
+func list(lines []string, i int) (string, string, int, error) {
+	ordered := !bulletRe.MatchString(lines[i])
+	marker := func(line string) (string, string, string) {
+		if ordered {
+			if m := orderedRe.FindStringSubmatch(line); m != nil {
+				return m[2], m[1], m[3]
+			}
+			return "", "", ""
+		}
+		if m := bulletRe.FindStringSubmatch(line); m != nil {
+			return m[1], "", m[2]
+		}
+		return "", "", ""
+	}
+	delim, number, _ := marker(lines[i])
+	start, _ := strconv.Atoi(number)
+	var items [][]string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if d, _, content := marker(line); d == delim {
+			items = append(items, []string{content})
+			continue
+		}
+		if interrupts(line) {
+			break
+		}
+		last := len(items) - 1
+		items[last] = append(items[last], strings.TrimSpace(line))
+	}
+	var h strings.Builder
+	var texts []string
+	switch {
+	case !ordered:
+		h.WriteString("<ul>")
+	case start != 1:
+		h.WriteString(`<ol start="` + strconv.Itoa(start) + `">`)
+	default:
+		h.WriteString("<ol>")
+	}
+	for n, item := range items {
+		ih, it, err := inline(strings.Join(item, "\n"))
+		if err != nil {
+			return "", "", i, err
+		}
+		h.WriteString("<li>" + ih + "</li>")
+		bullet := "- "
+		if ordered {
+			bullet = strconv.Itoa(start+n) + ". "
+		}
+		texts = append(texts, bullet+strings.Replace(it, "\n", "\n"+strings.Repeat(" ", len(bullet)), -1))
+	}
+	if ordered {
+		h.WriteString("</ol>")
+	} else {
+		h.WriteString("</ul>")
+	}
+	return h.String(), strings.Join(texts, "\n"), i, nil
+}
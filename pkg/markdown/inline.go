@@ -0,0 +1,392 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxInlineDepth = 16
+	// labelLimit bounds search for closing bracket of link text
+	labelLimit = 1000
+)
+
+var (
+	tagRe    = regexp.MustCompile(`^(?:<[A-Za-z][A-Za-z0-9-]*(?:\s[^<>]*)?/?>|</[A-Za-z][A-Za-z0-9-]*\s*>|<!--|<\?|<![A-Za-z]|<!\[CDATA\[)`)
+	schemeRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]{1,31}:`)
+	emailRe  = regexp.MustCompile(`^[A-Za-z0-9.!#$%&'*+/=?^_{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+)
+
+// inline renders spans of paragraph or list item, line breaks are kept
+func inline(s string) (string, string, error) {
+	p := new(spans)
+	if err := p.render(s, false, 0); err != nil {
+		return "", "", err
+	}
+	return p.html.String(), p.text.String(), nil
+}
+
+type spans struct {
+	html strings.Builder
+	text strings.Builder
+}
+
+func (p *spans) literal(s string) {
+	p.html.WriteString(html.EscapeString(s))
+	p.text.WriteString(s)
+}
+
+func (p *spans) render(s string, inLink bool, depth int) error {
+	if depth > maxInlineDepth {
+		return unsafe("formatting is nested deeper than %d levels", maxInlineDepth)
+	}
+	// noCloser remembers from which position delimiter has no closer, so unbalanced delimiters are scanned once
+	noCloser := map[string]int{}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			p.lineBreak()
+			i += 2
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			p.literal(s[i+1 : i+2])
+			i += 2
+		case c == '\n':
+			p.lineBreak()
+			i++
+		case c == '`':
+			n := runLength(s, i)
+			end := codeEnd(s, i+n, n)
+			if end < 0 {
+				p.literal(s[i : i+n])
+				i += n
+				break
+			}
+			code := strings.Replace(s[i+n:end], "\n", " ", -1)
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+				code = code[1 : len(code)-1]
+			}
+			p.html.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			p.text.WriteString(code)
+			i = end + n
+		case c == '<':
+			if end, label, dest := autolink(s, i); end > 0 && !inLink {
+				if err := p.link(label, dest, inLink, depth); err != nil {
+					return err
+				}
+				i = end
+				break
+			}
+			if tagRe.MatchString(s[i:]) {
+				return unsafe("raw HTML is not allowed")
+			}
+			p.literal("<")
+			i++
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if _, _, end := linkAt(s, i+1); end > 0 {
+				return unsafe("images are not supported, send them as url parts")
+			}
+			p.literal("!")
+			i++
+		case c == '[' && !inLink:
+			label, dest, end := linkAt(s, i)
+			if end < 0 {
+				p.literal("[")
+				i++
+				break
+			}
+			if err := p.link(label, dest, inLink, depth); err != nil {
+				return err
+			}
+			i = end
+		case c == '*' || c == '_' || c == '~':
+			n := runLength(s, i)
+			key := s[i : i+n]
+			from, failed := noCloser[key]
+			if (n > 3 || (c == '~' && n != 2)) || !canOpen(s, i, n) || (failed && i >= from) {
+				p.literal(key)
+				i += n
+				break
+			}
+			end := closer(s, i+n, c, n)
+			if end < 0 {
+				noCloser[key] = i
+				p.literal(key)
+				i += n
+				break
+			}
+			open, close := "<em>", "</em>"
+			switch {
+			case c == '~':
+				open, close = "<del>", "</del>"
+			case n == 2:
+				open, close = "<strong>", "</strong>"
+			case n == 3:
+				open, close = "<strong><em>", "</em></strong>"
+			}
+			p.html.WriteString(open)
+			if err := p.render(s[i+n:end], inLink, depth+1); err != nil {
+				return err
+			}
+			p.html.WriteString(close)
+			i = end + n
+		case (c == 'h' || c == 'H') && !inLink && (i == 0 || !isWord(s[i-1])):
+			end := bareURL(s, i)
+			if end < 0 {
+				p.literal(s[i : i+1])
+				i++
+				break
+			}
+			if err := p.link(s[i:end], s[i:end], inLink, depth); err != nil {
+				return err
+			}
+			i = end
+		default:
+			j := i + 1
+			for j < len(s) && !strings.ContainsRune("\\\n`<![*_~hH", rune(s[j])) {
+				j++
+			}
+			p.literal(s[i:j])
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *spans) lineBreak() {
+	p.html.WriteString("<br>")
+	p.text.WriteString("\n")
+}
+
+// link renders label linking to dest, text keeps destination after label unless label is the destination itself
+func (p *spans) link(label string, dest string, inLink bool, depth int) error {
+	href, err := checkLink(dest)
+	if err != nil {
+		return err
+	}
+	p.html.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer">`)
+	before := p.text.Len()
+	if err := p.render(label, true, depth+1); err != nil {
+		return err
+	}
+	p.html.WriteString("</a>")
+	if text := p.text.String()[before:]; text != dest && "mailto:"+text != dest {
+		p.text.WriteString(" (" + dest + ")")
+	}
+	return nil
+}
+
+// checkLink allows absolute http, https and mailto URLs only
+func checkLink(dest string) (string, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", unsafe("link %q is not valid URL", dest)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", unsafe("link %q has no host", dest)
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", unsafe("link %q has no address", dest)
+		}
+	case "":
+		return "", unsafe("link %q must be absolute http, https or mailto URL", dest)
+	default:
+		return "", unsafe("link scheme %q is not allowed", u.Scheme)
+	}
+	return u.String(), nil
+}
+
+// linkAt parses [label](dest) at s[i], end is -1 when there is no link
+func linkAt(s string, i int) (label string, dest string, end int) {
+	depth := 0
+	j := i + 1
+	for ; j < len(s) && j-i <= labelLimit; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+			continue
+		case '[':
+			depth++
+			continue
+		case ']':
+			depth--
+		default:
+			continue
+		}
+		if depth < 0 {
+			break
+		}
+	}
+	if j >= len(s) || s[j] != ']' || depth >= 0 || j == i+1 || j+1 >= len(s) || s[j+1] != '(' {
+		return "", "", -1
+	}
+	label = s[i+1 : j]
+	parens := 0
+	for k := j + 2; k < len(s); k++ {
+		switch c := s[k]; {
+		case c == ' ' || c == '\n' || c == '<' || c == '>':
+			return "", "", -1
+		case c == '(':
+			parens++
+		case c == ')' && parens > 0:
+			parens--
+		case c == ')':
+			if k == j+2 {
+				return "", "", -1
+			}
+			return label, s[j+2 : k], k + 1
+		}
+	}
+	return "", "", -1
+}
+
+// autolink parses <scheme:...> or <address@host> at s[i]
+func autolink(s string, i int) (end int, label string, dest string) {
+	n := strings.IndexByte(s[i:], '>')
+	if n < 0 {
+		return -1, "", ""
+	}
+	label = s[i+1 : i+n]
+	switch {
+	case label == "" || strings.ContainsAny(label, " \n<"):
+		return -1, "", ""
+	case emailRe.MatchString(label):
+		return i + n + 1, label, "mailto:" + label
+	case schemeRe.MatchString(label):
+		return i + n + 1, label, label
+	}
+	return -1, "", ""
+}
+
+// bareURL returns end of http or https URL starting at s[i], trailing punctuation and unbalanced parenthesis are left out
+func bareURL(s string, i int) int {
+	rest := strings.ToLower(s[i:])
+	if !strings.HasPrefix(rest, "http://") && !strings.HasPrefix(rest, "https://") {
+		return -1
+	}
+	end := i
+	for end < len(s) && !strings.ContainsRune(" \n<", rune(s[end])) {
+		end++
+	}
+	for end > i {
+		last := s[end-1]
+		if strings.IndexByte(".,:;!?'\"*_~", last) >= 0 {
+			end--
+			continue
+		}
+		if last == ')' && strings.Count(s[i:end], "(") < strings.Count(s[i:end], ")") {
+			end--
+			continue
+		}
+		break
+	}
+	if u, err := url.Parse(s[i:end]); err != nil || u.Host == "" {
+		return -1
+	}
+	return end
+}
+
+// codeEnd returns start of backtick run of length n closing code span, or -1
+func codeEnd(s string, from int, n int) int {
+	for j := from; j < len(s); {
+		if s[j] != '`' {
+			j++
+			continue
+		}
+		m := runLength(s, j)
+		if m == n {
+			return j
+		}
+		j += m
+	}
+	return -1
+}
+
+// closer returns start of delimiter run closing emphasis opened with run of n c, code spans and escapes are skipped
+func closer(s string, from int, c byte, n int) int {
+	for j := from; j < len(s); {
+		switch s[j] {
+		case '\\':
+			j += 2
+		case '`':
+			m := runLength(s, j)
+			if end := codeEnd(s, j+m, m); end >= 0 {
+				j = end + m
+			} else {
+				j += m
+			}
+		case c:
+			m := runLength(s, j)
+			if m == n && canClose(s, j, n) {
+				return j
+			}
+			j += m
+		default:
+			j++
+		}
+	}
+	return -1
+}
+
+// canOpen requires run to be followed by non space, underscore also can't open inside of a word
+func canOpen(s string, i int, n int) bool {
+	if i+n >= len(s) || isSpace(s[i+n]) {
+		return false
+	}
+	return s[i] != '_' || i == 0 || !isWord(s[i-1])
+}
+
+// canClose requires run to be preceded by non space, underscore also can't close inside of a word
+func canClose(s string, j int, n int) bool {
+	if isSpace(s[j-1]) {
+		return false
+	}
+	return s[j] != '_' || j+n >= len(s) || !isWord(s[j+n])
+}
+
+func runLength(s string, i int) int {
+	n := 1
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n'
+}
+
+// isWord treats non ASCII bytes as letters, so underscores inside of words in any script stay text
+func isWord(c byte) bool {
+	return c >= 0x80 || c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
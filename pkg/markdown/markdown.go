@@ -0,0 +1,87 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package markdown renders text/markdown message parts to sanitized HTML and plain text,
+// so every client shows the same thing and notifications get text without markup.
+//
+// Supported subset of CommonMark:
+//   - paragraphs, every line break is kept as hard break like chat users expect
+//   - block quotes with ">", nested up to 5 levels
+//   - bullet lists with "-", "*" or "+" and ordered lists with "1." or "1)", not nested
+//   - fenced code blocks with ``` or ~~~ and optional language
+//   - emphasis with * or _, strong with ** or __, strikethrough with ~~
+//   - code spans, backslash escapes
+//   - links [text](url), autolinks <url> and bare http and https URLs
+//
+// Everything else, like headings and tables, is shown as written. Raw HTML, images
+// and links other than http, https and mailto are rejected.
+package markdown
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContentType is type of message parts rendered by this package
+const ContentType = "text/markdown"
+
+// MaxSize bounds source of single part in bytes
+const MaxSize = 16 << 10
+
+// UnsafeError rejects markdown that can't be rendered safely or the same way on all clients
+type UnsafeError struct {
+	Reason string
+}
+
+func (e *UnsafeError) Error() string {
+	return "unsafe markdown: " + e.Reason
+}
+
+func unsafe(format string, args ...interface{}) error {
+	return &UnsafeError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Is reports whether part type is markdown, parameters like variant are ignored
+func Is(partType string) bool {
+	mediaType, _, err := mime.ParseMediaType(partType)
+	return err == nil && mediaType == ContentType
+}
+
+// Render returns sanitized HTML and plain text of src, errors are *UnsafeError
+func Render(src string) (html string, text string, err error) {
+	if len(src) > MaxSize {
+		return "", "", unsafe("content is longer than %d bytes", MaxSize)
+	}
+	if !utf8.ValidString(src) {
+		return "", "", unsafe("content is not valid UTF-8")
+	}
+	for _, r := range src {
+		if (r < 0x20 && r != '\n' && r != '\r' && r != '\t') || r == 0x7f {
+			return "", "", unsafe("control character %U is not allowed", r)
+		}
+	}
+	src = strings.Replace(src, "\r\n", "\n", -1)
+	src = strings.Replace(src, "\r", "\n", -1)
+	src = strings.Replace(src, "\t", "    ", -1)
+	return blocks(strings.Split(src, "\n"), 0)
+}
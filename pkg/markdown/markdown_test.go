@@ -0,0 +1,165 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package markdown
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const rel = ` rel="nofollow noopener noreferrer"`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		html string
+		text string
+	}{
+		{"plain", "plain text", "<p>plain text</p>", "plain text"},
+		{"line breaks", "one\ntwo", "<p>one<br>two</p>", "one\ntwo"},
+		{"paragraphs", "one\n\ntwo", "<p>one</p><p>two</p>", "one\n\ntwo"},
+		{"crlf", "one\r\ntwo", "<p>one<br>two</p>", "one\ntwo"},
+		{"entities and quotes", `a < b & c > "d" 'e'`, "<p>a &lt; b &amp; c &gt; &#34;d&#34; &#39;e&#39;</p>", `a < b & c > "d" 'e'`},
+		{"escaped entity", "&amp; &lt;", "<p>&amp;amp; &amp;lt;</p>", "&amp; &lt;"},
+		{"lone angle bracket", "1 <2", "<p>1 &lt;2</p>", "1 <2"},
+		{"emphasis", "*em* **strong** ***both*** ~~del~~ _u_", "<p><em>em</em> <strong>strong</strong> <strong><em>both</em></strong> <del>del</del> <em>u</em></p>", "em strong both del u"},
+		{"nested emphasis", "**bold *nested* bold**", "<p><strong>bold <em>nested</em> bold</strong></p>", "bold nested bold"},
+		{"underscores in words", "snake_case_name", "<p>snake_case_name</p>", "snake_case_name"},
+		{"unclosed emphasis", "*unclosed", "<p>*unclosed</p>", "*unclosed"},
+		{"backslash escapes", `\*not em\* \<b>`, "<p>*not em* &lt;b&gt;</p>", "*not em* <b>"},
+		{"code span", "`<b>code</b>`", "<p><code>&lt;b&gt;code&lt;/b&gt;</code></p>", "<b>code</b>"},
+		{"fenced code", "```go\nif a < b {}\n```", `<pre><code class="language-go">if a &lt; b {}` + "\n</code></pre>", "if a < b {}"},
+		{"quote", "> quote\n> more", "<blockquote><p>quote<br>more</p></blockquote>", "> quote\n> more"},
+		{"bullet list", "- one\n- two", "<ul><li>one</li><li>two</li></ul>", "- one\n- two"},
+		{"ordered list", "3. three\n4. four", `<ol start="3"><li>three</li><li>four</li></ol>`, "3. three\n4. four"},
+		{"heading is text", "# heading", "<p># heading</p>", "# heading"},
+		{"link", "[site](https://example.com/)", `<p><a href="https://example.com/"` + rel + ">site</a></p>", "site (https://example.com/)"},
+		{"link href escaped", `[q](https://example.com/?a=1&b="2")`, `<p><a href="https://example.com/?a=1&amp;b=&#34;2&#34;"` + rel + ">q</a></p>", `q (https://example.com/?a=1&b="2")`},
+		{"link text escaped", "[a & 'b'](http://example.com)", `<p><a href="http://example.com"` + rel + ">a &amp; &#39;b&#39;</a></p>", "a & 'b' (http://example.com)"},
+		{"emphasis in link", "[**bold** link](http://example.com)", `<p><a href="http://example.com"` + rel + "><strong>bold</strong> link</a></p>", "bold link (http://example.com)"},
+		{"mailto link", "[mail](mailto:a@example.com)", `<p><a href="mailto:a@example.com"` + rel + ">mail</a></p>", "mail (mailto:a@example.com)"},
+		{"mixed case scheme", "[x](HTTPS://example.com)", `<p><a href="https://example.com"` + rel + ">x</a></p>", "x (HTTPS://example.com)"},
+		{"autolink", "<https://example.com/x>", `<p><a href="https://example.com/x"` + rel + ">https://example.com/x</a></p>", "https://example.com/x"},
+		{"email autolink", "<user@example.com>", `<p><a href="mailto:user@example.com"` + rel + ">user@example.com</a></p>", "user@example.com"},
+		{"bare url", "see https://example.com/path?q=1&r=2.", `<p>see <a href="https://example.com/path?q=1&amp;r=2"` + rel + ">https://example.com/path?q=1&amp;r=2</a>.</p>", "see https://example.com/path?q=1&r=2."},
+		{"bare url in parenthesis", "(https://example.com/a_(b))", `<p>(<a href="https://example.com/a_(b)"` + rel + ">https://example.com/a_(b)</a>)</p>", "(https://example.com/a_(b))"},
+		{"upper case bare url", "HTTPS://EXAMPLE.COM", `<p><a href="https://EXAMPLE.COM"` + rel + ">HTTPS://EXAMPLE.COM</a></p>", "HTTPS://EXAMPLE.COM"},
+		{"url inside word is text", "xhttp://example.com", "<p>xhttp://example.com</p>", "xhttp://example.com"},
+		{"link in link text is text", "[[a](http://a.example)](http://b.example)", `<p><a href="http://b.example"` + rel + ">[a](http://a.example)</a></p>", "[a](http://a.example) (http://b.example)"},
+		{"every kind of emphasis nested", "*a **b ***c _d __e ___f ~~g~~ f___ e__ d_ c*** b** a*", "<p><em>a <strong>b <strong><em>c <em>d <strong>e <strong><em>f <del>g</del> f</em></strong> e</strong> d</em> c</em></strong> b</strong> a</em></p>", "a b c d e f g f e d c b a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, text, err := Render(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if html != tt.html {
+				t.Errorf("html\n got %q\nwant %q", html, tt.html)
+			}
+			if text != tt.text {
+				t.Errorf("text\n got %q\nwant %q", text, tt.text)
+			}
+		})
+	}
+}
+
+func TestRenderUnsafe(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"tag", "<b>bold</b>"},
+		{"closing tag", "text</div>"},
+		{"script", "<script>alert(1)</script>"},
+		{"tag with attributes", `<img src=x onerror="alert(1)">`},
+		{"self closing tag", "<br/>"},
+		{"comment", "<!-- hidden -->"},
+		{"processing instruction", "<?php ?>"},
+		{"declaration", "<!DOCTYPE html>"},
+		{"cdata", "<![CDATA[x]]>"},
+		{"tag in emphasis", "*<i>x</i>*"},
+		{"tag in link text", "[a <b> c](https://example.com)"},
+		{"javascript link", "[x](javascript:alert(1))"},
+		{"mixed case javascript link", "[x](JaVaScRiPt:alert(1))"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)"},
+		{"vbscript link", "[x](vbscript:msgbox)"},
+		{"javascript autolink", "<javascript:alert(1)>"},
+		{"mixed case data autolink", "<DATA:text/html,x>"},
+		{"relative link", "[x](/path)"},
+		{"protocol relative link", "[x](//example.com)"},
+		{"link without host", "[x](http:///path)"},
+		{"mailto without address", "[x](mailto:)"},
+		{"image", "![alt](https://example.com/a.png)"},
+		{"control character", "bell\a"},
+		{"invalid utf8", "\xff"},
+		{"too long", strings.Repeat("a", MaxSize+1)},
+		{"quotes too deep", strings.Repeat("> ", maxQuoteDepth+1) + "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, text, err := Render(tt.src)
+			var unsafeErr *UnsafeError
+			if !errors.As(err, &unsafeErr) {
+				t.Fatalf("got %q, %q, %v, want *UnsafeError", html, text, err)
+			}
+		})
+	}
+}
+
+// Delimiters of the same kind can't nest, so inline depth limit is checked on spans directly
+func TestDepthLimits(t *testing.T) {
+	if err := new(spans).render("*a* [b](http://example.com)", false, maxInlineDepth-1); err != nil {
+		t.Errorf("formatting at depth %d: %v", maxInlineDepth, err)
+	}
+	for _, src := range []string{"*a*", "[b](http://example.com)"} {
+		err := new(spans).render(src, false, maxInlineDepth)
+		var unsafeErr *UnsafeError
+		if !errors.As(err, &unsafeErr) {
+			t.Errorf("%q at depth %d: got %v, want *UnsafeError", src, maxInlineDepth+1, err)
+		}
+	}
+	if _, _, err := Render(strings.Repeat("> ", maxQuoteDepth) + "x"); err != nil {
+		t.Errorf("%d levels of quotes: %v", maxQuoteDepth, err)
+	}
+}
+
+func TestIs(t *testing.T) {
+	tests := []struct {
+		partType string
+		want     bool
+	}{
+		{"text/markdown", true},
+		{"text/markdown; variant=GFM", true},
+		{"TEXT/Markdown", true},
+		{"text/plain", false},
+		{"text/markdownx", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := Is(tt.partType); got != tt.want {
+			t.Errorf("Is(%q) = %v, want %v", tt.partType, got, tt.want)
+		}
+	}
+}
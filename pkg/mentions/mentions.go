@@ -0,0 +1,81 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package mentions finds @user_id, @room and @here mentions in text parts of messages
+package mentions
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+const (
+	// Room notifies every member of room
+	Room = "room"
+	// Here notifies members that are online
+	Here = "here"
+)
+
+// mentionRe matches @ that starts a word, so e-mail addresses are not mentions
+var mentionRe = regexp.MustCompile(`(?:^|[^\w@])@(\w[\w.\-]*)`)
+
+// Parse returns mentioned user ids in order of appearance and broadcast keyword, Room wins over Here
+func Parse(parts []models.MessagePart) (userIDs []string, broadcast string) {
+	seen := map[string]bool{}
+	for _, part := range parts {
+		if !strings.HasPrefix(part.Type, "text/") {
+			continue
+		}
+		for _, m := range mentionRe.FindAllStringSubmatch(part.Content, -1) {
+			id := strings.TrimRight(m[1], ".-")
+			switch id {
+			case Room:
+				broadcast = Room
+			case Here:
+				if broadcast == "" {
+					broadcast = Here
+				}
+			default:
+				if !seen[id] {
+					seen[id] = true
+					userIDs = append(userIDs, id)
+				}
+			}
+		}
+	}
+	return userIDs, broadcast
+}
+
+// PlainText joins text parts of message, used for notification bodies. Markdown parts give their rendered text
+func PlainText(parts []models.MessagePart) string {
+	var texts []string
+	for _, part := range parts {
+		switch {
+		case part.Text != "":
+			texts = append(texts, part.Text)
+		case strings.HasPrefix(part.Type, "text/") && part.Content != "":
+			texts = append(texts, part.Content)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,51 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+
+	ExportFormatJSONL = "jsonl"
+	ExportFormatCSV   = "csv"
+	ExportFormatHTML  = "html"
+)
+
+// Export is a job producing room transcript, the file is stored in GridFS
+type Export struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	RoomID      primitive.ObjectID `json:"room_id" bson:"room_id"`
+	Format      string             `json:"format" bson:"format"`
+	Status      string             `json:"status" bson:"status"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	RequestedBy string             `json:"requested_by,omitempty" bson:"requested_by,omitempty"`
+	FileID      primitive.ObjectID `json:"-" bson:"file_id,omitempty"`
+	Size        int64              `json:"size" bson:"size"`
+	Messages    int64              `json:"messages" bson:"messages"`
+	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
+	FinishedAt  primitive.DateTime `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	DownloadURL string             `json:"download_url,omitempty" bson:"-"`
+}
@@ -0,0 +1,41 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Instance struct {
+	ID          string             `json:"id" bson:"_id"`
+	Name        string             `json:"name" bson:"name"`
+	KeyID       string             `json:"key_id" bson:"key_id"`
+	Secret      string             `json:"secret,omitempty" bson:"secret"`
+	Database    string             `json:"database,omitempty" bson:"database,omitempty"`
+	Prefix      string             `json:"prefix,omitempty" bson:"prefix,omitempty"`
+	CORSOrigins []string           `json:"cors_origins" bson:"cors_origins"`
+	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
+	Retention   *Retention         `json:"retention,omitempty" bson:"retention,omitempty"`
+	Filters     []FilterRule       `json:"filters,omitempty" bson:"filters,omitempty"`
+	// DeletedAt is set while instance data is being dropped, such instance is treated as missing
+	DeletedAt primitive.DateTime `json:"-" bson:"deleted_at,omitempty"`
+}
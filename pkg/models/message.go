@@ -28,12 +28,38 @@ import (
 )
 
 type Message struct {
-	ID        int64              `json:"id" bson:"_id"`
-	CreatedAt string             `json:"created_at" bson:"created_at"`
-	Parts     []MessagePart      `json:"parts" bson:"parts"`
-	RoomID    primitive.ObjectID `json:"room_id" bson:"room_id"`
-	UpdatedAt primitive.DateTime `json:"updated_at" bson:"updated_at"`
-	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	ID         int64              `json:"id" bson:"_id"`
+	CreatedAt  primitive.DateTime `json:"created_at" bson:"created_at"`
+	Parts      []MessagePart      `json:"parts" bson:"parts"`
+	RoomID     primitive.ObjectID `json:"room_id" bson:"room_id"`
+	UpdatedAt  primitive.DateTime `json:"updated_at" bson:"updated_at"`
+	UserID     string             `json:"user_id" bson:"user_id"`
+	DeletedAt  primitive.DateTime `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	RedactedAt primitive.DateTime `json:"redacted_at,omitempty" bson:"redacted_at,omitempty"`
+	Reactions  []Reaction         `json:"reactions,omitempty" bson:"reactions,omitempty"`
+	// Replies point to thread parent, parent keeps number and time of its replies
+	ParentID    int64              `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	ReplyCount  int64              `json:"reply_count,omitempty" bson:"reply_count,omitempty"`
+	LastReplyAt primitive.DateTime `json:"last_reply_at,omitempty" bson:"last_reply_at,omitempty"`
+	PinnedAt    primitive.DateTime `json:"pinned_at,omitempty" bson:"pinned_at,omitempty"`
+	PinnedBy    string             `json:"pinned_by,omitempty" bson:"pinned_by,omitempty"`
+	// Mentions lists notified users with @room and @here expanded, MentionBroadcast keeps which of them was used
+	Mentions         []string `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	MentionBroadcast string   `json:"mention_broadcast,omitempty" bson:"mention_broadcast,omitempty"`
+	// Flags name filter rules that held message for review, cleared once moderator reviews it
+	Flags []string `json:"flags,omitempty" bson:"flags,omitempty"`
+	// HiddenAt is set once message gets too many reports, hidden messages are left out of history until reviewed
+	HiddenAt primitive.DateTime `json:"hidden_at,omitempty" bson:"hidden_at,omitempty"`
+}
+
+// Edited reports whether message was changed after it was sent
+func (m *Message) Edited() bool {
+	return m.UpdatedAt > m.CreatedAt
+}
+
+// Deleted reports whether message was deleted, deleted messages are kept for history and exports
+func (m *Message) Deleted() bool {
+	return m.DeletedAt != 0
 }
 
 type MessagePart struct {
@@ -41,6 +67,21 @@ type MessagePart struct {
 	Type       string     `json:"type" bson:"type"`
 	URL        string     `json:"url" bson:"url"`
 	Attachment Attachment `json:"attachment" bson:"attachment"`
+	// HTML and Text are rendered by server from Content of text/markdown parts
+	HTML string `json:"html,omitempty" bson:"html,omitempty"`
+	Text string `json:"text,omitempty" bson:"text,omitempty"`
+	// Preview is filled in by server in background after message with URL part is sent
+	Preview *LinkPreview `json:"preview,omitempty" bson:"preview,omitempty"`
+}
+
+// LinkPreview holds OpenGraph or Twitter card metadata of page at part URL
+type LinkPreview struct {
+	URL         string `json:"url" bson:"url"`
+	Title       string `json:"title,omitempty" bson:"title,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	Image       string `json:"image,omitempty" bson:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty" bson:"site_name,omitempty"`
+	Type        string `json:"type,omitempty" bson:"type,omitempty"`
 }
 
 type Attachment struct {
@@ -52,3 +93,10 @@ type Attachment struct {
 	RefreshURL  string             `json:"refresh_url" bson:"refresh_url"`
 	Size        int64              `json:"size" bson:"size"`
 }
+
+// Reaction aggregates users that reacted to message with the same emoji
+type Reaction struct {
+	Reaction string   `json:"reaction" bson:"reaction"`
+	Count    int64    `json:"count" bson:"count"`
+	UserIDs  []string `json:"user_ids" bson:"user_ids"`
+}
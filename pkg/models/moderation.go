@@ -0,0 +1,71 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SanctionBan  = "ban"
+	SanctionMute = "mute"
+)
+
+// Actions recorded in moderation log
+const (
+	ModerationBan    = "ban"
+	ModerationUnban  = "unban"
+	ModerationMute   = "mute"
+	ModerationUnmute = "unmute"
+	ModerationKick   = "kick"
+)
+
+// Sanction restricts user in room, banned users can't join or post and muted ones can't post.
+// Sanction without ExpiresAt lasts until it is lifted.
+type Sanction struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	RoomID    primitive.ObjectID `json:"room_id" bson:"room_id"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	Kind      string             `json:"kind" bson:"kind"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	ExpiresAt primitive.DateTime `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	CreatedBy string             `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+}
+
+func (s *Sanction) Expired(now time.Time) bool {
+	return s.ExpiresAt != 0 && !s.ExpiresAt.Time().After(now)
+}
+
+// ModerationEntry records one moderation action in room, entries without ActorID are made by sanction expiry
+type ModerationEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	RoomID    primitive.ObjectID `json:"room_id" bson:"room_id"`
+	Action    string             `json:"action" bson:"action"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	ActorID   string             `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	ExpiresAt primitive.DateTime `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+}
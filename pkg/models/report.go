@@ -0,0 +1,53 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Resolutions of reports, they apply to every open report of the message
+const (
+	ResolutionDismiss       = "dismiss"
+	ResolutionDeleteMessage = "delete_message"
+	ResolutionBanSender     = "ban_sender"
+)
+
+// Report is complaint of room member about message, each member reports message once
+type Report struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	RoomID     primitive.ObjectID `json:"room_id" bson:"room_id"`
+	MessageID  int64              `json:"message_id" bson:"message_id"`
+	SenderID   string             `json:"sender_id" bson:"sender_id"`
+	ReporterID string             `json:"reporter_id" bson:"reporter_id"`
+	Reason     string             `json:"reason" bson:"reason"`
+	Status     string             `json:"status" bson:"status"`
+	Resolution string             `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	ResolvedBy string             `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+	ResolvedAt primitive.DateTime `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+	CreatedAt  primitive.DateTime `json:"created_at" bson:"created_at"`
+	// Message is attached when reports are listed for moderators
+	Message *Message `json:"message,omitempty" bson:"-"`
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	RetentionDelete = "delete"
+	RetentionRedact = "redact"
+)
+
+const (
+	RetentionAuditPending = "pending"
+	RetentionAuditDone    = "done"
+)
+
+// Retention purges messages older than Days, room policy overrides instance one
+type Retention struct {
+	Days   int    `json:"days" bson:"days"`
+	Action string `json:"action" bson:"action"`
+}
+
+// RetentionAudit records one purged batch of messages. Record is written pending before messages are touched
+// and marked done with actual count afterwards, so pending record means the batch may be partially applied
+type RetentionAudit struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	RoomID     primitive.ObjectID `json:"room_id" bson:"room_id"`
+	Action     string             `json:"action" bson:"action"`
+	Days       int                `json:"days" bson:"days"`
+	Cutoff     primitive.DateTime `json:"cutoff" bson:"cutoff"`
+	MessageIDs []int64            `json:"message_ids" bson:"message_ids"`
+	Count      int64              `json:"count" bson:"count"`
+	// Status is empty in records written before batches were confirmed, they are done
+	Status      string             `json:"status,omitempty" bson:"status,omitempty"`
+	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
+	CompletedAt primitive.DateTime `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
@@ -0,0 +1,49 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package models
+
+const (
+	RoleScopeGlobal = "global"
+	RoleScopeRoom   = "room"
+)
+
+const (
+	// PermissionMessagePin allows pinning and unpinning messages of room
+	PermissionMessagePin = "message:pin"
+	// PermissionRoomArchive allows archiving and restoring room
+	PermissionRoomArchive = "room:archive"
+	// PermissionRoomModerate allows banning, muting and kicking members of room and reading its moderation log
+	PermissionRoomModerate = "room:moderate"
+)
+
+type Role struct {
+	Name        string   `json:"name" bson:"name"`
+	Scope       string   `json:"scope" bson:"scope"`
+	Permissions []string `json:"permissions" bson:"permissions"`
+}
+
+type UserRole struct {
+	UserID   string `json:"user_id,omitempty" bson:"user_id"`
+	RoleName string `json:"role_name" bson:"role_name"`
+	Scope    string `json:"scope" bson:"scope"`
+	RoomID   string `json:"room_id,omitempty" bson:"room_id,omitempty"`
+}
@@ -37,6 +37,23 @@ type Room struct {
 	UpdatedAt                     primitive.DateTime `json:"updated_at" bson:"updated_at"`
 	CreatedAt                     primitive.DateTime `json:"created_at" bson:"created_at"`
 	CustomData                    json.RawMessage    `json:"custom_data" bson:"custom_data"`
+	MemberUserIDs                 []string           `json:"member_user_ids" bson:"member_user_ids"`
+	Retention                     *Retention         `json:"retention,omitempty" bson:"retention,omitempty"`
+	LegalHold                     bool               `json:"legal_hold,omitempty" bson:"legal_hold,omitempty"`
+	// Direct message rooms are private and unique per member set, DMKey is hash of sorted member ids
+	DM    bool   `json:"dm,omitempty" bson:"dm,omitempty"`
+	DMKey string `json:"-" bson:"dm_key,omitempty"`
+	// Archived rooms are read only and hidden from listings unless asked for
+	ArchivedAt primitive.DateTime `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	ArchivedBy string             `json:"archived_by,omitempty" bson:"archived_by,omitempty"`
+	// Filters are applied after instance ones, they are hidden from members so the lists can't be worked around
+	Filters []FilterRule `json:"-" bson:"filters,omitempty"`
+	// PinnedMessageIDs reserves pin slots so the per room limit holds under concurrent pins
+	PinnedMessageIDs []int64 `json:"-" bson:"pinned_message_ids,omitempty"`
+}
+
+func (r *Room) Archived() bool {
+	return r.ArchivedAt != 0
 }
 
 type Membership struct {
@@ -44,14 +61,28 @@ type Membership struct {
 	UserIds []string           `json:"user_ids"`
 }
 
+// CursorTypeRead is the only cursor type, it marks last message read by user
+const CursorTypeRead = 0
+
+type Cursor struct {
+	CursorType int64              `json:"cursor_type" bson:"cursor_type"`
+	Position   int64              `json:"position" bson:"position"`
+	RoomID     string             `json:"room_id" bson:"room_id"`
+	UpdatedAt  primitive.DateTime `json:"updated_at" bson:"updated_at"`
+	UserID     string             `json:"user_id" bson:"user_id"`
+	ThreadID   int64              `json:"thread_id,omitempty" bson:"thread_id,omitempty"`
+}
+
 type RS struct {
-	Cursor struct {
-		CursorType int64  `json:"cursor_type"`
-		Position   int64  `json:"position"`
-		RoomID     string `json:"room_id"`
-		UpdatedAt  string `json:"updated_at"`
-		UserID     string `json:"user_id"`
-	} `json:"cursor"`
-	RoomID      string `json:"room_id"`
-	UnreadCount int64  `json:"unread_count"`
+	Cursor             Cursor `json:"cursor"`
+	RoomID             string `json:"room_id"`
+	UnreadCount        int64  `json:"unread_count"`
+	UnreadMentionCount int64  `json:"unread_mention_count"`
+}
+
+// ThreadRS is read state of user in thread of message
+type ThreadRS struct {
+	Cursor      *Cursor `json:"cursor"`
+	ThreadID    int64   `json:"thread_id"`
+	UnreadCount int64   `json:"unread_count"`
 }
@@ -34,4 +34,5 @@ type User struct {
 	Name       string             `json:"name" bson:"name"`
 	CreatedAt  primitive.DateTime `json:"created_at" bson:"created_at"`
 	UpdatedAt  primitive.DateTime `json:"updated_at" bson:"updated_at"`
+	LegalHold  bool               `json:"legal_hold,omitempty" bson:"legal_hold,omitempty"`
 }
@@ -0,0 +1,146 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package moderation lifts timed bans and mutes once they expire
+package moderation
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/models"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+// batch is number of expired sanctions loaded at once
+const batch = 100
+
+// Worker lifts expired sanctions of every instance, records it in moderation log and notifies subscribers
+type Worker struct {
+	tenants *tenant.Registry
+	hub     *hub.Hub
+	log     logrus.FieldLogger
+}
+
+func NewWorker(tenants *tenant.Registry, h *hub.Hub, log logrus.FieldLogger) *Worker {
+	return &Worker{
+		tenants: tenants,
+		hub:     h,
+		log:     log,
+	}
+}
+
+// Run lifts expired sanctions each interval until ctx is cancelled
+func (w *Worker) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			w.log.WithError(err).Error("sanction sweep failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce lifts expired sanctions of every instance, failure of one instance doesn't stop others
+func (w *Worker) RunOnce(ctx context.Context) error {
+	instances, err := w.tenants.List()
+	if err != nil {
+		return err
+	}
+	for _, i := range instances {
+		t, err := w.tenants.Load(i.ID)
+		if err != nil {
+			w.log.WithError(err).WithField("instance", i.ID).Error("can't open instance")
+			continue
+		}
+		n, err := w.Lift(ctx, t)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			w.log.WithError(err).WithField("instance", i.ID).Error("can't lift sanctions")
+			continue
+		}
+		if n > 0 {
+			w.log.WithField("instance", i.ID).WithField("sanctions", n).Info("expired sanctions lifted")
+		}
+	}
+	return nil
+}
+
+// Lift removes expired sanctions of instance and returns how many were lifted
+func (w *Worker) Lift(ctx context.Context, t *tenant.Tenant) (int, error) {
+	total := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		sanctions, err := t.Moderation.Expired(time.Now(), batch)
+		if err != nil {
+			return total, err
+		}
+		for _, s := range sanctions {
+			lifted, err := t.Moderation.Expire(s)
+			if err != nil {
+				return total, err
+			}
+			if !lifted {
+				continue
+			}
+			total++
+			e := &models.ModerationEntry{
+				RoomID: s.RoomID,
+				Action: liftAction(s.Kind),
+				UserID: s.UserID,
+				Reason: "expired",
+			}
+			if err := t.Moderation.Record(e); err != nil {
+				return total, err
+			}
+			w.publish(hub.RoomChannel(t.Instance.ID, s.RoomID.Hex()), e)
+			w.publish(hub.UserChannel(t.Instance.ID, s.UserID), e)
+		}
+		if len(sanctions) < batch {
+			return total, nil
+		}
+	}
+}
+
+func (w *Worker) publish(channel string, e *models.ModerationEntry) {
+	if err := w.hub.Publish(channel, hub.EventModeration, e); err != nil {
+		w.log.WithError(err).WithField("event", hub.EventModeration).Warn("can't publish event")
+	}
+}
+
+func liftAction(kind string) string {
+	if kind == models.SanctionMute {
+		return models.ModerationUnmute
+	}
+	return models.ModerationUnban
+}
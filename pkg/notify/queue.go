@@ -0,0 +1,102 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package notify queues notifications for push delivery workers
+package notify
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/neonxp/chatcloud/pkg/metrics"
+)
+
+const (
+	// PriorityHigh is used for notifications addressed to user personally, like mentions
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+
+	// bodyLimit keeps notification payloads small, push services limit their size
+	bodyLimit = 200
+)
+
+// ReasonMention is reason of notification sent to mentioned user
+const ReasonMention = "mention"
+
+type Notification struct {
+	Instance  string    `json:"instance"`
+	UserID    string    `json:"user_id"`
+	RoomID    string    `json:"room_id"`
+	MessageID int64     `json:"message_id"`
+	SenderID  string    `json:"sender_id"`
+	Reason    string    `json:"reason"`
+	Priority  string    `json:"priority"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Queue keeps notifications in redis list per instance and priority, delivery workers pop high priority list first.
+// Lists are capped at limit so they don't grow without bound while no worker is running
+// and Enqueue writes them before returning, so the queue holds nothing in memory that would need flushing on shutdown
+type Queue struct {
+	rds   *redis.Client
+	limit int64
+}
+
+func NewQueue(rds *redis.Client, limit int) *Queue {
+	return &Queue{rds: rds, limit: int64(limit)}
+}
+
+// Key returns redis list holding notifications of instance with priority
+func Key(instanceID string, priority string) string {
+	return instanceID + ":notifications:" + priority
+}
+
+// Enqueue appends notifications to lists of their priorities in one round trip, dropping the oldest ones over limit
+func (q *Queue) Enqueue(notifications []*Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	_, err := q.rds.Pipelined(func(p redis.Pipeliner) error {
+		for _, n := range notifications {
+			if r := []rune(n.Body); len(r) > bodyLimit {
+				n.Body = string(r[:bodyLimit-1]) + "…"
+			}
+			b, err := json.Marshal(n)
+			if err != nil {
+				return err
+			}
+			key := Key(n.Instance, n.Priority)
+			p.RPush(key, b)
+			p.LTrim(key, -q.limit, -1)
+		}
+		return nil
+	})
+	outcome := "queued"
+	if err != nil {
+		outcome = "failed"
+	}
+	metrics.Deliveries.WithLabelValues("push", outcome).Add(float64(len(notifications)))
+	return err
+}
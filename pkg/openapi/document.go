@@ -0,0 +1,245 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package openapi
+
+import (
+	"sort"
+	"strings"
+)
+
+// Document is the subset of OpenAPI 3 object model used to describe and validate the API
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components *Components          `json:"components,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Components struct {
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// PathItem holds operations of a path. SUBSCRIBE is not an HTTP method known to OpenAPI, so it is an extension
+type PathItem struct {
+	Parameters []*Parameter `json:"parameters,omitempty"`
+	Get        *Operation   `json:"get,omitempty"`
+	Put        *Operation   `json:"put,omitempty"`
+	Post       *Operation   `json:"post,omitempty"`
+	Delete     *Operation   `json:"delete,omitempty"`
+	Subscribe  *Operation   `json:"x-subscribe,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+const (
+	InPath  = "path"
+	InQuery = "query"
+)
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// NewOperation creates operation answering with errors by default
+func NewOperation(id string, tag string, summary string) *Operation {
+	return &Operation{
+		OperationID: id,
+		Summary:     summary,
+		Tags:        []string{tag},
+		Responses: map[string]*Response{
+			"default": {Description: "Error", Content: jsonContent(ErrorSchema)},
+		},
+	}
+}
+
+// Body sets required JSON request body
+func (o *Operation) Body(s *Schema) *Operation {
+	o.RequestBody = &RequestBody{Required: true, Content: jsonContent(s)}
+	return o
+}
+
+// Query adds query parameter
+func (o *Operation) Query(name string, required bool, s *Schema) *Operation {
+	o.Parameters = append(o.Parameters, &Parameter{Name: name, In: InQuery, Required: required, Schema: s})
+	return o
+}
+
+// Returns describes response with status code, s may be nil for empty body
+func (o *Operation) Returns(code string, description string, s *Schema) *Operation {
+	r := &Response{Description: description}
+	if s != nil {
+		r.Content = jsonContent(s)
+	}
+	o.Responses[code] = r
+	return o
+}
+
+// Secured lists security schemes operation accepts
+func (o *Operation) Secured(schemes ...string) *Operation {
+	for _, scheme := range schemes {
+		o.Security = append(o.Security, map[string][]string{scheme: {}})
+	}
+	return o
+}
+
+// PathParam describes required path parameter
+func PathParam(name string, s *Schema) *Parameter {
+	return &Parameter{Name: name, In: InPath, Required: true, Schema: s}
+}
+
+// ErrorSchema is the shape of every error response
+var ErrorSchema = &Schema{
+	Type: TypeObject,
+	Properties: map[string]*Schema{
+		"code":    {Type: TypeInteger},
+		"message": {Type: TypeString},
+		"errors": {Type: TypeArray, Items: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"field":   {Type: TypeString},
+				"message": {Type: TypeString},
+			},
+		}},
+	},
+	Required: []string{"code", "message"},
+}
+
+func jsonContent(s *Schema) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: s}}
+}
+
+// Operation returns operation registered for method, methods are case insensitive
+func (p *PathItem) Operation(method string) *Operation {
+	switch strings.ToUpper(method) {
+	case "GET":
+		return p.Get
+	case "PUT":
+		return p.Put
+	case "POST":
+		return p.Post
+	case "DELETE":
+		return p.Delete
+	case "SUBSCRIBE":
+		return p.Subscribe
+	}
+	return nil
+}
+
+// Match finds path template matching request path and extracts its parameters.
+// Templates with fewer parameters win, so /users/register is preferred over /users/{user_id}
+func (d *Document) Match(path string) (string, *PathItem, map[string]string) {
+	segments := split(path)
+	var (
+		best       string
+		bestParams map[string]string
+	)
+	for template := range d.Paths {
+		params, ok := match(split(template), segments)
+		if !ok {
+			continue
+		}
+		if bestParams == nil || len(params) < len(bestParams) || (len(params) == len(bestParams) && template < best) {
+			best, bestParams = template, params
+		}
+	}
+	if bestParams == nil {
+		return "", nil, nil
+	}
+	return best, d.Paths[best], bestParams
+}
+
+// Templates returns sorted path templates of document
+func (d *Document) Templates() []string {
+	templates := make([]string, 0, len(d.Paths))
+	for t := range d.Paths {
+		templates = append(templates, t)
+	}
+	sort.Strings(templates)
+	return templates
+}
+
+func match(template []string, segments []string) (map[string]string, bool) {
+	if len(template) != len(segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, t := range template {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[t[1:len(t)-1]] = segments[i]
+			continue
+		}
+		if t != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,256 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeArray   = "array"
+	TypeObject  = "object"
+
+	FormatDateTime = "date-time"
+	FormatURI      = "uri"
+)
+
+// Schema is the subset of JSON schema keywords supported by validator
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+}
+
+// Int returns pointer to n, handy for optional schema keywords
+func Int(n int) *int {
+	return &n
+}
+
+// Float returns pointer to n, handy for optional schema keywords
+func Float(n float64) *float64 {
+	return &n
+}
+
+// Bool returns pointer to b, handy for optional schema keywords
+func Bool(b bool) *bool {
+	return &b
+}
+
+var (
+	patternsMu sync.Mutex
+	patterns   = map[string]*regexp.Regexp{}
+)
+
+// Validate checks decoded JSON value, field is the path reported in errors
+func (s *Schema) Validate(field string, v interface{}) []FieldError {
+	if v == nil {
+		if s.Nullable {
+			return nil
+		}
+		return []FieldError{{Field: field, Message: "must not be null"}}
+	}
+	switch s.Type {
+	case TypeString:
+		str, ok := v.(string)
+		if !ok {
+			return typeError(field, s.Type)
+		}
+		return s.validateString(field, str)
+	case TypeInteger, TypeNumber:
+		var n float64
+		switch num := v.(type) {
+		case float64:
+			n = num
+		case json.Number:
+			f, err := num.Float64()
+			if err != nil {
+				return typeError(field, s.Type)
+			}
+			n = f
+		default:
+			return typeError(field, s.Type)
+		}
+		if s.Type == TypeInteger && n != float64(int64(n)) {
+			return typeError(field, s.Type)
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return []FieldError{{Field: field, Message: fmt.Sprintf("must be at least %v", *s.Minimum)}}
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return []FieldError{{Field: field, Message: fmt.Sprintf("must be at most %v", *s.Maximum)}}
+		}
+	case TypeBoolean:
+		if _, ok := v.(bool); !ok {
+			return typeError(field, s.Type)
+		}
+	case TypeArray:
+		items, ok := v.([]interface{})
+		if !ok {
+			return typeError(field, s.Type)
+		}
+		if s.MinItems != nil && len(items) < *s.MinItems {
+			return []FieldError{{Field: field, Message: fmt.Sprintf("must have at least %d items", *s.MinItems)}}
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			return []FieldError{{Field: field, Message: fmt.Sprintf("must have at most %d items", *s.MaxItems)}}
+		}
+		if s.Items == nil {
+			return nil
+		}
+		var errs []FieldError
+		for i, item := range items {
+			errs = append(errs, s.Items.Validate(fmt.Sprintf("%s[%d]", field, i), item)...)
+		}
+		return errs
+	case TypeObject:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return typeError(field, s.Type)
+		}
+		return s.validateObject(field, obj)
+	}
+	return nil
+}
+
+func (s *Schema) validateString(field string, str string) []FieldError {
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if str == e {
+				return nil
+			}
+		}
+		return []FieldError{{Field: field, Message: "must be one of " + strings.Join(s.Enum, ", ")}}
+	}
+	length := len([]rune(str))
+	if s.MinLength != nil && length < *s.MinLength {
+		if *s.MinLength == 1 {
+			return []FieldError{{Field: field, Message: "must not be empty"}}
+		}
+		return []FieldError{{Field: field, Message: fmt.Sprintf("must be at least %d characters", *s.MinLength)}}
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return []FieldError{{Field: field, Message: fmt.Sprintf("must be at most %d characters", *s.MaxLength)}}
+	}
+	if s.Pattern != "" && !pattern(s.Pattern).MatchString(str) {
+		return []FieldError{{Field: field, Message: "must match " + s.Pattern}}
+	}
+	switch s.Format {
+	case FormatDateTime:
+		if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+			return []FieldError{{Field: field, Message: "must be RFC 3339 date-time"}}
+		}
+	case FormatURI:
+		if u, err := url.Parse(str); err != nil || !u.IsAbs() {
+			return []FieldError{{Field: field, Message: "must be absolute URI"}}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(field string, obj map[string]interface{}) []FieldError {
+	var errs []FieldError
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			errs = append(errs, FieldError{Field: join(field, name), Message: "is required"})
+		}
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, FieldError{Field: join(field, name), Message: "is not allowed"})
+			}
+			continue
+		}
+		errs = append(errs, prop.Validate(join(field, name), obj[name])...)
+	}
+	return errs
+}
+
+// parse converts raw query or path value into JSON value of schema type
+func (s *Schema) parse(raw string) (interface{}, bool) {
+	switch s.Type {
+	case TypeInteger, TypeNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		return n, err == nil
+	case TypeBoolean:
+		b, err := strconv.ParseBool(raw)
+		return b, err == nil
+	}
+	return raw, true
+}
+
+func typeError(field string, typ string) []FieldError {
+	article := "a"
+	if typ == TypeInteger || typ == TypeArray || typ == TypeObject {
+		article = "an"
+	}
+	return []FieldError{{Field: field, Message: fmt.Sprintf("must be %s %s", article, typ)}}
+}
+
+func join(field string, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func pattern(p string) *regexp.Regexp {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	re, ok := patterns[p]
+	if !ok {
+		re = regexp.MustCompile(p)
+		patterns[p] = re
+	}
+	return re
+}
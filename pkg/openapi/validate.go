@@ -0,0 +1,157 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// FieldError describes single invalid request field. Field is prefixed with its location: path, query or body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found in request
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		parts = append(parts, fe.Field+" "+fe.Message)
+	}
+	return "request validation failed: " + strings.Join(parts, "; ")
+}
+
+// ErrNoOperation is returned for requests the document does not describe
+var ErrNoOperation = errors.New("operation is not described")
+
+const maxBodySize = 1 << 20
+
+// ValidateRequest checks path, query and JSON body of request against its operation.
+// Body is read and replaced, so handlers can decode it again
+func (d *Document) ValidateRequest(r *http.Request) error {
+	_, item, pathParams := d.Match(r.URL.Path)
+	if item == nil {
+		return ErrNoOperation
+	}
+	op := item.Operation(r.Method)
+	if op == nil {
+		return ErrNoOperation
+	}
+	var errs []FieldError
+	query := r.URL.Query()
+	for _, p := range append(item.Parameters, op.Parameters...) {
+		switch p.In {
+		case InPath:
+			errs = append(errs, p.validate(pathParams[p.Name])...)
+		case InQuery:
+			values, ok := query[p.Name]
+			if !ok {
+				if p.Required {
+					errs = append(errs, FieldError{Field: "query." + p.Name, Message: "is required"})
+				}
+				continue
+			}
+			if p.Schema.Type == TypeArray {
+				items := make([]interface{}, 0, len(values))
+				for i, v := range values {
+					value, ok := p.Schema.Items.parse(v)
+					if !ok {
+						errs = append(errs, typeError(fmt.Sprintf("query.%s[%d]", p.Name, i), p.Schema.Items.Type)...)
+						continue
+					}
+					items = append(items, value)
+				}
+				errs = append(errs, p.Schema.Validate("query."+p.Name, items)...)
+				continue
+			}
+			errs = append(errs, p.validate(values[0])...)
+		}
+	}
+	if op.RequestBody != nil {
+		bodyErrs, err := validateBody(r, op.RequestBody)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, bodyErrs...)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func (p *Parameter) validate(raw string) []FieldError {
+	field := p.In + "." + p.Name
+	value, ok := p.Schema.parse(raw)
+	if !ok {
+		return typeError(field, p.Schema.Type)
+	}
+	return p.Schema.Validate(field, value)
+}
+
+func validateBody(r *http.Request, body *RequestBody) ([]FieldError, error) {
+	media, ok := body.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil, nil
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != "application/json" {
+			return []FieldError{{Field: "body", Message: "content type must be application/json"}}, nil
+		}
+	}
+	var raw []byte
+	if r.Body != nil {
+		var err error
+		raw, err = ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+		_ = r.Body.Close()
+		if len(raw) > maxBodySize {
+			return []FieldError{{Field: "body", Message: fmt.Sprintf("must be at most %d bytes", maxBodySize)}}, nil
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		if body.Required {
+			return []FieldError{{Field: "body", Message: "is required"}}, nil
+		}
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return []FieldError{{Field: "body", Message: "must be valid JSON"}}, nil
+	}
+	return media.Schema.Validate("body", v), nil
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package openapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testDocument() *Document {
+	item := &PathItem{Parameters: []*Parameter{PathParam("room_id", &Schema{Type: TypeString, Pattern: "^[0-9a-f]{24}$"})}}
+	item.Get = NewOperation("listMessages", "messages", "List messages").
+		Query("limit", false, &Schema{Type: TypeInteger, Minimum: Float(1), Maximum: Float(100)}).
+		Query("direction", true, &Schema{Type: TypeString, Enum: []string{"older", "newer"}}).
+		Query("ids", false, &Schema{Type: TypeArray, MaxItems: Int(2), Items: &Schema{Type: TypeInteger}})
+	item.Post = NewOperation("sendMessage", "messages", "Send message").
+		Body(&Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"parts": {Type: TypeArray, MinItems: Int(1), Items: &Schema{
+					Type: TypeObject,
+					Properties: map[string]*Schema{
+						"type": {Type: TypeString, MinLength: Int(1), MaxLength: Int(8)},
+						"url":  {Type: TypeString, Format: FormatURI},
+					},
+					Required: []string{"type"},
+				}},
+				"at":     {Type: TypeString, Format: FormatDateTime},
+				"silent": {Type: TypeBoolean},
+			},
+			Required:             []string{"parts"},
+			AdditionalProperties: Bool(false),
+		})
+	return &Document{Paths: map[string]*PathItem{"/rooms/{room_id}/messages": item}}
+}
+
+func TestValidateRequest(t *testing.T) {
+	const room = "/rooms/5e8f8f8f8f8f8f8f8f8f8f8f/messages"
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   []FieldError
+	}{
+		{
+			name:   "valid query",
+			method: http.MethodGet,
+			target: room + "?direction=older&limit=10&ids=1&ids=2",
+		},
+		{
+			name:   "bad path parameter",
+			method: http.MethodGet,
+			target: "/rooms/nope/messages?direction=older",
+			want:   []FieldError{{Field: "path.room_id", Message: "must match ^[0-9a-f]{24}$"}},
+		},
+		{
+			name:   "missing required query",
+			method: http.MethodGet,
+			target: room,
+			want:   []FieldError{{Field: "query.direction", Message: "is required"}},
+		},
+		{
+			name:   "query out of range and not in enum",
+			method: http.MethodGet,
+			target: room + "?direction=up&limit=500",
+			want: []FieldError{
+				{Field: "query.limit", Message: "must be at most 100"},
+				{Field: "query.direction", Message: "must be one of older, newer"},
+			},
+		},
+		{
+			name:   "query of wrong type",
+			method: http.MethodGet,
+			target: room + "?direction=older&limit=ten&ids=1&ids=x",
+			want: []FieldError{
+				{Field: "query.limit", Message: "must be an integer"},
+				{Field: "query.ids[1]", Message: "must be an integer"},
+			},
+		},
+		{
+			name:   "too many array items in query",
+			method: http.MethodGet,
+			target: room + "?direction=older&ids=1&ids=2&ids=3",
+			want:   []FieldError{{Field: "query.ids", Message: "must have at most 2 items"}},
+		},
+		{
+			name:   "valid body",
+			method: http.MethodPost,
+			target: room,
+			body:   `{"parts":[{"type":"text/md","url":"https://example.com"}],"at":"2020-04-01T10:00:00Z","silent":true}`,
+		},
+		{
+			name:   "missing body",
+			method: http.MethodPost,
+			target: room,
+			want:   []FieldError{{Field: "body", Message: "is required"}},
+		},
+		{
+			name:   "malformed body",
+			method: http.MethodPost,
+			target: room,
+			body:   `{"parts":`,
+			want:   []FieldError{{Field: "body", Message: "must be valid JSON"}},
+		},
+		{
+			name:   "missing and unknown fields",
+			method: http.MethodPost,
+			target: room,
+			body:   `{"text":"hi"}`,
+			want: []FieldError{
+				{Field: "body.parts", Message: "is required"},
+				{Field: "body.text", Message: "is not allowed"},
+			},
+		},
+		{
+			name:   "nested field errors",
+			method: http.MethodPost,
+			target: room,
+			body:   `{"parts":[{"type":""},{"type":"application/json","url":"/relative"},{}],"at":"yesterday","silent":"yes"}`,
+			want: []FieldError{
+				{Field: "body.at", Message: "must be RFC 3339 date-time"},
+				{Field: "body.parts[0].type", Message: "must not be empty"},
+				{Field: "body.parts[1].type", Message: "must be at most 8 characters"},
+				{Field: "body.parts[1].url", Message: "must be absolute URI"},
+				{Field: "body.parts[2].type", Message: "is required"},
+				{Field: "body.silent", Message: "must be a boolean"},
+			},
+		},
+		{
+			name:   "empty array",
+			method: http.MethodPost,
+			target: room,
+			body:   `{"parts":[]}`,
+			want:   []FieldError{{Field: "body.parts", Message: "must have at least 1 items"}},
+		},
+		{
+			name:   "null field",
+			method: http.MethodPost,
+			target: room,
+			body:   `{"parts":null}`,
+			want:   []FieldError{{Field: "body.parts", Message: "must not be null"}},
+		},
+	}
+	doc := testDocument()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			err := doc.ValidateRequest(r)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("got %v, want validation error", err)
+			}
+			if !reflect.DeepEqual(verr.Errors, tt.want) {
+				t.Errorf("got %+v, want %+v", verr.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRequestKeepsBody(t *testing.T) {
+	const body = `{"parts":[{"type":"text"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/rooms/5e8f8f8f8f8f8f8f8f8f8f8f/messages", strings.NewReader(body))
+	if err := testDocument().ValidateRequest(r); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Errorf("body is %q after validation, want %q", b, body)
+	}
+}
+
+func TestValidateRequestUnknownOperation(t *testing.T) {
+	doc := testDocument()
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/users", nil),
+		httptest.NewRequest(http.MethodDelete, "/rooms/5e8f8f8f8f8f8f8f8f8f8f8f/messages", nil),
+	} {
+		if err := doc.ValidateRequest(r); err != ErrNoOperation {
+			t.Errorf("%s %s: got %v, want ErrNoOperation", r.Method, r.URL.Path, err)
+		}
+	}
+}
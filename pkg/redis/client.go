@@ -22,13 +22,26 @@ THE SOFTWARE.
 package redis
 
 import (
+	"time"
+
 	"github.com/go-redis/redis"
 
 	"github.com/neonxp/chatcloud/pkg/config"
+	"github.com/neonxp/chatcloud/pkg/metrics"
 )
 
-func New(cfg *config.Config) *redis.Client  {
-	return redis.NewClient(&redis.Options{
-		Addr: cfg.Redis,
+func New(cfg *config.Config) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis,
+		Password: cfg.RedisPassword,
+	})
+	client.WrapProcess(func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			start := time.Now()
+			err := old(cmd)
+			metrics.RedisDuration.WithLabelValues(cmd.Name()).Observe(time.Since(start).Seconds())
+			return err
+		}
 	})
+	return client
 }
@@ -0,0 +1,198 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package retention purges messages that outlived retention policy of their room or instance
+package retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+// roomPage is number of rooms loaded at once while walking instance
+const roomPage = 100
+
+// Worker applies retention policies in batches, pausing between batches to keep load on Mongo low
+type Worker struct {
+	tenants *tenant.Registry
+	batch   int
+	pause   time.Duration
+	log     logrus.FieldLogger
+}
+
+func NewWorker(tenants *tenant.Registry, batch int, pause time.Duration, log logrus.FieldLogger) *Worker {
+	return &Worker{
+		tenants: tenants,
+		batch:   batch,
+		pause:   pause,
+		log:     log,
+	}
+}
+
+// Run applies policies of every instance each interval until ctx is cancelled
+func (w *Worker) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			w.log.WithError(err).Error("retention run failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies policies of every instance, failure of one instance doesn't stop others
+func (w *Worker) RunOnce(ctx context.Context) error {
+	instances, err := w.tenants.List()
+	if err != nil {
+		return err
+	}
+	for _, i := range instances {
+		t, err := w.tenants.Load(i.ID)
+		if err != nil {
+			w.log.WithError(err).WithField("instance", i.ID).Error("can't open instance")
+			continue
+		}
+		n, err := w.Apply(ctx, t)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			w.log.WithError(err).WithField("instance", i.ID).Error("can't apply retention")
+			continue
+		}
+		if n > 0 {
+			w.log.WithField("instance", i.ID).WithField("messages", n).Info("retention applied")
+		}
+	}
+	return nil
+}
+
+// Apply purges expired messages of instance and returns how many were deleted or redacted
+func (w *Worker) Apply(ctx context.Context, t *tenant.Tenant) (int64, error) {
+	held, err := t.Users.HeldIDs()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	from := ""
+	for {
+		rooms, err := t.Rooms.Find(from, roomPage, true)
+		if err != nil {
+			return total, err
+		}
+		for _, room := range rooms {
+			n, err := w.applyRoom(ctx, t, room, held)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+		if len(rooms) < roomPage {
+			return total, nil
+		}
+		from = rooms[len(rooms)-1].ID.Hex()
+	}
+}
+
+func (w *Worker) applyRoom(ctx context.Context, t *tenant.Tenant, room *models.Room, held []string) (int64, error) {
+	policy := Policy(t.Instance, room)
+	if policy == nil {
+		return 0, nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -policy.Days)
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		ids, err := t.Messages.ExpiredIDs(room.ID, cutoff, held, policy.Action == models.RetentionDelete, w.batch)
+		if err != nil || len(ids) == 0 {
+			return total, err
+		}
+		// Audit record goes first, so no batch is purged without trace even if worker dies halfway
+		record, err := t.Retention.Record(room.ID, policy, cutoff, ids)
+		if err != nil {
+			return total, err
+		}
+		var n int64
+		if policy.Action == models.RetentionRedact {
+			n, err = t.Messages.Redact(ids)
+		} else {
+			n, err = w.remove(t, room, ids)
+		}
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if err := t.Retention.Confirm(record.ID, n); err != nil {
+			return total, err
+		}
+		if len(ids) < w.batch {
+			return total, nil
+		}
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(w.pause):
+		}
+	}
+}
+
+// remove deletes messages, frees their pin slots and recounts replies of threads they belonged to
+func (w *Worker) remove(t *tenant.Tenant, room *models.Room, ids []int64) (int64, error) {
+	parentIDs, err := t.Messages.ParentIDs(ids)
+	if err != nil {
+		return 0, err
+	}
+	n, err := t.Messages.RemoveByIDs(ids)
+	if err != nil {
+		return n, err
+	}
+	if err := t.Rooms.ReleasePins(room.ID, ids); err != nil {
+		return n, err
+	}
+	return n, t.Messages.RecountReplies(parentIDs)
+}
+
+// Policy returns retention policy effective for room, nil when messages are kept forever or room is on legal hold
+func Policy(instance *models.Instance, room *models.Room) *models.Retention {
+	if room.LegalHold {
+		return nil
+	}
+	policy := room.Retention
+	if policy == nil {
+		policy = instance.Retention
+	}
+	if policy == nil || policy.Days <= 0 {
+		return nil
+	}
+	return policy
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/models"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+)
+
+// GetReadCursor returns read cursor of user in room
+func (s *Server) GetReadCursor(w http.ResponseWriter, r *http.Request) {
+	cursor, err := mw.TenantFromRequest(r).Cursors.Find(mw.RoomFromRequest(r).ID.Hex(), mw.UserFromRequest(r).ID)
+	switch err {
+	case nil:
+		render.JSON(w, r, cursor)
+	case mongo.ErrNoDocuments:
+		pkg.WriteError(w, r, http.StatusNotFound, errors.New("user has no read cursor in room"))
+	default:
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// SetReadCursor marks room read up to position by user, unread and mention counts of read states start after it
+func (s *Server) SetReadCursor(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.CursorRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	user := mw.UserFromRequest(r)
+	cursor := &models.Cursor{
+		CursorType: models.CursorTypeRead,
+		Position:   req.Position,
+		RoomID:     mw.RoomFromRequest(r).ID.Hex(),
+		UpdatedAt:  primitive.NewDateTimeFromTime(time.Now()),
+		UserID:     user.ID,
+	}
+	if err := t.Cursors.Set(cursor); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	s.publish(r, hub.UserChannel(t.Instance.ID, user.ID), hub.EventReadCursor, cursor)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListRoomCursors returns read cursors of room members, recently moved first
+func (s *Server) ListRoomCursors(w http.ResponseWriter, r *http.Request) {
+	cursors, err := mw.TenantFromRequest(r).Cursors.ByRoom(mw.RoomFromRequest(r).ID.Hex())
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, cursors)
+}
+
+// ListUserCursors returns read cursors of user in all rooms, recently moved first
+func (s *Server) ListUserCursors(w http.ResponseWriter, r *http.Request) {
+	cursors, err := mw.TenantFromRequest(r).Cursors.ByUser(mw.UserFromRequest(r).ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, cursors)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+)
+
+// CreateDM returns direct message room of user with other user, creating it on first call
+func (s *Server) CreateDM(w http.ResponseWriter, r *http.Request) {
+	otherID := chi.URLParam(r, "other_id")
+	if otherID == mw.UserFromRequest(r).ID {
+		pkg.WriteError(w, r, http.StatusBadRequest, errors.New("direct message needs another user"))
+		return
+	}
+	s.dm(w, r, []string{otherID})
+}
+
+// CreateGroupDM returns direct message room of user and listed users, the same member set always gets the same room
+func (s *Server) CreateGroupDM(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.GroupDMRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	others := 0
+	for _, id := range req.UserIDs {
+		if id != mw.UserFromRequest(r).ID {
+			others++
+		}
+	}
+	if others == 0 {
+		pkg.WriteError(w, r, http.StatusBadRequest, errors.New("direct message needs another user"))
+		return
+	}
+	s.dm(w, r, req.UserIDs)
+}
+
+func (s *Server) dm(w http.ResponseWriter, r *http.Request, userIDs []string) {
+	t := mw.TenantFromRequest(r)
+	user := mw.UserFromRequest(r)
+	users, err := t.Users.FindByIDs(userIDs)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	found := map[string]bool{}
+	for _, u := range users {
+		found[u.ID] = true
+	}
+	for _, id := range userIDs {
+		if !found[id] {
+			pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("user %s not found", id))
+			return
+		}
+	}
+	room, created, err := t.Rooms.FindOrCreateDM(user.ID, userIDs)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if created {
+		for _, id := range room.MemberUserIDs {
+			s.publish(r, hub.UserChannel(t.Instance.ID, id), hub.EventAddedToRoom, room)
+		}
+		render.Status(r, http.StatusCreated)
+	}
+	render.JSON(w, r, room)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/logger"
+	"github.com/neonxp/chatcloud/pkg/models"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+	"github.com/neonxp/chatcloud/pkg/transcript"
+)
+
+// CreateExport starts transcript generation in background and responds with job to poll
+func (s *Server) CreateExport(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.ExportRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	export, err := t.Exports.Create(room.ID, req.Format, mw.ClaimsFromRequest(r).Subject)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	log := logger.FromRequest(r).WithField("export_id", export.ID.Hex())
+	s.background(func(ctx context.Context) {
+		if err := runExport(ctx, t, room, export); err != nil {
+			log.WithError(err).Error("export failed")
+			if err := t.Exports.SetFailed(export.ID, err); err != nil {
+				log.WithError(err).Error("can't mark export failed")
+			}
+		}
+	})
+	w.Header().Set("Location", exportPath(t, export))
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, export)
+}
+
+func (s *Server) GetExport(w http.ResponseWriter, r *http.Request) {
+	t := mw.TenantFromRequest(r)
+	export, ok := findExport(w, r)
+	if !ok {
+		return
+	}
+	if export.Status == models.ExportDone {
+		export.DownloadURL = exportPath(t, export) + "/download"
+	}
+	render.JSON(w, r, export)
+}
+
+func (s *Server) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	t := mw.TenantFromRequest(r)
+	export, ok := findExport(w, r)
+	if !ok {
+		return
+	}
+	if export.Status != models.ExportDone {
+		pkg.WriteError(w, r, http.StatusConflict, fmt.Errorf("export is %s", export.Status))
+		return
+	}
+	file, size, err := t.Exports.Open(export)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", transcript.ContentType(export.Format))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", transcript.Filename(mw.RoomFromRequest(r), export.Format)))
+	if _, err := io.Copy(w, file); err != nil {
+		logger.FromRequest(r).WithError(err).Warn("export download interrupted")
+	}
+}
+
+func findExport(w http.ResponseWriter, r *http.Request) (*models.Export, bool) {
+	id := chi.URLParam(r, "export_id")
+	export, err := mw.TenantFromRequest(r).Exports.FindByID(mw.RoomFromRequest(r).ID, id)
+	if err == mongo.ErrNoDocuments {
+		pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("export %s not found", id))
+		return nil, false
+	}
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return export, true
+}
+
+// runExport writes transcript straight into GridFS, partial file is discarded on failure
+func runExport(ctx context.Context, t *tenant.Tenant, room *models.Room, export *models.Export) error {
+	if err := t.Exports.SetRunning(export.ID); err != nil {
+		return err
+	}
+	upload, err := t.Exports.Upload(transcript.Filename(room, export.Format))
+	if err != nil {
+		return err
+	}
+	counter := &countingWriter{w: upload}
+	n, err := transcript.Write(ctx, t.Users, t.Messages, room, export.Format, counter)
+	if err != nil {
+		_ = upload.Abort()
+		return err
+	}
+	if err := upload.Close(); err != nil {
+		return err
+	}
+	return t.Exports.SetDone(export.ID, upload.FileID.(primitive.ObjectID), counter.n, n)
+}
+
+func exportPath(t *tenant.Tenant, export *models.Export) string {
+	return "/api/v1/" + t.Instance.ID + "/rooms/" + export.RoomID.Hex() + "/exports/" + export.ID.Hex()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
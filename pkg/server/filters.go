@@ -0,0 +1,159 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/filter"
+	"github.com/neonxp/chatcloud/pkg/models"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+func (s *Server) SetInstanceFilters(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.FiltersRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !s.setInstanceFilters(w, r, req.Rules) {
+		return
+	}
+	t, err := s.tenants.Get(chi.URLParam(r, "instance_id"))
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	instance := *t.Instance
+	instance.Secret = ""
+	render.JSON(w, r, instance)
+}
+
+func (s *Server) DeleteInstanceFilters(w http.ResponseWriter, r *http.Request) {
+	if s.setInstanceFilters(w, r, nil) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) setInstanceFilters(w http.ResponseWriter, r *http.Request, rules []models.FilterRule) bool {
+	err := s.tenants.SetFilters(chi.URLParam(r, "instance_id"), rules)
+	if err == mongo.ErrNoDocuments {
+		pkg.WriteError(w, r, http.StatusNotFound, err)
+		return false
+	}
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+// GetRoomFilters returns filter rules of room, rules are hidden from room representation
+func (s *Server) GetRoomFilters(w http.ResponseWriter, r *http.Request) {
+	rules := mw.RoomFromRequest(r).Filters
+	if rules == nil {
+		rules = []models.FilterRule{}
+	}
+	render.JSON(w, r, map[string][]models.FilterRule{"rules": rules})
+}
+
+func (s *Server) SetRoomFilters(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.FiltersRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err := mw.TenantFromRequest(r).Rooms.SetFilters(mw.RoomFromRequest(r).ID, req.Rules); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) DeleteRoomFilters(w http.ResponseWriter, r *http.Request) {
+	if err := mw.TenantFromRequest(r).Rooms.SetFilters(mw.RoomFromRequest(r).ID, nil); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListFlagged returns messages held for review by filters, newest first
+func (s *Server) ListFlagged(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	initialID, _ := strconv.ParseInt(q.Get("initial_id"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	messages, err := mw.TenantFromRequest(r).Messages.Flagged(mw.RoomFromRequest(r).ID, initialID, limit)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, messages)
+}
+
+// ClearFlags marks flagged message as reviewed
+func (s *Server) ClearFlags(w http.ResponseWriter, r *http.Request) {
+	if _, err := mw.TenantFromRequest(r).Messages.ClearFlags(mw.MessageFromRequest(r).ID); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// filterMessage runs filter chain over parts of msg before it is stored, masking them and setting its flags.
+// It writes error and returns false when message is rejected
+func (s *Server) filterMessage(w http.ResponseWriter, r *http.Request, t *tenant.Tenant, room *models.Room, msg *models.Message, edit bool) bool {
+	in := &filter.Message{
+		InstanceID: t.Instance.ID,
+		RoomID:     room.ID.Hex(),
+		UserID:     msg.UserID,
+		Parts:      msg.Parts,
+	}
+	if edit {
+		in.MessageID = msg.ID
+	}
+	flags, err := s.filters.Apply(r.Context(), in, t.Instance.Filters, room.Filters)
+	var rejected *filter.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		pkg.WriteError(w, r, http.StatusUnprocessableEntity, err)
+		return false
+	case err == filter.ErrUnavailable:
+		pkg.WriteError(w, r, http.StatusServiceUnavailable, err)
+		return false
+	case err != nil:
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	msg.Parts = in.Parts
+	msg.Flags = flags
+	return true
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readinessStatus struct {
+	Status       string                       `json:"status"`
+	Dependencies map[string]*dependencyStatus `json:"dependencies"`
+}
+
+// Healthz reports that the process is alive and serving requests
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, map[string]string{"status": statusOK})
+}
+
+// Readyz pings Mongo and Redis and fails when any of them is unavailable
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	resp := &readinessStatus{
+		Status: statusOK,
+		Dependencies: map[string]*dependencyStatus{
+			"mongo": s.check(r.Context(), func(ctx context.Context) error {
+				return s.db.Client().Ping(ctx, readpref.Primary())
+			}),
+			"redis": s.check(r.Context(), func(ctx context.Context) error {
+				return s.rds.Ping().Err()
+			}),
+		},
+	}
+	for _, dep := range resp.Dependencies {
+		if dep.Status != statusOK {
+			resp.Status = statusFail
+		}
+	}
+	if resp.Status != statusOK {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, resp)
+}
+
+// check runs ping in background so that clients without context support are bounded by the timeout too
+func (s *Server) check(ctx context.Context, ping func(ctx context.Context) error) *dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.HealthTimeout)
+	defer cancel()
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		result <- ping(ctx)
+	}()
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	status := &dependencyStatus{
+		Status:    statusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = statusFail
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+func (s *Server) CreateInstance(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.InstanceRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	instance, err := s.tenants.Create(req.ID, req.Name, req.CORSOrigins)
+	if err != nil {
+		switch err {
+		case tenant.ErrInvalidID:
+			pkg.WriteError(w, r, http.StatusBadRequest, err)
+		case tenant.ErrExists:
+			pkg.WriteError(w, r, http.StatusConflict, err)
+		default:
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, instance)
+}
+
+func (s *Server) ListInstances(w http.ResponseWriter, r *http.Request) {
+	instances, err := s.tenants.List()
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	// Secret is shown only once, on creation
+	for _, instance := range instances {
+		instance.Secret = ""
+	}
+	render.JSON(w, r, instances)
+}
+
+func (s *Server) GetInstance(w http.ResponseWriter, r *http.Request) {
+	t, err := s.tenants.Get(chi.URLParam(r, "instance_id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			pkg.WriteError(w, r, http.StatusNotFound, err)
+			return
+		}
+		pkg.WriteError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	instance := *t.Instance
+	instance.Secret = ""
+	render.JSON(w, r, instance)
+}
+
+func (s *Server) DeleteInstance(w http.ResponseWriter, r *http.Request) {
+	if err := s.tenants.Delete(chi.URLParam(r, "instance_id")); err != nil {
+		if err == mongo.ErrNoDocuments {
+			pkg.WriteError(w, r, http.StatusNotFound, err)
+			return
+		}
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/markdown"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+// renderMarkdown stores sanitized HTML and plain text next to source of markdown parts, unsafe markdown is rejected with 422
+func renderMarkdown(w http.ResponseWriter, r *http.Request, msg *models.Message) bool {
+	for i := range msg.Parts {
+		part := &msg.Parts[i]
+		if !markdown.Is(part.Type) {
+			continue
+		}
+		var err error
+		if part.HTML, part.Text, err = markdown.Render(part.Content); err != nil {
+			pkg.WriteError(w, r, http.StatusUnprocessableEntity, fmt.Errorf("part %d: %w", i, err))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/logger"
+	"github.com/neonxp/chatcloud/pkg/mentions"
+	"github.com/neonxp/chatcloud/pkg/models"
+	"github.com/neonxp/chatcloud/pkg/notify"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+// ListMentions pages through messages mentioning user, newest first
+func (s *Server) ListMentions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	initialID, _ := strconv.ParseInt(q.Get("initial_id"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	messages, err := mw.TenantFromRequest(r).Messages.Mentioning(mw.UserFromRequest(r).ID, initialID, limit)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, messages)
+}
+
+// ListReadStates returns read cursor with unread message and mention counts for every joined room
+func (s *Server) ListReadStates(w http.ResponseWriter, r *http.Request) {
+	t := mw.TenantFromRequest(r)
+	user := mw.UserFromRequest(r)
+	rooms, err := t.Rooms.JoinedRooms(user.ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	cursors, err := t.Cursors.ByUser(user.ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	byRoom := make(map[string]*models.Cursor, len(cursors))
+	for _, c := range cursors {
+		byRoom[c.RoomID] = c
+	}
+	states := make([]*models.RS, 0, len(rooms))
+	positions := make(map[primitive.ObjectID]int64, len(rooms))
+	for _, room := range rooms {
+		rs := &models.RS{
+			Cursor: models.Cursor{CursorType: models.CursorTypeRead, RoomID: room.ID.Hex(), UserID: user.ID},
+			RoomID: room.ID.Hex(),
+		}
+		if c, ok := byRoom[rs.RoomID]; ok {
+			rs.Cursor = *c
+		}
+		positions[room.ID] = rs.Cursor.Position
+		states = append(states, rs)
+	}
+	counts, err := t.Messages.CountUnreadByRooms(positions, user.ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	for i, room := range rooms {
+		states[i].UnreadCount = counts[room.ID].Unread
+		states[i].UnreadMentionCount = counts[room.ID].Mentions
+	}
+	render.JSON(w, r, states)
+}
+
+// resolveMentions returns room members mentioned by parts and broadcast keyword used, sender is never mentioned
+func (s *Server) resolveMentions(t *tenant.Tenant, room *models.Room, senderID string, parts []models.MessagePart) ([]string, string, error) {
+	ids, broadcast := mentions.Parse(parts)
+	switch broadcast {
+	case mentions.Room:
+		ids = room.MemberUserIDs
+	case mentions.Here:
+		online, err := s.hub.Online(t.Instance.ID, room.MemberUserIDs)
+		if err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, online...)
+	}
+	var resolved []string
+	seen := map[string]bool{senderID: true}
+	for _, id := range ids {
+		if seen[id] || !mw.IsMember(room.MemberUserIDs, id) {
+			continue
+		}
+		seen[id] = true
+		resolved = append(resolved, id)
+	}
+	return resolved, broadcast, nil
+}
+
+// notifyMentioned sends mention event to every mentioned user and queues high priority push notifications
+func (s *Server) notifyMentioned(r *http.Request, t *tenant.Tenant, room *models.Room, msg *models.Message) {
+	if len(msg.Mentions) == 0 {
+		return
+	}
+	title := room.PushNotificationTitleOverride
+	if title == "" {
+		title = room.Name
+	}
+	body := mentions.PlainText(msg.Parts)
+	notifications := make([]*notify.Notification, 0, len(msg.Mentions))
+	for _, userID := range msg.Mentions {
+		s.publish(r, hub.UserChannel(t.Instance.ID, userID), hub.EventMention, msg)
+		notifications = append(notifications, &notify.Notification{
+			Instance:  t.Instance.ID,
+			UserID:    userID,
+			RoomID:    room.ID.Hex(),
+			MessageID: msg.ID,
+			SenderID:  msg.UserID,
+			Reason:    notify.ReasonMention,
+			Priority:  notify.PriorityHigh,
+			Title:     title,
+			Body:      body,
+			CreatedAt: time.Now(),
+		})
+	}
+	if err := s.notify.Enqueue(notifications); err != nil {
+		logger.FromRequest(r).WithError(err).Warn("can't queue mention notifications")
+	}
+}
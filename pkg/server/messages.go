@@ -0,0 +1,278 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/metrics"
+	"github.com/neonxp/chatcloud/pkg/models"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+)
+
+// threadReplyEvent is sent to room subscribers when thread gets new reply
+type threadReplyEvent struct {
+	ParentID    int64              `json:"parent_id"`
+	ReplyCount  int64              `json:"reply_count"`
+	LastReplyAt primitive.DateTime `json:"last_reply_at"`
+	Message     *models.Message    `json:"message"`
+}
+
+// ListMessages pages through room history, older messages newest first by default
+func (s *Server) ListMessages(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	initialID, _ := strconv.ParseInt(q.Get("initial_id"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	messages, err := mw.TenantFromRequest(r).Messages.History(mw.RoomFromRequest(r).ID, initialID, q.Get("direction") == "newer", limit)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, messages)
+}
+
+// SendMessage posts message on behalf of token user, message with parent_id is a reply in thread of parent
+func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.SendMessageRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	userID, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	if !checkSanctions(w, r, t, room.ID, userID) {
+		return
+	}
+	var parent *models.Message
+	if req.ParentID != 0 {
+		var err error
+		parent, err = t.Messages.FindInRoom(room.ID, req.ParentID)
+		if err == mongo.ErrNoDocuments {
+			pkg.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("parent message %d not found", req.ParentID))
+			return
+		}
+		if err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if parent.ParentID != 0 {
+			pkg.WriteError(w, r, http.StatusBadRequest, errors.New("replies can't have own threads"))
+			return
+		}
+		if parent.Deleted() || parent.RedactedAt != 0 {
+			pkg.WriteError(w, r, http.StatusConflict, errors.New("parent message is deleted"))
+			return
+		}
+	}
+	id, err := t.Messages.NextID()
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	msg := &models.Message{
+		ID:        id,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Parts:     req.Parts,
+		RoomID:    room.ID,
+		UserID:    userID,
+		ParentID:  req.ParentID,
+	}
+	// Markdown is rendered after filters, so parts masked or replaced by moderation hook are rendered and checked too
+	if !s.filterMessage(w, r, t, room, msg, false) || !renderMarkdown(w, r, msg) {
+		return
+	}
+	if msg.Mentions, msg.MentionBroadcast, err = s.resolveMentions(t, room, userID, msg.Parts); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if err := t.Messages.Insert(msg); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	metrics.MessagesSent.Inc()
+	if err := t.Rooms.TouchLastMessage(room.ID, now); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	channel := hub.RoomChannel(t.Instance.ID, room.ID.Hex())
+	if parent == nil {
+		s.publish(r, channel, hub.EventNewMessage, msg)
+	} else {
+		if err := t.Messages.AddReply(parent.ID, now); err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if parent, err = t.Messages.FindByID(parent.ID); err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.publish(r, channel, hub.EventThreadReply, &threadReplyEvent{
+			ParentID:    parent.ID,
+			ReplyCount:  parent.ReplyCount,
+			LastReplyAt: parent.LastReplyAt,
+			Message:     msg,
+		})
+	}
+	s.notifyMentioned(r, t, room, msg)
+	s.unfurlLinks(r, t, msg)
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, map[string]int64{"message_id": id})
+}
+
+// GetMessage returns message, messages hidden by reports are shown to moderators only
+func (s *Server) GetMessage(w http.ResponseWriter, r *http.Request) {
+	msg := mw.MessageFromRequest(r)
+	if msg.HiddenAt != 0 {
+		ok, err := canModerate(r)
+		if err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if !ok {
+			pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("message %d not found", msg.ID))
+			return
+		}
+	}
+	render.JSON(w, r, msg)
+}
+
+// EditMessage replaces parts of message on behalf of its author. Edited text goes through filters again
+// and only users mentioned for the first time are notified
+func (s *Server) EditMessage(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.EditMessageRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	userID, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	msg := *mw.MessageFromRequest(r)
+	if msg.UserID != userID {
+		pkg.WriteError(w, r, http.StatusForbidden, errors.New("only author can edit message"))
+		return
+	}
+	if msg.Deleted() || msg.RedactedAt != 0 {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("message is deleted"))
+		return
+	}
+	if !checkSanctions(w, r, t, room.ID, userID) {
+		return
+	}
+	mentioned := map[string]bool{}
+	for _, id := range msg.Mentions {
+		mentioned[id] = true
+	}
+	msg.Parts = keepPreviews(msg.Parts, req.Parts)
+	if !s.filterMessage(w, r, t, room, &msg, true) || !renderMarkdown(w, r, &msg) {
+		return
+	}
+	var err error
+	if msg.Mentions, msg.MentionBroadcast, err = s.resolveMentions(t, room, userID, msg.Parts); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	if err := t.Messages.Edit(msg.ID, msg.Parts, msg.Mentions, msg.MentionBroadcast, msg.Flags, now); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	edited, err := t.Messages.FindByID(msg.ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	s.publish(r, hub.RoomChannel(t.Instance.ID, room.ID.Hex()), hub.EventMessageEdited, edited)
+	notified := *edited
+	notified.Mentions = nil
+	for _, id := range edited.Mentions {
+		if !mentioned[id] {
+			notified.Mentions = append(notified.Mentions, id)
+		}
+	}
+	s.notifyMentioned(r, t, room, &notified)
+	s.unfurlLinks(r, t, edited)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteMessage drops content of message on behalf of its author or moderator, it stays in history as deleted
+func (s *Server) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	msg := mw.MessageFromRequest(r)
+	if mw.ClaimsFromRequest(r).Subject != msg.UserID {
+		ok, err := canModerate(r)
+		if err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if !ok {
+			pkg.WriteError(w, r, http.StatusForbidden, errors.New("only author or moderator can delete message"))
+			return
+		}
+	}
+	deleted, err := t.Messages.Delete(msg.ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	s.releasePins(r, room.ID, msg.ID)
+	if deleted {
+		s.publishMessage(r, hub.RoomChannel(t.Instance.ID, room.ID.Hex()), hub.EventMessageDeleted, msg.ID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tokenUser returns user the request acts on behalf of, server tokens must carry user too
+func tokenUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	claims := mw.ClaimsFromRequest(r)
+	if claims == nil {
+		pkg.WriteError(w, r, http.StatusUnauthorized, errors.New("access token is required"))
+		return "", false
+	}
+	userID := claims.Subject
+	if userID == "" {
+		pkg.WriteError(w, r, http.StatusBadRequest, errors.New("token has no user to act on behalf of"))
+		return "", false
+	}
+	return userID, true
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/logger"
+	"github.com/neonxp/chatcloud/pkg/token"
+)
+
+const claimsCtxKey = "claims"
+
+// Auth verifies bearer token against instance secret and stores its claims in context. Requests without token pass through
+func Auth() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, "Bearer ") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims, err := token.Parse(TenantFromRequest(r).Instance, strings.TrimPrefix(header, "Bearer "))
+			if err != nil {
+				pkg.WriteError(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			fields := logrus.Fields{"su": claims.SU}
+			if claims.Subject != "" {
+				fields["user_id"] = claims.Subject
+			}
+			logger.AddFields(r.Context(), fields)
+			r = r.WithContext(context.WithValue(
+				r.Context(),
+				claimsCtxKey,
+				claims,
+			))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClaimsFromRequest returns claims of verified token or nil for anonymous request
+func ClaimsFromRequest(r *http.Request) *token.Claims {
+	claims, _ := r.Context().Value(claimsCtxKey).(*token.Claims)
+	return claims
+}
@@ -0,0 +1,62 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// legacyRoots are first segments of paths served under /api before instances were introduced
+var legacyRoots = map[string]bool{
+	"batch_users":  true,
+	"users_by_ids": true,
+	"users":        true,
+	"rooms":        true,
+	"roles":        true,
+	"cursors":      true,
+	"token":        true,
+}
+
+// LegacyPaths rewrites /api/... paths of deployments older than instances to /api/v1/{instanceID}/...,
+// it must run before routing and StripSlashes
+func LegacyPaths(instanceID string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if path, ok := legacyPath(r.URL.Path, instanceID); ok {
+				r.URL.Path = path
+				if r.URL.RawPath != "" {
+					r.URL.RawPath, _ = legacyPath(r.URL.RawPath, instanceID)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func legacyPath(path string, instanceID string) (string, bool) {
+	rest := strings.TrimPrefix(path, "/api/")
+	if rest == path || !legacyRoots[strings.SplitN(rest, "/", 2)[0]] {
+		return path, false
+	}
+	return "/api/v1/" + instanceID + "/" + rest, true
+}
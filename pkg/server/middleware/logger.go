@@ -0,0 +1,71 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/sirupsen/logrus"
+
+	"github.com/neonxp/chatcloud/pkg/logger"
+)
+
+// Logger puts request scoped logger into context and logs every completed request, panics are logged and passed on to recoverer
+func Logger(l *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			entry := l.WithFields(logrus.Fields{
+				"request_id": middleware.GetReqID(r.Context()),
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"remote":     r.RemoteAddr,
+			})
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			r = r.WithContext(logger.WithLogger(r.Context(), entry))
+			defer func() {
+				rvr := recover()
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				if rvr != nil {
+					status = http.StatusInternalServerError
+				}
+				log := logger.FromRequest(r).WithFields(logrus.Fields{
+					"status":   status,
+					"bytes":    ww.BytesWritten(),
+					"duration": time.Since(start).String(),
+				})
+				if rvr == nil {
+					log.Info("request completed")
+					return
+				}
+				log.WithField("panic", rvr).Error("request panicked")
+				panic(rvr)
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+const messageUrlParam = "message_id"
+const messageCtxKey = "message"
+
+// Message loads message of room, must be used after Room
+func Message() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mid := chi.URLParam(r, messageUrlParam)
+			if mid != "" {
+				id, err := strconv.ParseInt(mid, 10, 64)
+				if err != nil {
+					pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("message %s not found", mid))
+					return
+				}
+				msg, err := TenantFromRequest(r).Messages.FindInRoom(RoomFromRequest(r).ID, id)
+				if err != nil {
+					if err == mongo.ErrNoDocuments {
+						pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("message %s not found", mid))
+						return
+					}
+					pkg.WriteError(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				r = r.WithContext(context.WithValue(
+					r.Context(),
+					messageCtxKey,
+					msg,
+				))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func MessageFromRequest(r *http.Request) *models.Message {
+	return r.Context().Value(messageCtxKey).(*models.Message)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/neonxp/chatcloud/pkg"
+)
+
+// Permission allows server tokens and users whose role grants permission in room, must be used after Room
+func Permission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := ClaimsFromRequest(r)
+			if claims == nil {
+				pkg.WriteError(w, r, http.StatusUnauthorized, errors.New("access token is required"))
+				return
+			}
+			if !claims.SU {
+				ok, err := TenantFromRequest(r).Roles.Can(claims.Subject, RoomFromRequest(r).ID.Hex(), permission)
+				if err != nil {
+					pkg.WriteError(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				if !ok {
+					pkg.WriteError(w, r, http.StatusForbidden, fmt.Errorf("%s permission is required", permission))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
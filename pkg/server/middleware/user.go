@@ -30,25 +30,25 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/neonxp/chatcloud/pkg"
-	"github.com/neonxp/chatcloud/pkg/manager"
 	"github.com/neonxp/chatcloud/pkg/models"
 )
 
 const userUrlParam = "user_id"
 const userCtxKey = "user"
 
-func User(m *manager.User) func(http.Handler) http.Handler {
+func User() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			uid := chi.URLParam(r, userUrlParam)
 			if uid != "" {
-				u, err := m.FindByID(uid)
+				u, err := TenantFromRequest(r).Users.FindByID(uid)
 				if err != nil {
 					if err == mongo.ErrNoDocuments {
-						pkg.WriteError(w, http.StatusNotFound, fmt.Errorf("user %s not found", uid))
+						pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("user %s not found", uid))
 						return
 					}
-					pkg.WriteError(w, http.StatusInternalServerError, err)
+					pkg.WriteError(w, r, http.StatusInternalServerError, err)
+					return
 				}
 				r = r.WithContext(context.WithValue(
 					r.Context(),
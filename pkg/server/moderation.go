@@ -0,0 +1,266 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/models"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+func (s *Server) ListBans(w http.ResponseWriter, r *http.Request) {
+	s.listSanctions(w, r, models.SanctionBan)
+}
+
+func (s *Server) ListMutes(w http.ResponseWriter, r *http.Request) {
+	s.listSanctions(w, r, models.SanctionMute)
+}
+
+// BanUser removes user from room and keeps them from joining and posting until ban is lifted or expires
+func (s *Server) BanUser(w http.ResponseWriter, r *http.Request) {
+	s.sanction(w, r, models.SanctionBan)
+}
+
+func (s *Server) UnbanUser(w http.ResponseWriter, r *http.Request) {
+	s.lift(w, r, models.SanctionBan)
+}
+
+// MuteUser keeps member from posting while they still can read the room
+func (s *Server) MuteUser(w http.ResponseWriter, r *http.Request) {
+	s.sanction(w, r, models.SanctionMute)
+}
+
+func (s *Server) UnmuteUser(w http.ResponseWriter, r *http.Request) {
+	s.lift(w, r, models.SanctionMute)
+}
+
+// KickUser removes member from room, unlike ban it doesn't stop them from joining again
+func (s *Server) KickUser(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.ModerationRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	user := mw.UserFromRequest(r)
+	if !moderatable(w, r, user.ID) {
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	if !mw.IsMember(room.MemberUserIDs, user.ID) {
+		pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("user %s is not a member of room", user.ID))
+		return
+	}
+	if err := t.Rooms.RemoveMembers(room.ID, []string{user.ID}); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if s.recordModeration(w, r, t, &models.ModerationEntry{
+		RoomID:  room.ID,
+		Action:  models.ModerationKick,
+		UserID:  user.ID,
+		ActorID: mw.ClaimsFromRequest(r).Subject,
+		Reason:  req.Reason,
+	}, true) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) ListModerationLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var before primitive.ObjectID
+	if v := q.Get("before"); v != "" {
+		var err error
+		if before, err = primitive.ObjectIDFromHex(v); err != nil {
+			pkg.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid before: %w", err))
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	entries, err := mw.TenantFromRequest(r).Moderation.Log(mw.RoomFromRequest(r).ID, before, limit)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+func (s *Server) listSanctions(w http.ResponseWriter, r *http.Request, kind string) {
+	sanctions, err := mw.TenantFromRequest(r).Moderation.Sanctions(mw.RoomFromRequest(r).ID, kind)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, sanctions)
+}
+
+func (s *Server) sanction(w http.ResponseWriter, r *http.Request, kind string) {
+	req := new(rest.ModerationRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	user := mw.UserFromRequest(r)
+	if !moderatable(w, r, user.ID) {
+		return
+	}
+	room := mw.RoomFromRequest(r)
+	now := time.Now()
+	sanction := &models.Sanction{
+		RoomID:    room.ID,
+		UserID:    user.ID,
+		Kind:      kind,
+		Reason:    req.Reason,
+		CreatedBy: mw.ClaimsFromRequest(r).Subject,
+		CreatedAt: primitive.NewDateTimeFromTime(now),
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			pkg.WriteError(w, r, http.StatusBadRequest, errors.New("expires_at must be in future"))
+			return
+		}
+		sanction.ExpiresAt = primitive.NewDateTimeFromTime(*req.ExpiresAt)
+	}
+	if s.impose(w, r, mw.TenantFromRequest(r), sanction) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// impose stores sanction, drops banned user from room and records it in moderation log
+func (s *Server) impose(w http.ResponseWriter, r *http.Request, t *tenant.Tenant, sanction *models.Sanction) bool {
+	if err := t.Moderation.Impose(sanction); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	room := mw.RoomFromRequest(r)
+	removed := false
+	if sanction.Kind == models.SanctionBan && mw.IsMember(room.MemberUserIDs, sanction.UserID) {
+		if err := t.Rooms.RemoveMembers(room.ID, []string{sanction.UserID}); err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return false
+		}
+		removed = true
+	}
+	action := models.ModerationMute
+	if sanction.Kind == models.SanctionBan {
+		action = models.ModerationBan
+	}
+	return s.recordModeration(w, r, t, &models.ModerationEntry{
+		RoomID:    room.ID,
+		Action:    action,
+		UserID:    sanction.UserID,
+		ActorID:   sanction.CreatedBy,
+		Reason:    sanction.Reason,
+		ExpiresAt: sanction.ExpiresAt,
+	}, removed)
+}
+
+func (s *Server) lift(w http.ResponseWriter, r *http.Request, kind string) {
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	userID := chi.URLParam(r, "user_id")
+	lifted, err := t.Moderation.Lift(room.ID, userID, kind)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if !lifted {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	action := models.ModerationUnmute
+	if kind == models.SanctionBan {
+		action = models.ModerationUnban
+	}
+	if s.recordModeration(w, r, t, &models.ModerationEntry{
+		RoomID:  room.ID,
+		Action:  action,
+		UserID:  userID,
+		ActorID: mw.ClaimsFromRequest(r).Subject,
+	}, false) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// recordModeration logs action and tells room and affected user about it, removed also tells user they left room
+// and closes their room streams
+func (s *Server) recordModeration(w http.ResponseWriter, r *http.Request, t *tenant.Tenant, e *models.ModerationEntry, removed bool) bool {
+	if err := t.Moderation.Record(e); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	userChannel := hub.UserChannel(t.Instance.ID, e.UserID)
+	s.publish(r, hub.RoomChannel(t.Instance.ID, e.RoomID.Hex()), hub.EventModeration, e)
+	s.publish(r, userChannel, hub.EventModeration, e)
+	if removed {
+		s.publish(r, userChannel, hub.EventRemovedFromRoom, mw.RoomFromRequest(r))
+		s.publish(r, hub.RoomChannel(t.Instance.ID, e.RoomID.Hex()), hub.EventMembersRemoved, &models.Membership{
+			RoomID:  e.RoomID,
+			UserIds: []string{e.UserID},
+		})
+	}
+	return true
+}
+
+// moderatable rejects moderation of direct message rooms and of moderator themselves
+func moderatable(w http.ResponseWriter, r *http.Request, userID string) bool {
+	if mw.RoomFromRequest(r).DM {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("direct message rooms can't be moderated"))
+		return false
+	}
+	if mw.ClaimsFromRequest(r).Subject == userID {
+		pkg.WriteError(w, r, http.StatusBadRequest, errors.New("moderators can't moderate themselves"))
+		return false
+	}
+	return true
+}
+
+// checkSanctions writes error and returns false when user is banned or muted in room
+func checkSanctions(w http.ResponseWriter, r *http.Request, t *tenant.Tenant, roomID primitive.ObjectID, userID string) bool {
+	sanctions, err := t.Moderation.Active(roomID, userID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	if len(sanctions) == 0 {
+		return true
+	}
+	if sanctions[0].Kind == models.SanctionBan {
+		pkg.WriteError(w, r, http.StatusForbidden, errors.New("user is banned from room"))
+	} else {
+		pkg.WriteError(w, r, http.StatusForbidden, errors.New("user is muted in room"))
+	}
+	return false
+}
@@ -0,0 +1,875 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	oa "github.com/neonxp/chatcloud/pkg/openapi"
+)
+
+const (
+	v1Prefix    = "/api/v1/{instance_id}"
+	adminPrefix = "/api/admin/instances"
+
+	securityBearer = "bearer"
+	securityBasic  = "basic"
+	securityAdmin  = "admin"
+)
+
+var (
+	str        = &oa.Schema{Type: oa.TypeString}
+	boolean    = &oa.Schema{Type: oa.TypeBoolean}
+	dateTime   = &oa.Schema{Type: oa.TypeString, Format: oa.FormatDateTime}
+	uri        = &oa.Schema{Type: oa.TypeString, Format: oa.FormatURI}
+	object     = &oa.Schema{Type: oa.TypeObject, Description: "Arbitrary JSON object"}
+	limit      = &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(1), Maximum: oa.Float(100)}
+	stringList = &oa.Schema{Type: oa.TypeArray, Items: &oa.Schema{Type: oa.TypeString}}
+	userIDs    = &oa.Schema{Type: oa.TypeArray, MinItems: oa.Int(1), Items: nonEmpty(160)}
+	anyValue   = &oa.Schema{Description: "Any JSON value"}
+)
+
+var (
+	userSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":          str,
+			"name":        str,
+			"avatar_url":  str,
+			"custom_data": anyValue,
+			"created_at":  dateTime,
+			"updated_at":  dateTime,
+			"legal_hold":  boolean,
+		},
+	}
+	createUserSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":          nonEmpty(160),
+			"name":        nonEmpty(256),
+			"avatar_url":  uri,
+			"custom_data": object,
+		},
+		Required: []string{"id", "name"},
+	}
+	updateUserSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"name":        nonEmpty(256),
+			"avatar_url":  uri,
+			"custom_data": object,
+		},
+	}
+	roomSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":                               str,
+			"name":                             str,
+			"private":                          boolean,
+			"push_notification_title_override": str,
+			"created_by_id":                    str,
+			"custom_data":                      anyValue,
+			"member_user_ids":                  stringList,
+			"last_message_at":                  dateTime,
+			"created_at":                       dateTime,
+			"updated_at":                       dateTime,
+			"retention":                        retentionSchema,
+			"legal_hold":                       boolean,
+			"dm":                               boolean,
+			"archived_at":                      dateTime,
+			"archived_by":                      str,
+		},
+	}
+	createRoomSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"name":                             nonEmpty(60),
+			"private":                          boolean,
+			"push_notification_title_override": &oa.Schema{Type: oa.TypeString, MaxLength: oa.Int(60)},
+			"custom_data":                      object,
+			"user_ids":                         &oa.Schema{Type: oa.TypeArray, Items: nonEmpty(160)},
+		},
+		Required: []string{"name"},
+	}
+	updateRoomSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"name":                             nonEmpty(60),
+			"private":                          boolean,
+			"push_notification_title_override": &oa.Schema{Type: oa.TypeString, MaxLength: oa.Int(60), Nullable: true},
+			"custom_data":                      object,
+		},
+	}
+	membersSchema = &oa.Schema{
+		Type:       oa.TypeObject,
+		Properties: map[string]*oa.Schema{"user_ids": userIDs},
+		Required:   []string{"user_ids"},
+	}
+	roomRefSchema = &oa.Schema{
+		Type:       oa.TypeObject,
+		Properties: map[string]*oa.Schema{"room_id": nonEmpty(64)},
+		Required:   []string{"room_id"},
+	}
+	messagePartSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"type":    nonEmpty(255),
+			"content": str,
+			"url":     uri,
+			"attachment": &oa.Schema{
+				Type:       oa.TypeObject,
+				Properties: map[string]*oa.Schema{"id": str},
+			},
+			"html": &oa.Schema{Type: oa.TypeString, Description: "Sanitized HTML of text/markdown part filled in by server, ignored in requests"},
+			"text": &oa.Schema{Type: oa.TypeString, Description: "Plain text of text/markdown part filled in by server, ignored in requests"},
+			"preview": &oa.Schema{
+				Type:        oa.TypeObject,
+				Description: "Link preview filled in by server after message is sent, ignored in requests",
+				Properties: map[string]*oa.Schema{
+					"url":         str,
+					"title":       str,
+					"description": str,
+					"image":       str,
+					"site_name":   str,
+					"type":        str,
+				},
+			},
+		},
+		Required: []string{"type"},
+	}
+	reactionSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"reaction": str,
+			"count":    &oa.Schema{Type: oa.TypeInteger},
+			"user_ids": stringList,
+		},
+	}
+	messageSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":                &oa.Schema{Type: oa.TypeInteger},
+			"user_id":           str,
+			"room_id":           str,
+			"parts":             &oa.Schema{Type: oa.TypeArray, Items: messagePartSchema},
+			"created_at":        dateTime,
+			"updated_at":        dateTime,
+			"deleted_at":        dateTime,
+			"redacted_at":       dateTime,
+			"reactions":         arrayOf(reactionSchema),
+			"parent_id":         &oa.Schema{Type: oa.TypeInteger},
+			"reply_count":       &oa.Schema{Type: oa.TypeInteger},
+			"last_reply_at":     dateTime,
+			"pinned_at":         dateTime,
+			"pinned_by":         str,
+			"mentions":          stringList,
+			"mention_broadcast": &oa.Schema{Type: oa.TypeString, Enum: []string{"room", "here"}},
+			"flags":             &oa.Schema{Type: oa.TypeArray, Items: str, Description: "Filter rules that held message for review"},
+			"hidden_at":         dateTime,
+		},
+	}
+	sendMessageSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"parts":     &oa.Schema{Type: oa.TypeArray, MinItems: oa.Int(1), Items: messagePartSchema},
+			"parent_id": &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(1), Description: "Message to reply to in its thread"},
+		},
+		Required: []string{"parts"},
+	}
+	editMessageSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"parts": &oa.Schema{Type: oa.TypeArray, MinItems: oa.Int(1), Items: messagePartSchema},
+		},
+		Required: []string{"parts"},
+	}
+	roleSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"name":        nonEmpty(64),
+			"scope":       &oa.Schema{Type: oa.TypeString, Enum: []string{"global", "room"}},
+			"permissions": stringList,
+		},
+		Required: []string{"name", "scope"},
+	}
+	permissionsSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"add_permissions":    stringList,
+			"remove_permissions": stringList,
+		},
+	}
+	assignRoleSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"name":    nonEmpty(64),
+			"room_id": str,
+		},
+		Required: []string{"name"},
+	}
+	userRoleSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"user_id":   str,
+			"role_name": str,
+			"scope":     str,
+			"room_id":   str,
+		},
+	}
+	cursorSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"cursor_type": &oa.Schema{Type: oa.TypeInteger},
+			"position":    &oa.Schema{Type: oa.TypeInteger},
+			"room_id":     str,
+			"user_id":     str,
+			"updated_at":  dateTime,
+		},
+	}
+	setCursorSchema = &oa.Schema{
+		Type:       oa.TypeObject,
+		Properties: map[string]*oa.Schema{"position": &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(0)}},
+		Required:   []string{"position"},
+	}
+	tokenRequestSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"grant_type": &oa.Schema{Type: oa.TypeString, Enum: []string{"client_credentials"}},
+			"user_id":    str,
+			"su":         boolean,
+		},
+		Required: []string{"grant_type"},
+	}
+	tokenResponseSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"access_token": str,
+			"token_type":   str,
+			"expires_in":   &oa.Schema{Type: oa.TypeInteger},
+		},
+	}
+	instanceSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":           str,
+			"name":         str,
+			"key_id":       str,
+			"secret":       str,
+			"cors_origins": stringList,
+			"created_at":   dateTime,
+			"retention":    retentionSchema,
+			"filters":      arrayOf(filterRuleSchema),
+		},
+	}
+	createInstanceSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":           &oa.Schema{Type: oa.TypeString, Pattern: `^[a-z0-9-]{1,32}$`},
+			"name":         nonEmpty(256),
+			"cors_origins": &oa.Schema{Type: oa.TypeArray, Items: uri},
+		},
+		Required: []string{"name"},
+	}
+	retentionSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"days":   &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(0), Maximum: oa.Float(36500), Description: "Messages older than this are purged, 0 keeps them forever"},
+			"action": &oa.Schema{Type: oa.TypeString, Enum: []string{"delete", "redact"}},
+		},
+		Required: []string{"days", "action"},
+	}
+	retentionAuditSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":           str,
+			"room_id":      str,
+			"action":       str,
+			"days":         &oa.Schema{Type: oa.TypeInteger},
+			"cutoff":       dateTime,
+			"message_ids":  arrayOf(&oa.Schema{Type: oa.TypeInteger}),
+			"count":        &oa.Schema{Type: oa.TypeInteger},
+			"status":       &oa.Schema{Type: oa.TypeString, Enum: []string{"pending", "done"}},
+			"created_at":   dateTime,
+			"completed_at": dateTime,
+		},
+	}
+	filterRuleSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"name":    nonEmpty(64),
+			"words":   &oa.Schema{Type: oa.TypeArray, MaxItems: oa.Int(1000), Items: nonEmpty(64), Description: "Whole words matched ignoring case"},
+			"pattern": &oa.Schema{Type: oa.TypeString, MaxLength: oa.Int(1000), Description: "Regular expression in RE2 syntax, exclusive with words"},
+			"action":  &oa.Schema{Type: oa.TypeString, Enum: []string{"reject", "mask", "flag"}},
+		},
+		Required: []string{"name", "action"},
+	}
+	filtersSchema = &oa.Schema{
+		Type:       oa.TypeObject,
+		Properties: map[string]*oa.Schema{"rules": &oa.Schema{Type: oa.TypeArray, MaxItems: oa.Int(100), Items: filterRuleSchema}},
+		Required:   []string{"rules"},
+	}
+	moderationSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"reason":     &oa.Schema{Type: oa.TypeString, MaxLength: oa.Int(500)},
+			"expires_at": dateTime,
+		},
+	}
+	kickSchema = &oa.Schema{
+		Type:       oa.TypeObject,
+		Properties: map[string]*oa.Schema{"reason": &oa.Schema{Type: oa.TypeString, MaxLength: oa.Int(500)}},
+	}
+	sanctionSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"room_id":    str,
+			"user_id":    str,
+			"kind":       &oa.Schema{Type: oa.TypeString, Enum: []string{"ban", "mute"}},
+			"reason":     str,
+			"expires_at": dateTime,
+			"created_by": str,
+			"created_at": dateTime,
+		},
+	}
+	moderationEntrySchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":         str,
+			"room_id":    str,
+			"action":     &oa.Schema{Type: oa.TypeString, Enum: []string{"ban", "unban", "mute", "unmute", "kick"}},
+			"user_id":    str,
+			"actor_id":   &oa.Schema{Type: oa.TypeString, Description: "Empty when sanction expired"},
+			"reason":     str,
+			"expires_at": dateTime,
+			"created_at": dateTime,
+		},
+	}
+	reportRequestSchema = &oa.Schema{
+		Type:       oa.TypeObject,
+		Properties: map[string]*oa.Schema{"reason": &oa.Schema{Type: oa.TypeString, MinLength: oa.Int(1), MaxLength: oa.Int(500)}},
+		Required:   []string{"reason"},
+	}
+	reportSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":          str,
+			"room_id":     str,
+			"message_id":  &oa.Schema{Type: oa.TypeInteger},
+			"sender_id":   str,
+			"reporter_id": str,
+			"reason":      str,
+			"status":      &oa.Schema{Type: oa.TypeString, Enum: []string{"open", "resolved"}},
+			"resolution":  &oa.Schema{Type: oa.TypeString, Enum: []string{"dismiss", "delete_message", "ban_sender"}},
+			"resolved_by": str,
+			"resolved_at": dateTime,
+			"created_at":  dateTime,
+			"message":     messageSchema,
+		},
+	}
+	resolveReportSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"action": &oa.Schema{Type: oa.TypeString, Enum: []string{"dismiss", "delete_message", "ban_sender"}},
+			"reason": &oa.Schema{Type: oa.TypeString, MaxLength: oa.Int(500), Description: "Reason of ban, reason of report is used when empty"},
+		},
+		Required: []string{"action"},
+	}
+	healthSchema = &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"status":       str,
+			"dependencies": object,
+		},
+	}
+)
+
+func nonEmpty(max int) *oa.Schema {
+	return &oa.Schema{Type: oa.TypeString, MinLength: oa.Int(1), MaxLength: oa.Int(max)}
+}
+
+func arrayOf(s *oa.Schema) *oa.Schema {
+	return &oa.Schema{Type: oa.TypeArray, Items: s}
+}
+
+// v1 creates operation of instance API, callers authenticate with bearer token
+func v1(id string, tag string, summary string) *oa.Operation {
+	return oa.NewOperation(id, tag, summary).Secured(securityBearer)
+}
+
+func subscription(id string, tag string, summary string) *oa.Operation {
+	return v1(id, tag, summary).Returns("200", "Stream of events as JSON lines", &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"event_name": str,
+			"data":       anyValue,
+			"timestamp":  dateTime,
+		},
+	})
+}
+
+// newSpec describes every route registered in Init. Init refuses to start when a route is missing here
+func newSpec() *oa.Document {
+	instanceID := oa.PathParam("instance_id", str)
+	userID := oa.PathParam("user_id", nonEmpty(160))
+	roomID := oa.PathParam("room_id", str)
+	messageID := oa.PathParam("message_id", &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(1)})
+	fileName := oa.PathParam("file_name", nonEmpty(255))
+	roleName := oa.PathParam("role_name", nonEmpty(64))
+	scopeName := oa.PathParam("scope_name", &oa.Schema{Type: oa.TypeString, Enum: []string{"global", "room"}})
+
+	path := func(params ...*oa.Parameter) *oa.PathItem {
+		return &oa.PathItem{Parameters: params}
+	}
+	v1Path := func(params ...*oa.Parameter) *oa.PathItem {
+		return path(append([]*oa.Parameter{instanceID}, params...)...)
+	}
+	paths := map[string]*oa.PathItem{}
+	add := func(p string, item *oa.PathItem) *oa.PathItem {
+		paths[p] = item
+		return item
+	}
+
+	add("/", path()).Get = oa.NewOperation("index", "service", "Service root").
+		Returns("501", "Not implemented", nil)
+	add("/healthz", path()).Get = oa.NewOperation("healthz", "service", "Liveness probe").
+		Returns("200", "Process is alive", healthSchema)
+	add("/readyz", path()).Get = oa.NewOperation("readyz", "service", "Readiness probe checking dependencies").
+		Returns("200", "Ready to serve", healthSchema).
+		Returns("503", "Dependency is unavailable", healthSchema)
+	add("/api/openapi.json", path()).Get = oa.NewOperation("openapi", "service", "This document").
+		Returns("200", "OpenAPI document", object)
+
+	// Admin
+	instances := add(adminPrefix, path())
+	instances.Get = oa.NewOperation("listInstances", "admin", "List instances").Secured(securityAdmin).
+		Returns("200", "Instances without secrets", arrayOf(instanceSchema))
+	instances.Post = oa.NewOperation("createInstance", "admin", "Create instance").Secured(securityAdmin).
+		Body(createInstanceSchema).
+		Returns("201", "Created instance with its secret", instanceSchema)
+	instance := add(adminPrefix+"/{instance_id}", path(instanceID))
+	instance.Get = oa.NewOperation("getInstance", "admin", "Get instance").Secured(securityAdmin).
+		Returns("200", "Instance", instanceSchema)
+	instance.Delete = oa.NewOperation("deleteInstance", "admin", "Delete instance with all its data").Secured(securityAdmin).
+		Returns("204", "Deleted", nil)
+	instanceRetention := add(adminPrefix+"/{instance_id}/retention", path(instanceID))
+	instanceRetention.Put = oa.NewOperation("setInstanceRetention", "admin", "Set default retention policy of instance rooms").Secured(securityAdmin).
+		Body(retentionSchema).
+		Returns("200", "Instance", instanceSchema)
+	instanceRetention.Delete = oa.NewOperation("deleteInstanceRetention", "admin", "Keep instance messages forever").Secured(securityAdmin).
+		Returns("204", "Removed", nil)
+	instanceFilters := add(adminPrefix+"/{instance_id}/filters", path(instanceID))
+	instanceFilters.Put = oa.NewOperation("setInstanceFilters", "admin", "Replace message filter rules applied in every room of instance").Secured(securityAdmin).
+		Body(filtersSchema).
+		Returns("200", "Instance", instanceSchema)
+	instanceFilters.Delete = oa.NewOperation("deleteInstanceFilters", "admin", "Remove message filter rules of instance").Secured(securityAdmin).
+		Returns("204", "Removed", nil)
+
+	// Users
+	add(v1Prefix+"/batch_users", v1Path()).Post = v1("batchCreateUsers", "users", "Create several users").
+		Body(arrayOf(createUserSchema)).
+		Returns("201", "Created users", arrayOf(userSchema))
+	add(v1Prefix+"/users_by_ids", v1Path()).Get = v1("getUsersByIDs", "users", "Get users by ids").
+		Query("id", true, &oa.Schema{Type: oa.TypeArray, MinItems: oa.Int(1), Items: nonEmpty(160)}).
+		Returns("200", "Users", arrayOf(userSchema))
+	users := add(v1Prefix+"/users", v1Path())
+	users.Get = v1("listUsers", "users", "List users in creation order").
+		Query("from_ts", false, dateTime).
+		Query("limit", false, limit).
+		Returns("200", "Users", arrayOf(userSchema))
+	users.Post = v1("createUser", "users", "Create user").
+		Body(createUserSchema).
+		Returns("201", "Created user", userSchema)
+	users.Subscribe = subscription("subscribeUsers", "users", "Subscribe to presence of users")
+	user := add(v1Prefix+"/users/{user_id}", v1Path(userID))
+	user.Get = v1("getUser", "users", "Get user").
+		Returns("200", "User", userSchema)
+	user.Put = v1("updateUser", "users", "Update user. Requires token of the user or server token").
+		Body(updateUserSchema).
+		Returns("204", "Updated", nil)
+	user.Delete = v1("deleteUser", "users", "Delete user with memberships and roles, messages are kept. Requires server token").
+		Returns("204", "Deleted", nil).
+		Returns("409", "User is under legal hold", oa.ErrorSchema)
+	user.Subscribe = subscription("subscribeUser", "users", "Subscribe to events of user. Requires token of the user or server token")
+	add(v1Prefix+"/users/{user_id}/register", v1Path(userID)).Subscribe = subscription("subscribeUserRegister", "users", "Subscribe to user registration events")
+	add(v1Prefix+"/users/{user_id}/joined_rooms", v1Path(userID)).Get = v1("joinedRooms", "rooms", "Rooms user is member of. Requires token of the user or server token").
+		Returns("200", "Rooms", arrayOf(roomSchema))
+	add(v1Prefix+"/users/{user_id}/joinable_rooms", v1Path(userID)).Get = v1("joinableRooms", "rooms", "Public rooms user can join, direct message and archived rooms are left out. Requires token of the user or server token").
+		Returns("200", "Rooms", arrayOf(roomSchema))
+	add(v1Prefix+"/users/{user_id}/join", v1Path(userID)).Post = v1("joinRoom", "rooms", "Join public room, private ones require server token. Requires token of the user or server token").
+		Body(roomRefSchema).
+		Returns("200", "Joined room", roomSchema).
+		Returns("403", "Room is private or user is banned from it", oa.ErrorSchema).
+		Returns("404", "Room not found", oa.ErrorSchema).
+		Returns("409", "Room is archived", oa.ErrorSchema)
+	add(v1Prefix+"/users/{user_id}/leave", v1Path(userID)).Post = v1("leaveRoom", "rooms", "Leave room. Requires token of the user or server token").
+		Body(roomRefSchema).
+		Returns("204", "Left room", nil).
+		Returns("404", "Room not found", oa.ErrorSchema).
+		Returns("409", "Room is direct message or archived room", oa.ErrorSchema)
+	userRoles := add(v1Prefix+"/users/{user_id}/roles", v1Path(userID))
+	userRoles.Get = v1("userRoles", "roles", "Roles assigned to user, requires server token").
+		Returns("200", "Assignments", arrayOf(userRoleSchema))
+	userRoles.Put = v1("assignRole", "roles", "Assign role to user replacing previous role in the same scope, requires server token").
+		Body(assignRoleSchema).
+		Returns("204", "Assigned", nil).
+		Returns("404", "Role or room not found", oa.ErrorSchema)
+	userRoles.Delete = v1("removeRole", "roles", "Remove room role of user or global role when room_id is absent, requires server token").
+		Query("room_id", false, str).
+		Returns("204", "Removed", nil).
+		Returns("404", "User has no such role", oa.ErrorSchema)
+
+	// Rooms
+	rooms := add(v1Prefix+"/rooms", v1Path())
+	rooms.Get = v1("listRooms", "rooms", "List rooms in creation order, searching by name includes archived rooms").
+		Query("from_id", false, str).
+		Query("include_private", false, boolean).
+		Query("include_archived", false, boolean).
+		Query("name", false, &oa.Schema{Type: oa.TypeString, MaxLength: oa.Int(60)}).
+		Query("limit", false, limit).
+		Returns("200", "Rooms", arrayOf(roomSchema)).
+		Returns("403", "Private rooms require server token", oa.ErrorSchema)
+	rooms.Post = v1("createRoom", "rooms", "Create room on behalf of token user, who becomes its member").
+		Body(createRoomSchema).
+		Returns("201", "Created room", roomSchema).
+		Returns("404", "User not found", oa.ErrorSchema)
+	room := add(v1Prefix+"/rooms/{room_id}", v1Path(roomID))
+	room.Get = v1("getRoom", "rooms", "Get room, private rooms are shown to members and server tokens only").
+		Returns("200", "Room", roomSchema).
+		Returns("403", "Token user is not a member of private room", oa.ErrorSchema)
+	room.Put = v1("updateRoom", "rooms", "Update room, requires server token").
+		Body(updateRoomSchema).
+		Returns("204", "Updated", nil).
+		Returns("409", "Room is direct message room", oa.ErrorSchema)
+	room.Delete = v1("deleteRoom", "rooms", "Delete room with its messages, cursors, exports and moderation data, requires server token").
+		Returns("204", "Deleted", nil).
+		Returns("409", "Room or some of its messages are under legal hold", oa.ErrorSchema)
+	room.Subscribe = subscription("subscribeRoom", "rooms", "Subscribe to events of room").
+		Returns("403", "Token user is not a member of room", oa.ErrorSchema)
+	archive := add(v1Prefix+"/rooms/{room_id}/archive", v1Path(roomID))
+	archive.Put = v1("archiveRoom", "rooms", "Archive room making it read only, requires room:archive permission").
+		Returns("204", "Archived", nil)
+	archive.Delete = v1("unarchiveRoom", "rooms", "Restore archived room, requires room:archive permission").
+		Returns("204", "Restored", nil)
+	add(v1Prefix+"/rooms/{room_id}/users/add", v1Path(roomID)).Put = v1("addUsersToRoom", "rooms", "Add members, requires server token").
+		Body(membersSchema).
+		Returns("204", "Added", nil).
+		Returns("403", "User is banned from room", oa.ErrorSchema).
+		Returns("404", "User not found", oa.ErrorSchema).
+		Returns("409", "Room is direct message or archived room", oa.ErrorSchema)
+	add(v1Prefix+"/rooms/{room_id}/users/remove", v1Path(roomID)).Put = v1("removeUsersFromRoom", "rooms", "Remove members, their room streams end. Requires server token").
+		Body(membersSchema).
+		Returns("204", "Removed", nil).
+		Returns("409", "Room is direct message or archived room", oa.ErrorSchema)
+	add(v1Prefix+"/rooms/{room_id}/typing_indicators", v1Path(roomID)).Post = v1("sendTypingIndicator", "rooms", "Notify members that user is typing").
+		Returns("204", "Sent", nil)
+	add(v1Prefix+"/rooms/{room_id}/attachments", v1Path(roomID)).Post = v1("uploadAttachment", "files", "Upload attachment").
+		Returns("201", "Uploaded", object)
+	add(v1Prefix+"/rooms/{room_id}/files/{file_name}", v1Path(roomID, fileName)).Get = v1("getFile", "files", "Get file").
+		Returns("200", "File metadata", object)
+	paths[v1Prefix+"/rooms/{room_id}/files/{file_name}"].Delete = v1("deleteFile", "files", "Delete file").
+		Returns("204", "Deleted", nil)
+	add(v1Prefix+"/rooms/{room_id}/users/{user_id}/files/{file_name}", v1Path(roomID, userID, fileName)).Post = v1("uploadUserFile", "files", "Upload file on behalf of user").
+		Returns("201", "Uploaded", object)
+	add(v1Prefix+"/rooms/{room_id}/users/{user_id}/files", v1Path(roomID, userID)).Delete = v1("deleteUserFiles", "files", "Delete files of user in room").
+		Returns("204", "Deleted", nil)
+
+	// Messages
+	messages := add(v1Prefix+"/rooms/{room_id}/messages", v1Path(roomID))
+	messages.Get = v1("listMessages", "messages", "Room history").
+		Query("initial_id", false, &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(1)}).
+		Query("direction", false, &oa.Schema{Type: oa.TypeString, Enum: []string{"older", "newer"}}).
+		Query("limit", false, limit).
+		Returns("200", "Messages", arrayOf(messageSchema))
+	messages.Post = v1("sendMessage", "messages", "Send message").
+		Body(sendMessageSchema).
+		Returns("201", "Sent message id", &oa.Schema{
+			Type:       oa.TypeObject,
+			Properties: map[string]*oa.Schema{"message_id": {Type: oa.TypeInteger}},
+		}).
+		Returns("403", "User is banned or muted", oa.ErrorSchema).
+		Returns("409", "Room is archived", oa.ErrorSchema).
+		Returns("422", "Message is rejected by filter or has unsafe markdown", oa.ErrorSchema).
+		Returns("503", "Moderation service is unavailable", oa.ErrorSchema)
+	message := add(v1Prefix+"/rooms/{room_id}/messages/{message_id}", v1Path(roomID, messageID))
+	message.Get = v1("getMessage", "messages", "Get message").
+		Returns("200", "Message", messageSchema)
+	message.Put = v1("editMessage", "messages", "Edit message on behalf of its author, newly mentioned users are notified").
+		Body(editMessageSchema).
+		Returns("204", "Edited", nil).
+		Returns("403", "Token user is not the author or is banned or muted", oa.ErrorSchema).
+		Returns("409", "Message is deleted or room is archived", oa.ErrorSchema).
+		Returns("422", "Message is rejected by filter or has unsafe markdown", oa.ErrorSchema).
+		Returns("503", "Moderation service is unavailable", oa.ErrorSchema)
+	message.Delete = v1("deleteMessage", "messages", "Delete message on behalf of its author or user with room:moderate permission, it stays in history as deleted").
+		Returns("204", "Deleted", nil).
+		Returns("403", "Token user is neither the author nor moderator", oa.ErrorSchema).
+		Returns("409", "Room is archived", oa.ErrorSchema)
+	add(v1Prefix+"/rooms/{room_id}/messages/{message_id}/replies", v1Path(roomID, messageID)).Get = v1("listReplies", "messages", "Thread of message").
+		Query("initial_id", false, &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(1)}).
+		Query("direction", false, &oa.Schema{Type: oa.TypeString, Enum: []string{"older", "newer"}}).
+		Query("limit", false, limit).
+		Returns("200", "Replies", arrayOf(messageSchema))
+	threadCursor := add(v1Prefix+"/rooms/{room_id}/messages/{message_id}/cursor", v1Path(roomID, messageID))
+	threadCursor.Get = v1("getThreadCursor", "cursors", "Read state of token user in thread of message").
+		Returns("200", "Read state", &oa.Schema{
+			Type: oa.TypeObject,
+			Properties: map[string]*oa.Schema{
+				"cursor":       &oa.Schema{Type: oa.TypeObject, Nullable: true, Properties: cursorSchema.Properties},
+				"thread_id":    &oa.Schema{Type: oa.TypeInteger},
+				"unread_count": &oa.Schema{Type: oa.TypeInteger},
+			},
+		})
+	threadCursor.Put = v1("setThreadCursor", "cursors", "Mark thread of message read by token user").
+		Body(setCursorSchema).
+		Returns("204", "Set", nil)
+	add(v1Prefix+"/users/{user_id}/dm/{other_id}", v1Path(userID, oa.PathParam("other_id", nonEmpty(160)))).Post = v1("createDM", "rooms", "Direct message room of two users, created on first call. Requires token of the user or server token").
+		Returns("200", "Existing room", roomSchema).
+		Returns("201", "Created room", roomSchema)
+	add(v1Prefix+"/users/{user_id}/dm", v1Path(userID)).Post = v1("createGroupDM", "rooms", "Direct message room of user and listed users, one room per member set. Requires token of the user or server token").
+		Body(&oa.Schema{
+			Type: oa.TypeObject,
+			Properties: map[string]*oa.Schema{
+				"user_ids": &oa.Schema{Type: oa.TypeArray, MinItems: oa.Int(1), MaxItems: oa.Int(8), Items: nonEmpty(160)},
+			},
+			Required: []string{"user_ids"},
+		}).
+		Returns("200", "Existing room", roomSchema).
+		Returns("201", "Created room", roomSchema)
+	add(v1Prefix+"/users/{user_id}/mentions", v1Path(userID)).Get = v1("listMentions", "messages", "Messages mentioning user, newest first. Requires token of the user or server token").
+		Query("initial_id", false, &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(1)}).
+		Query("limit", false, limit).
+		Returns("200", "Messages", arrayOf(messageSchema))
+	add(v1Prefix+"/rooms/{room_id}/pins", v1Path(roomID)).Get = v1("listPins", "messages", "Pinned messages of room, recently pinned first").
+		Returns("200", "Messages", arrayOf(messageSchema))
+	pin := add(v1Prefix+"/rooms/{room_id}/messages/{message_id}/pin", v1Path(roomID, messageID))
+	pin.Put = v1("pinMessage", "messages", "Pin message, requires message:pin permission").
+		Returns("204", "Pinned", nil).
+		Returns("409", "Message is deleted, room is archived or has too many pins", oa.ErrorSchema)
+	pin.Delete = v1("unpinMessage", "messages", "Unpin message, requires message:pin permission").
+		Returns("204", "Unpinned", nil).
+		Returns("409", "Room is archived", oa.ErrorSchema)
+	reaction := add(v1Prefix+"/rooms/{room_id}/messages/{message_id}/reactions/{reaction}", v1Path(roomID, messageID, oa.PathParam("reaction", nonEmpty(64))))
+	reaction.Put = v1("addReaction", "messages", "React to message on behalf of token user, one reaction of each kind per user").
+		Returns("200", "Reactions of message", arrayOf(reactionSchema)).
+		Returns("409", "Message is deleted or room is archived", oa.ErrorSchema)
+	reaction.Delete = v1("removeReaction", "messages", "Remove reaction of token user").
+		Returns("200", "Reactions of message", arrayOf(reactionSchema)).
+		Returns("409", "Message is deleted or room is archived", oa.ErrorSchema)
+
+	// Exports
+	exportSchema := &oa.Schema{
+		Type: oa.TypeObject,
+		Properties: map[string]*oa.Schema{
+			"id":           str,
+			"room_id":      str,
+			"format":       str,
+			"status":       &oa.Schema{Type: oa.TypeString, Enum: []string{"pending", "running", "done", "failed"}},
+			"error":        str,
+			"requested_by": str,
+			"size":         &oa.Schema{Type: oa.TypeInteger},
+			"messages":     &oa.Schema{Type: oa.TypeInteger},
+			"created_at":   dateTime,
+			"finished_at":  dateTime,
+			"download_url": str,
+		},
+	}
+	exportID := oa.PathParam("export_id", str)
+	add(v1Prefix+"/rooms/{room_id}/exports", v1Path(roomID)).Post = v1("createExport", "exports", "Start room transcript export, requires server token").
+		Body(&oa.Schema{
+			Type: oa.TypeObject,
+			Properties: map[string]*oa.Schema{
+				"format": &oa.Schema{Type: oa.TypeString, Enum: []string{"jsonl", "csv", "html"}},
+			},
+			Required: []string{"format"},
+		}).
+		Returns("202", "Export job, poll it until status is done", exportSchema)
+	add(v1Prefix+"/rooms/{room_id}/exports/{export_id}", v1Path(roomID, exportID)).Get = v1("getExport", "exports", "Export job status, download_url is set when done").
+		Returns("200", "Export job", exportSchema)
+	add(v1Prefix+"/rooms/{room_id}/exports/{export_id}/download", v1Path(roomID, exportID)).Get = v1("downloadExport", "exports", "Download finished transcript").
+		Returns("200", "Transcript in requested format", nil).
+		Returns("409", "Export is not finished", oa.ErrorSchema)
+
+	// Retention
+	roomRetention := add(v1Prefix+"/rooms/{room_id}/retention", v1Path(roomID))
+	roomRetention.Put = v1("setRoomRetention", "retention", "Override instance retention policy for room, requires server token").
+		Body(retentionSchema).
+		Returns("200", "Room", roomSchema)
+	roomRetention.Delete = v1("deleteRoomRetention", "retention", "Fall back to instance retention policy, requires server token").
+		Returns("204", "Removed", nil)
+	roomHold := add(v1Prefix+"/rooms/{room_id}/legal_hold", v1Path(roomID))
+	roomHold.Put = v1("holdRoom", "retention", "Exempt room messages from retention, requires server token").
+		Returns("204", "Held", nil)
+	roomHold.Delete = v1("releaseRoom", "retention", "Release room legal hold, requires server token").
+		Returns("204", "Released", nil)
+	userHold := add(v1Prefix+"/users/{user_id}/legal_hold", v1Path(userID))
+	userHold.Put = v1("holdUser", "retention", "Exempt messages of user from retention, requires server token").
+		Returns("204", "Held", nil)
+	userHold.Delete = v1("releaseUser", "retention", "Release user legal hold, requires server token").
+		Returns("204", "Released", nil)
+	add(v1Prefix+"/retention/audit", v1Path()).Get = v1("listRetentionAudit", "retention", "Purged messages newest first, requires server token").
+		Query("room_id", false, str).
+		Query("before", false, str).
+		Query("limit", false, limit).
+		Returns("200", "Audit records", arrayOf(retentionAuditSchema))
+
+	// Moderation
+	add(v1Prefix+"/rooms/{room_id}/bans", v1Path(roomID)).Get = v1("listBans", "moderation", "Active bans of room, requires room:moderate permission").
+		Returns("200", "Bans", arrayOf(sanctionSchema))
+	ban := add(v1Prefix+"/rooms/{room_id}/bans/{user_id}", v1Path(roomID, userID))
+	ban.Put = v1("banUser", "moderation", "Remove user from room and keep them from joining and posting, requires room:moderate permission").
+		Body(moderationSchema).
+		Returns("204", "Banned", nil).
+		Returns("409", "Room is archived or is direct message room", oa.ErrorSchema)
+	ban.Delete = v1("unbanUser", "moderation", "Lift ban, requires room:moderate permission").
+		Returns("204", "Lifted", nil)
+	add(v1Prefix+"/rooms/{room_id}/mutes", v1Path(roomID)).Get = v1("listMutes", "moderation", "Active mutes of room, requires room:moderate permission").
+		Returns("200", "Mutes", arrayOf(sanctionSchema))
+	mute := add(v1Prefix+"/rooms/{room_id}/mutes/{user_id}", v1Path(roomID, userID))
+	mute.Put = v1("muteUser", "moderation", "Keep member from posting, requires room:moderate permission").
+		Body(moderationSchema).
+		Returns("204", "Muted", nil).
+		Returns("409", "Room is direct message room", oa.ErrorSchema)
+	mute.Delete = v1("unmuteUser", "moderation", "Lift mute, requires room:moderate permission").
+		Returns("204", "Lifted", nil)
+	add(v1Prefix+"/rooms/{room_id}/users/{user_id}/kick", v1Path(roomID, userID)).Post = v1("kickUser", "moderation", "Remove member from room, requires room:moderate permission").
+		Body(kickSchema).
+		Returns("204", "Kicked", nil).
+		Returns("404", "User is not a member", oa.ErrorSchema).
+		Returns("409", "Room is archived or is direct message room", oa.ErrorSchema)
+	roomFilters := add(v1Prefix+"/rooms/{room_id}/filters", v1Path(roomID))
+	roomFilters.Get = v1("getRoomFilters", "moderation", "Message filter rules of room, requires room:moderate permission").
+		Returns("200", "Rules", filtersSchema)
+	roomFilters.Put = v1("setRoomFilters", "moderation", "Replace message filter rules of room, they apply after instance rules. Requires room:moderate permission").
+		Body(filtersSchema).
+		Returns("204", "Replaced", nil)
+	roomFilters.Delete = v1("deleteRoomFilters", "moderation", "Remove message filter rules of room, requires room:moderate permission").
+		Returns("204", "Removed", nil)
+	add(v1Prefix+"/rooms/{room_id}/flagged", v1Path(roomID)).Get = v1("listFlagged", "moderation", "Messages held for review by filters newest first, requires room:moderate permission").
+		Query("initial_id", false, &oa.Schema{Type: oa.TypeInteger, Minimum: oa.Float(1)}).
+		Query("limit", false, limit).
+		Returns("200", "Messages", arrayOf(messageSchema))
+	add(v1Prefix+"/rooms/{room_id}/messages/{message_id}/flags", v1Path(roomID, messageID)).Delete = v1("clearFlags", "moderation", "Mark flagged message as reviewed, requires room:moderate permission").
+		Returns("204", "Cleared", nil)
+	add(v1Prefix+"/rooms/{room_id}/moderation_log", v1Path(roomID)).Get = v1("listModerationLog", "moderation", "Moderation actions newest first, requires room:moderate permission").
+		Query("before", false, str).
+		Query("limit", false, limit).
+		Returns("200", "Log entries", arrayOf(moderationEntrySchema))
+
+	// Reports
+	reportID := oa.PathParam("report_id", str)
+	reportStatus := &oa.Schema{Type: oa.TypeString, Enum: []string{"open", "resolved"}}
+	add(v1Prefix+"/rooms/{room_id}/messages/{message_id}/report", v1Path(roomID, messageID)).Post = v1("reportMessage", "reports", "Report message to moderators, it is hidden once it has as many open reports as configured").
+		Body(reportRequestSchema).
+		Returns("201", "Report", reportSchema).
+		Returns("409", "Message is deleted or already reported by user", oa.ErrorSchema)
+	add(v1Prefix+"/rooms/{room_id}/reports", v1Path(roomID)).Get = v1("listReports", "reports", "Reports of room with reported messages newest first, requires room:moderate permission").
+		Query("status", false, reportStatus).
+		Query("before", false, str).
+		Query("limit", false, limit).
+		Returns("200", "Reports", arrayOf(reportSchema))
+	add(v1Prefix+"/rooms/{room_id}/reports/{report_id}/resolve", v1Path(roomID, reportID)).Post = v1("resolveReport", "reports", "Resolve all open reports of message, requires room:moderate permission").
+		Body(resolveReportSchema).
+		Returns("200", "Resolved report", reportSchema).
+		Returns("409", "Report is already resolved or sender can't be banned", oa.ErrorSchema)
+	add(v1Prefix+"/reports", v1Path()).Get = v1("listAllReports", "reports", "Reports of all rooms newest first, requires server token").
+		Query("status", false, reportStatus).
+		Query("before", false, str).
+		Query("limit", false, limit).
+		Returns("200", "Reports", arrayOf(reportSchema))
+
+	// Roles
+	roles := add(v1Prefix+"/roles", v1Path())
+	roles.Get = v1("listRoles", "roles", "List roles, requires server token").
+		Returns("200", "Roles", arrayOf(roleSchema))
+	roles.Post = v1("createRole", "roles", "Create role, requires server token").
+		Body(roleSchema).
+		Returns("201", "Created", roleSchema).
+		Returns("409", "Role already exists", oa.ErrorSchema)
+	add(v1Prefix+"/roles/{role_name}/scope/{scope_name}", v1Path(roleName, scopeName)).Delete = v1("deleteRole", "roles", "Delete role with its assignments, requires server token").
+		Returns("204", "Deleted", nil).
+		Returns("404", "Role not found", oa.ErrorSchema)
+	permissions := add(v1Prefix+"/roles/{role_name}/scope/{scope_name}/permissions", v1Path(roleName, scopeName))
+	permissions.Get = v1("rolePermissions", "roles", "Permissions of role, requires server token").
+		Returns("200", "Permissions", stringList).
+		Returns("404", "Role not found", oa.ErrorSchema)
+	permissions.Put = v1("updateRolePermissions", "roles", "Add or remove permissions of role, requires server token").
+		Body(permissionsSchema).
+		Returns("204", "Updated", nil).
+		Returns("404", "Role not found", oa.ErrorSchema)
+
+	// Cursors
+	cursor := add(v1Prefix+"/cursors/0/rooms/{room_id}/users/{user_id}", v1Path(roomID, userID))
+	cursor.Get = v1("getReadCursor", "cursors", "Read cursor of user in room. Requires token of the user or server token").
+		Returns("200", "Cursor", cursorSchema).
+		Returns("404", "User has not read room yet", oa.ErrorSchema)
+	cursor.Put = v1("setReadCursor", "cursors", "Set read cursor of user in room. Requires token of the user or server token").
+		Body(setCursorSchema).
+		Returns("204", "Set", nil).
+		Returns("403", "Token of other user or user is not a member of room", oa.ErrorSchema)
+	roomCursors := add(v1Prefix+"/cursors/0/rooms/{room_id}", v1Path(roomID))
+	roomCursors.Get = v1("roomReadCursors", "cursors", "Read cursors of room members. Requires token of a member or server token").
+		Returns("200", "Cursors", arrayOf(cursorSchema))
+	roomCursors.Subscribe = subscription("subscribeRoomCursors", "cursors", "Subscribe to read cursors of room")
+	add(v1Prefix+"/users/{user_id}/read_states", v1Path(userID)).Get = v1("listReadStates", "cursors", "Read cursors with unread message and mention counts of joined rooms. Requires token of the user or server token").
+		Returns("200", "Read states", arrayOf(&oa.Schema{
+			Type: oa.TypeObject,
+			Properties: map[string]*oa.Schema{
+				"cursor":               cursorSchema,
+				"room_id":              str,
+				"unread_count":         &oa.Schema{Type: oa.TypeInteger},
+				"unread_mention_count": &oa.Schema{Type: oa.TypeInteger},
+			},
+		}))
+	userCursors := add(v1Prefix+"/cursors/0/users/{user_id}", v1Path(userID))
+	userCursors.Get = v1("userReadCursors", "cursors", "Read cursors of user. Requires token of the user or server token").
+		Returns("200", "Cursors", arrayOf(cursorSchema))
+	userCursors.Subscribe = subscription("subscribeUserCursors", "cursors", "Subscribe to read cursors of user")
+
+	// Token
+	add(v1Prefix+"/token", v1Path()).Post = oa.NewOperation("token", "auth", "Issue access token").Secured(securityBasic).
+		Body(tokenRequestSchema).
+		Returns("200", "Token", tokenResponseSchema)
+
+	return &oa.Document{
+		OpenAPI: "3.0.3",
+		Info: oa.Info{
+			Title:       "chatcloud",
+			Description: "Chatkit compatible chat server. SUBSCRIBE operations are listed under x-subscribe and stream JSON lines",
+			Version:     "1",
+		},
+		Paths: paths,
+		Components: &oa.Components{
+			SecuritySchemes: map[string]*oa.SecurityScheme{
+				securityBearer: {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "Access token issued by token endpoint"},
+				securityBasic:  {Type: "http", Scheme: "basic", Description: "Instance key id and secret"},
+				securityAdmin:  {Type: "http", Scheme: "bearer", Description: "Admin token from server config"},
+			},
+		},
+	}
+}
+
+// OpenAPI serves API description
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, s.spec)
+}
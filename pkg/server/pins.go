@@ -0,0 +1,141 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/logger"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+)
+
+func (s *Server) ListPins(w http.ResponseWriter, r *http.Request) {
+	messages, err := mw.TenantFromRequest(r).Messages.Pinned(mw.RoomFromRequest(r).ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, messages)
+}
+
+// PinMessage pins message unless room already has as many pins as configured.
+// Pin slot is reserved on room first, so concurrent pins can't go over the limit
+func (s *Server) PinMessage(w http.ResponseWriter, r *http.Request) {
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	msg := mw.MessageFromRequest(r)
+	if msg.Deleted() || msg.RedactedAt != 0 {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("message is deleted"))
+		return
+	}
+	if msg.PinnedAt != 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if room.PinnedMessageIDs == nil {
+		ids, err := t.Messages.PinnedIDs(room.ID)
+		if err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if err := t.Rooms.InitPins(room.ID, ids); err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	reserved, err := t.Rooms.ReservePin(room.ID, msg.ID, s.cfg.PinsPerRoom)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if !reserved {
+		// Slot may be taken by concurrent pin of the same message
+		current, err := t.Rooms.FindByID(room.ID.Hex())
+		if err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if !hasPin(current.PinnedMessageIDs, msg.ID) {
+			pkg.WriteError(w, r, http.StatusConflict, fmt.Errorf("room already has %d pinned messages", len(current.PinnedMessageIDs)))
+			return
+		}
+	}
+	changed, err := t.Messages.Pin(msg.ID, mw.ClaimsFromRequest(r).Subject)
+	if err != nil {
+		if reserved {
+			s.releasePins(r, room.ID, msg.ID)
+		}
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if changed {
+		s.publishPin(r, hub.EventMessagePinned)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	msg := mw.MessageFromRequest(r)
+	changed, err := mw.TenantFromRequest(r).Messages.Unpin(msg.ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	s.releasePins(r, msg.RoomID, msg.ID)
+	if changed {
+		s.publishPin(r, hub.EventMessageUnpinned)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// releasePins frees pin slots of room. Message state is already stored, so failure is only logged
+func (s *Server) releasePins(r *http.Request, roomID primitive.ObjectID, ids ...int64) {
+	if err := mw.TenantFromRequest(r).Rooms.ReleasePins(roomID, ids); err != nil {
+		logger.FromRequest(r).WithError(err).Warn("can't release pin slots")
+	}
+}
+
+func hasPin(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// publishPin sends message with its current pin state to room subscribers
+func (s *Server) publishPin(r *http.Request, name string) {
+	t := mw.TenantFromRequest(r)
+	msg, err := t.Messages.FindByID(mw.MessageFromRequest(r).ID)
+	if err != nil {
+		msg = mw.MessageFromRequest(r)
+	}
+	s.publish(r, hub.RoomChannel(t.Instance.ID, msg.RoomID.Hex()), name, msg)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/logger"
+	"github.com/neonxp/chatcloud/pkg/metrics"
+	"github.com/neonxp/chatcloud/pkg/models"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+	"github.com/neonxp/chatcloud/pkg/unfurl"
+)
+
+// unfurlLinks fetches previews of URL parts in background, stores them on parts
+// and publishes message_updated once any of them is stored. Messages sent while all unfurl slots are busy are skipped
+// rather than queued, so a burst of links can't pile up goroutines
+func (s *Server) unfurlLinks(r *http.Request, t *tenant.Tenant, msg *models.Message) {
+	if s.unfurl == nil {
+		return
+	}
+	links := map[int]string{}
+	for i, part := range msg.Parts {
+		if unfurlable(part) {
+			links[i] = part.URL
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+	log := logger.FromRequest(r).WithField("message_id", msg.ID)
+	select {
+	case s.unfurls <- struct{}{}:
+	default:
+		metrics.Unfurls.WithLabelValues("skipped").Add(float64(len(links)))
+		log.Debug("all unfurl slots are busy, links are left without preview")
+		return
+	}
+	s.background(func(ctx context.Context) {
+		defer func() { <-s.unfurls }()
+		stored := false
+		for i, link := range links {
+			preview, err := s.unfurl.Unfurl(ctx, link)
+			if err == unfurl.ErrNoPreview {
+				continue
+			}
+			if err != nil {
+				log.WithError(err).WithField("url", link).Debug("can't unfurl link")
+				continue
+			}
+			ok, err := t.Messages.SetPreview(msg.ID, i, link, preview)
+			if err != nil {
+				log.WithError(err).Warn("can't store link preview")
+				continue
+			}
+			stored = stored || ok
+		}
+		if !stored {
+			return
+		}
+		updated, err := t.Messages.FindByID(msg.ID)
+		if err != nil {
+			log.WithError(err).Warn("can't load message for event")
+			return
+		}
+		if updated.HiddenAt != 0 {
+			return
+		}
+		if err := s.hub.Publish(hub.RoomChannel(t.Instance.ID, msg.RoomID.Hex()), hub.EventMessageUpdated, updated); err != nil {
+			log.WithError(err).WithField("event", hub.EventMessageUpdated).Warn("can't publish event")
+		}
+	})
+}
+
+// unfurlable reports whether part links to page, media links are rendered by clients themselves
+func unfurlable(part models.MessagePart) bool {
+	if part.URL == "" || part.Preview != nil {
+		return false
+	}
+	for _, media := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(part.Type, media) {
+			return false
+		}
+	}
+	return true
+}
+
+// keepPreviews copies previews of old parts to edited parts linking to the same URL, so they are not fetched again
+func keepPreviews(old []models.MessagePart, edited []models.MessagePart) []models.MessagePart {
+	previews := map[string]*models.LinkPreview{}
+	for _, part := range old {
+		if part.URL != "" && part.Preview != nil {
+			previews[part.URL] = part.Preview
+		}
+	}
+	for i := range edited {
+		if edited[i].URL != "" {
+			edited[i].Preview = previews[edited[i].URL]
+		}
+	}
+	return edited
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/models"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+)
+
+// reactionEvent is sent to room subscribers when reactions of message change
+type reactionEvent struct {
+	MessageID int64             `json:"message_id"`
+	Reaction  string            `json:"reaction"`
+	UserID    string            `json:"user_id"`
+	Reactions []models.Reaction `json:"reactions"`
+}
+
+// AddReaction reacts to message on behalf of token user, repeated reaction with the same emoji changes nothing
+func (s *Server) AddReaction(w http.ResponseWriter, r *http.Request) {
+	s.react(w, r, true)
+}
+
+func (s *Server) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	s.react(w, r, false)
+}
+
+func (s *Server) react(w http.ResponseWriter, r *http.Request, add bool) {
+	userID, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	msg := mw.MessageFromRequest(r)
+	if msg.Deleted() || msg.RedactedAt != 0 {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("message is deleted"))
+		return
+	}
+	reaction := chi.URLParam(r, "reaction")
+	var changed bool
+	var err error
+	if add {
+		changed, err = t.Messages.AddReaction(msg.ID, reaction, userID)
+	} else {
+		changed, err = t.Messages.RemoveReaction(msg.ID, reaction, userID)
+	}
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if msg, err = t.Messages.FindByID(msg.ID); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	reactions := msg.Reactions
+	if reactions == nil {
+		reactions = []models.Reaction{}
+	}
+	if changed {
+		name := hub.EventReactionAdded
+		if !add {
+			name = hub.EventReactionRemoved
+		}
+		event := &reactionEvent{MessageID: msg.ID, Reaction: reaction, UserID: userID, Reactions: reactions}
+		s.publish(r, hub.RoomChannel(t.Instance.ID, msg.RoomID.Hex()), name, event)
+	}
+	render.JSON(w, r, reactions)
+}
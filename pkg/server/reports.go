@@ -0,0 +1,284 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/logger"
+	"github.com/neonxp/chatcloud/pkg/models"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+// ReportMessage files report of token user, message is hidden once it has as many open reports as configured
+func (s *Server) ReportMessage(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.ReportRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	userID, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	msg := mw.MessageFromRequest(r)
+	if msg.UserID == userID {
+		pkg.WriteError(w, r, http.StatusBadRequest, errors.New("users can't report own messages"))
+		return
+	}
+	if msg.Deleted() || msg.RedactedAt != 0 {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("message is deleted"))
+		return
+	}
+	reported, err := t.Reports.Reported(msg.ID, userID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if reported {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("message is already reported by user"))
+		return
+	}
+	report := &models.Report{
+		RoomID:     msg.RoomID,
+		MessageID:  msg.ID,
+		SenderID:   msg.UserID,
+		ReporterID: userID,
+		Reason:     req.Reason,
+	}
+	if err := t.Reports.Add(report); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			pkg.WriteError(w, r, http.StatusConflict, errors.New("message is already reported by user"))
+			return
+		}
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if s.cfg.ReportThreshold > 0 && msg.HiddenAt == 0 {
+		s.hideReported(r, t, msg)
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, report)
+}
+
+// ListReports is moderator queue of room, open reports by default
+func (s *Server) ListReports(w http.ResponseWriter, r *http.Request) {
+	s.listReports(w, r, mw.RoomFromRequest(r).ID)
+}
+
+// ListAllReports is moderator queue of whole instance
+func (s *Server) ListAllReports(w http.ResponseWriter, r *http.Request) {
+	s.listReports(w, r, primitive.NilObjectID)
+}
+
+// ResolveReport applies moderator decision to reported message and closes all its open reports
+func (s *Server) ResolveReport(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.ResolveReportRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	reportID := chi.URLParam(r, "report_id")
+	report, err := t.Reports.FindInRoom(room.ID, reportID)
+	if err == mongo.ErrNoDocuments {
+		pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("report %s not found", reportID))
+		return
+	}
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if report.Status != models.ReportOpen {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("report is already resolved"))
+		return
+	}
+	switch req.Action {
+	case models.ResolutionDismiss, models.ResolutionDeleteMessage:
+	case models.ResolutionBanSender:
+		if !moderatable(w, r, report.SenderID) {
+			return
+		}
+	default:
+		pkg.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("unknown action %q", req.Action))
+		return
+	}
+	// Reports are closed before action is applied, so of concurrent moderators only one applies it
+	actorID := mw.ClaimsFromRequest(r).Subject
+	at := primitive.NewDateTimeFromTime(time.Now())
+	n, err := t.Reports.Resolve(report.MessageID, req.Action, actorID, at)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if n == 0 {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("report is already resolved"))
+		return
+	}
+	if !s.applyResolution(w, r, t, report, req, actorID) {
+		if err := t.Reports.Reopen(report.MessageID, at); err != nil {
+			logger.FromRequest(r).WithError(err).WithField("message_id", report.MessageID).Warn("can't reopen reports")
+		}
+		return
+	}
+	if report, err = t.Reports.FindInRoom(room.ID, reportID); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, report)
+}
+
+// applyResolution applies action of moderator to reported message, it writes error and returns false on failure
+func (s *Server) applyResolution(w http.ResponseWriter, r *http.Request, t *tenant.Tenant, report *models.Report, req *rest.ResolveReportRequest, actorID string) bool {
+	room := mw.RoomFromRequest(r)
+	channel := hub.RoomChannel(t.Instance.ID, room.ID.Hex())
+	switch req.Action {
+	case models.ResolutionDismiss:
+		unhidden, err := t.Messages.Unhide(report.MessageID)
+		if err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return false
+		}
+		if unhidden {
+			s.publishMessage(r, channel, hub.EventMessageUnhidden, report.MessageID)
+		}
+	case models.ResolutionDeleteMessage:
+		deleted, err := t.Messages.Delete(report.MessageID)
+		if err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return false
+		}
+		s.releasePins(r, room.ID, report.MessageID)
+		if deleted {
+			s.publishMessage(r, channel, hub.EventMessageDeleted, report.MessageID)
+		}
+	case models.ResolutionBanSender:
+		reason := req.Reason
+		if reason == "" {
+			reason = report.Reason
+		}
+		return s.impose(w, r, t, &models.Sanction{
+			RoomID:    room.ID,
+			UserID:    report.SenderID,
+			Kind:      models.SanctionBan,
+			Reason:    reason,
+			CreatedBy: actorID,
+			CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+		})
+	}
+	return true
+}
+
+func (s *Server) listReports(w http.ResponseWriter, r *http.Request, roomID primitive.ObjectID) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	if status == "" {
+		status = models.ReportOpen
+	}
+	var before primitive.ObjectID
+	if v := q.Get("before"); v != "" {
+		var err error
+		if before, err = primitive.ObjectIDFromHex(v); err != nil {
+			pkg.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid before: %w", err))
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	t := mw.TenantFromRequest(r)
+	reports, err := t.Reports.Find(roomID, status, before, limit)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	ids := make([]int64, 0, len(reports))
+	for _, report := range reports {
+		ids = append(ids, report.MessageID)
+	}
+	messages, err := t.Messages.FindByIDs(ids)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	byID := make(map[int64]*models.Message, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+	}
+	for _, report := range reports {
+		report.Message = byID[report.MessageID]
+	}
+	render.JSON(w, r, reports)
+}
+
+// hideReported hides message once its open reports reach threshold, failures are logged as report itself is stored
+func (s *Server) hideReported(r *http.Request, t *tenant.Tenant, msg *models.Message) {
+	log := logger.FromRequest(r).WithField("message_id", msg.ID)
+	n, err := t.Reports.CountOpen(msg.ID)
+	if err != nil {
+		log.WithError(err).Warn("can't count reports")
+		return
+	}
+	if n < int64(s.cfg.ReportThreshold) {
+		return
+	}
+	hidden, err := t.Messages.Hide(msg.ID)
+	if err != nil {
+		log.WithError(err).Warn("can't hide reported message")
+		return
+	}
+	if hidden {
+		s.publishMessage(r, hub.RoomChannel(t.Instance.ID, msg.RoomID.Hex()), hub.EventMessageHidden, msg.ID)
+	}
+}
+
+// publishMessage sends current state of message to channel
+func (s *Server) publishMessage(r *http.Request, channel string, name string, id int64) {
+	msg, err := mw.TenantFromRequest(r).Messages.FindByID(id)
+	if err != nil {
+		logger.FromRequest(r).WithError(err).WithField("event", name).Warn("can't load message for event")
+		return
+	}
+	s.publish(r, channel, name, msg)
+}
+
+// canModerate reports whether token may moderate room of request
+func canModerate(r *http.Request) (bool, error) {
+	claims := mw.ClaimsFromRequest(r)
+	if claims.SU {
+		return true, nil
+	}
+	return mw.TenantFromRequest(r).Roles.Can(claims.Subject, mw.RoomFromRequest(r).ID.Hex(), models.PermissionRoomModerate)
+}
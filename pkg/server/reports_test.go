@@ -0,0 +1,309 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/neonxp/chatcloud/pkg/config"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/manager"
+	"github.com/neonxp/chatcloud/pkg/models"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+	"github.com/neonxp/chatcloud/pkg/token"
+)
+
+// reportFixture serves handlers of one room from mocked database, events go to unreachable redis and are dropped
+type reportFixture struct {
+	mt     *mtest.T
+	server *Server
+	tenant *tenant.Tenant
+	room   *models.Room
+}
+
+func newReportFixture(mt *mtest.T, threshold int) *reportFixture {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	cfg.ReportThreshold = threshold
+	rds := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 10 * time.Millisecond})
+	mt.Cleanup(func() { _ = rds.Close() })
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	t := &tenant.Tenant{Instance: &models.Instance{ID: "test"}}
+	if t.Messages, err = manager.NewMessage(mt.DB.Collection("messages"), rds); err != nil {
+		mt.Fatal(err)
+	}
+	if t.Rooms, err = manager.NewRoom(mt.DB.Collection("rooms")); err != nil {
+		mt.Fatal(err)
+	}
+	if t.Reports, err = manager.NewReport(mt.DB.Collection("reports")); err != nil {
+		mt.Fatal(err)
+	}
+	if t.Moderation, err = manager.NewModeration(mt.DB.Collection("sanctions"), mt.DB.Collection("moderation_log")); err != nil {
+		mt.Fatal(err)
+	}
+	return &reportFixture{
+		mt:     mt,
+		server: &Server{cfg: cfg, log: log, hub: hub.New(rds)},
+		tenant: t,
+		room:   &models.Room{ID: primitive.NewObjectID(), Name: "room", MemberUserIDs: []string{"moderator", "sender", "reporter"}},
+	}
+}
+
+// serve calls handler as subject with route values in context set by middlewares
+func (f *reportFixture) serve(handler http.HandlerFunc, subject string, body string, msg *models.Message, params map[string]string) *httptest.ResponseRecorder {
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+	ctx = context.WithValue(ctx, "tenant", f.tenant)
+	ctx = context.WithValue(ctx, "room", f.room)
+	ctx = context.WithValue(ctx, "claims", &token.Claims{StandardClaims: jwt.StandardClaims{Subject: subject}, Instance: "test"})
+	if msg != nil {
+		ctx = context.WithValue(ctx, "message", msg)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// commands lists commands sent to database as "name collection"
+func (f *reportFixture) commands() []string {
+	var commands []string
+	for _, e := range f.mt.GetAllStartedEvents() {
+		collection, _ := e.Command.Lookup(e.CommandName).StringValueOK()
+		commands = append(commands, e.CommandName+" "+collection)
+	}
+	return commands
+}
+
+func document(mt *mtest.T, v interface{}) bson.D {
+	b, err := bson.Marshal(v)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	var d bson.D
+	if err := bson.Unmarshal(b, &d); err != nil {
+		mt.Fatal(err)
+	}
+	return d
+}
+
+func found(mt *mtest.T, ns string, v interface{}) bson.D {
+	return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, document(mt, v))
+}
+
+func modified(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+func TestReportThreshold(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	tests := []struct {
+		name      string
+		threshold int
+		reporters []string
+		hiddenAt  primitive.DateTime
+		commands  []string
+	}{
+		{
+			name:      "below threshold",
+			threshold: 3,
+			reporters: []string{"a", "reporter"},
+			commands:  []string{"aggregate reports", "insert reports", "distinct reports"},
+		},
+		{
+			name:      "threshold reached",
+			threshold: 3,
+			reporters: []string{"a", "b", "reporter"},
+			commands:  []string{"aggregate reports", "insert reports", "distinct reports", "update messages", "find messages"},
+		},
+		{
+			name:      "hiding disabled",
+			threshold: 0,
+			commands:  []string{"aggregate reports", "insert reports"},
+		},
+		{
+			name:      "already hidden",
+			threshold: 1,
+			hiddenAt:  primitive.NewDateTimeFromTime(time.Now()),
+			commands:  []string{"aggregate reports", "insert reports"},
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			f := newReportFixture(mt, tt.threshold)
+			msg := &models.Message{ID: 7, RoomID: f.room.ID, UserID: "sender", HiddenAt: tt.hiddenAt}
+			reporters := bson.A{}
+			for _, id := range tt.reporters {
+				reporters = append(reporters, id)
+			}
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "test.reports", mtest.FirstBatch),
+				mtest.CreateSuccessResponse(),
+				mtest.CreateSuccessResponse(bson.E{Key: "values", Value: reporters}),
+				modified(1),
+				found(mt, "test.messages", msg),
+			)
+			w := f.serve(f.server.ReportMessage, "reporter", `{"reason":"spam"}`, msg, nil)
+			if w.Code != http.StatusCreated {
+				mt.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if got := f.commands(); !reflect.DeepEqual(got, tt.commands) {
+				mt.Errorf("commands %v, want %v", got, tt.commands)
+			}
+		})
+	}
+}
+
+func TestResolveReport(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	tests := []struct {
+		name      string
+		subject   string
+		action    string
+		status    string
+		responses func(mt *mtest.T, report *models.Report, msg *models.Message) []bson.D
+		code      int
+		commands  []string
+	}{
+		{
+			name:   "dismiss",
+			action: models.ResolutionDismiss,
+			responses: func(mt *mtest.T, report *models.Report, msg *models.Message) []bson.D {
+				return []bson.D{modified(2), modified(1), found(mt, "test.messages", msg), found(mt, "test.reports", report)}
+			},
+			code:     http.StatusOK,
+			commands: []string{"find reports", "update reports", "update messages", "find messages", "find reports"},
+		},
+		{
+			name:   "delete message",
+			action: models.ResolutionDeleteMessage,
+			responses: func(mt *mtest.T, report *models.Report, msg *models.Message) []bson.D {
+				return []bson.D{modified(1), modified(1), modified(1), found(mt, "test.messages", msg), found(mt, "test.reports", report)}
+			},
+			code:     http.StatusOK,
+			commands: []string{"find reports", "update reports", "update messages", "update rooms", "find messages", "find reports"},
+		},
+		{
+			name:   "ban sender",
+			action: models.ResolutionBanSender,
+			responses: func(mt *mtest.T, report *models.Report, msg *models.Message) []bson.D {
+				return []bson.D{modified(1), modified(0), modified(1), modified(1), mtest.CreateSuccessResponse(), found(mt, "test.reports", report)}
+			},
+			code:     http.StatusOK,
+			commands: []string{"find reports", "update reports", "update sanctions", "update sanctions", "update rooms", "insert moderation_log", "find reports"},
+		},
+		{
+			name:   "resolved meanwhile",
+			action: models.ResolutionDeleteMessage,
+			responses: func(mt *mtest.T, report *models.Report, msg *models.Message) []bson.D {
+				return []bson.D{modified(0)}
+			},
+			code:     http.StatusConflict,
+			commands: []string{"find reports", "update reports"},
+		},
+		{
+			name:     "already resolved",
+			action:   models.ResolutionDismiss,
+			status:   models.ReportResolved,
+			code:     http.StatusConflict,
+			commands: []string{"find reports"},
+		},
+		{
+			name:   "failed action reopens reports",
+			action: models.ResolutionDismiss,
+			responses: func(mt *mtest.T, report *models.Report, msg *models.Message) []bson.D {
+				return []bson.D{modified(2), mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "boom"}), modified(2)}
+			},
+			code:     http.StatusInternalServerError,
+			commands: []string{"find reports", "update reports", "update messages", "update reports"},
+		},
+		{
+			name:     "moderator bans themselves",
+			subject:  "sender",
+			action:   models.ResolutionBanSender,
+			code:     http.StatusBadRequest,
+			commands: []string{"find reports"},
+		},
+		{
+			name:     "unknown action",
+			action:   "mute_sender",
+			code:     http.StatusBadRequest,
+			commands: []string{"find reports"},
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			f := newReportFixture(mt, 3)
+			msg := &models.Message{ID: 7, RoomID: f.room.ID, UserID: "sender"}
+			report := &models.Report{
+				ID:         primitive.NewObjectID(),
+				RoomID:     f.room.ID,
+				MessageID:  msg.ID,
+				SenderID:   msg.UserID,
+				ReporterID: "reporter",
+				Status:     models.ReportOpen,
+			}
+			if tt.status != "" {
+				report.Status = tt.status
+			}
+			mt.AddMockResponses(found(mt, "test.reports", report))
+			if tt.responses != nil {
+				mt.AddMockResponses(tt.responses(mt, report, msg)...)
+			}
+			subject := tt.subject
+			if subject == "" {
+				subject = "moderator"
+			}
+			w := f.serve(f.server.ResolveReport, subject, `{"action":"`+tt.action+`"}`, nil, map[string]string{"report_id": report.ID.Hex()})
+			if w.Code != tt.code {
+				mt.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if got := f.commands(); !reflect.DeepEqual(got, tt.commands) {
+				mt.Errorf("commands %v, want %v", got, tt.commands)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package rest
+
+import (
+	"net/http"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+type SendMessageRequest struct {
+	Parts    []models.MessagePart `json:"parts"`
+	ParentID int64                `json:"parent_id"` // Message to reply to in its thread.
+}
+
+// Bind drops rendered markdown and link previews sent by client, they are filled in by server
+func (m *SendMessageRequest) Bind(r *http.Request) error {
+	dropRendered(m.Parts)
+	return nil
+}
+
+type EditMessageRequest struct {
+	Parts []models.MessagePart `json:"parts"`
+}
+
+// Bind drops rendered markdown and link previews sent by client, they are filled in by server
+func (m *EditMessageRequest) Bind(r *http.Request) error {
+	dropRendered(m.Parts)
+	return nil
+}
+
+func dropRendered(parts []models.MessagePart) {
+	for i := range parts {
+		parts[i].HTML = ""
+		parts[i].Text = ""
+		parts[i].Preview = nil
+	}
+}
+
+type CursorRequest struct {
+	Position int64 `json:"position"`
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (c *CursorRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+type GroupDMRequest struct {
+	UserIDs []string `json:"user_ids"` // Members besides the requesting user.
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (g *GroupDMRequest) Bind(r *http.Request) error {
+	return nil
+}
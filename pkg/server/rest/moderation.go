@@ -0,0 +1,65 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package rest
+
+import (
+	"net/http"
+	"time"
+)
+
+type ModerationRequest struct {
+	Reason    string     `json:"reason"`     // Shown in moderation log and to the affected user.
+	ExpiresAt *time.Time `json:"expires_at"` // Ban or mute is lifted automatically at this time, empty means until lifted by hand.
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (m *ModerationRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+type RoomRefRequest struct {
+	RoomID string `json:"room_id"`
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (j *RoomRefRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+type ReportRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (m *ReportRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+type ResolveReportRequest struct {
+	Action string `json:"action"` // One of dismiss, delete_message or ban_sender.
+	Reason string `json:"reason"` // Reason of ban, reason of report is used when empty.
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (m *ResolveReportRequest) Bind(r *http.Request) error {
+	return nil
+}
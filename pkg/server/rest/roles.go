@@ -0,0 +1,57 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package rest
+
+import (
+	"net/http"
+)
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Scope       string   `json:"scope"` // Either global or room.
+	Permissions []string `json:"permissions"`
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (r *RoleRequest) Bind(req *http.Request) error {
+	return nil
+}
+
+type PermissionsRequest struct {
+	AddPermissions    []string `json:"add_permissions"`
+	RemovePermissions []string `json:"remove_permissions"`
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (p *PermissionsRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+type AssignRoleRequest struct {
+	Name   string `json:"name"`
+	RoomID string `json:"room_id"` // Assigns room role when set, global role otherwise.
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (a *AssignRoleRequest) Bind(r *http.Request) error {
+	return nil
+}
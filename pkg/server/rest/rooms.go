@@ -0,0 +1,60 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package rest
+
+import (
+	"net/http"
+)
+
+type RoomRequest struct {
+	Name                          string      `json:"name"`
+	Private                       bool        `json:"private"`
+	PushNotificationTitleOverride string      `json:"push_notification_title_override"` // Title of push notifications instead of room name.
+	CustomData                    interface{} `json:"custom_data"`
+	UserIDs                       []string    `json:"user_ids"` // Initial members besides the creator.
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (r *RoomRequest) Bind(req *http.Request) error {
+	return nil
+}
+
+type UpdateRoomRequest struct {
+	Name                          string      `json:"name"`    // New name, kept when empty.
+	Private                       *bool       `json:"private"` // Kept when absent.
+	PushNotificationTitleOverride *string     `json:"push_notification_title_override"`
+	CustomData                    interface{} `json:"custom_data"` // Replaces custom data when set.
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (r *UpdateRoomRequest) Bind(req *http.Request) error {
+	return nil
+}
+
+type MembersRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (m *MembersRequest) Bind(r *http.Request) error {
+	return nil
+}
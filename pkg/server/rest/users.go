@@ -22,7 +22,6 @@ THE SOFTWARE.
 package rest
 
 import (
-	"fmt"
 	"net/http"
 )
 
@@ -33,26 +32,24 @@ type UserRequest struct {
 	CustomData interface{} `json:"custom_data"` // Custom data to associate with a user.
 }
 
+// Bind has nothing to check, request is validated against openapi spec
 func (u *UserRequest) Bind(r *http.Request) error {
-	if u.ID == "" {
-		return fmt.Errorf("`id` is required")
-	}
-	if u.Name == "" {
-		return fmt.Errorf("`name` is required")
-	}
+	return nil
+}
+
+type UpdateUserRequest struct {
+	Name       string      `json:"name"`        // New name, kept when empty.
+	AvatarURL  string      `json:"avatar_url"`  // New avatar link, kept when empty.
+	CustomData interface{} `json:"custom_data"` // Replaces custom data when set.
+}
+
+// Bind has nothing to check, request is validated against openapi spec
+func (u *UpdateUserRequest) Bind(r *http.Request) error {
 	return nil
 }
 
 type BatchUsersRequest []*UserRequest
 
 func (u BatchUsersRequest) Bind(r *http.Request) error {
-	for idx, uu := range u {
-		if uu.ID == "" {
-			return fmt.Errorf("%d element: `id` is required", idx)
-		}
-		if uu.Name == "" {
-			return fmt.Errorf("%d element: `name` is required", idx)
-		}
-	}
 	return nil
 }
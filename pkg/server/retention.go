@@ -0,0 +1,160 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+)
+
+func (s *Server) SetInstanceRetention(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.RetentionRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !s.setInstanceRetention(w, r, req) {
+		return
+	}
+	t, err := s.tenants.Get(chi.URLParam(r, "instance_id"))
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	instance := *t.Instance
+	instance.Secret = ""
+	render.JSON(w, r, instance)
+}
+
+func (s *Server) DeleteInstanceRetention(w http.ResponseWriter, r *http.Request) {
+	if s.setInstanceRetention(w, r, nil) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) setInstanceRetention(w http.ResponseWriter, r *http.Request, req *rest.RetentionRequest) bool {
+	var err error
+	if req == nil {
+		err = s.tenants.SetRetention(chi.URLParam(r, "instance_id"), nil)
+	} else {
+		err = s.tenants.SetRetention(chi.URLParam(r, "instance_id"), req.Retention())
+	}
+	if err == mongo.ErrNoDocuments {
+		pkg.WriteError(w, r, http.StatusNotFound, err)
+		return false
+	}
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+func (s *Server) SetRoomRetention(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.RetentionRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	room := *mw.RoomFromRequest(r)
+	room.Retention = req.Retention()
+	if err := mw.TenantFromRequest(r).Rooms.SetRetention(room.ID, room.Retention); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, room)
+}
+
+func (s *Server) DeleteRoomRetention(w http.ResponseWriter, r *http.Request) {
+	if err := mw.TenantFromRequest(r).Rooms.SetRetention(mw.RoomFromRequest(r).ID, nil); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) HoldRoom(w http.ResponseWriter, r *http.Request) {
+	s.holdRoom(w, r, true)
+}
+
+func (s *Server) ReleaseRoom(w http.ResponseWriter, r *http.Request) {
+	s.holdRoom(w, r, false)
+}
+
+func (s *Server) holdRoom(w http.ResponseWriter, r *http.Request, hold bool) {
+	if err := mw.TenantFromRequest(r).Rooms.SetLegalHold(mw.RoomFromRequest(r).ID, hold); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) HoldUser(w http.ResponseWriter, r *http.Request) {
+	s.holdUser(w, r, true)
+}
+
+func (s *Server) ReleaseUser(w http.ResponseWriter, r *http.Request) {
+	s.holdUser(w, r, false)
+}
+
+func (s *Server) holdUser(w http.ResponseWriter, r *http.Request, hold bool) {
+	if err := mw.TenantFromRequest(r).Users.SetLegalHold(mw.UserFromRequest(r).ID, hold); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) ListRetentionAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var roomID, before primitive.ObjectID
+	var err error
+	if v := q.Get("room_id"); v != "" {
+		if roomID, err = primitive.ObjectIDFromHex(v); err != nil {
+			pkg.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid room_id: %w", err))
+			return
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		if before, err = primitive.ObjectIDFromHex(v); err != nil {
+			pkg.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid before: %w", err))
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	records, err := mw.TenantFromRequest(r).Retention.Find(roomID, before, limit)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, records)
+}
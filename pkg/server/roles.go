@@ -0,0 +1,162 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/manager"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+)
+
+func (s *Server) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := mw.TenantFromRequest(r).Roles.FindRoles()
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, roles)
+}
+
+func (s *Server) CreateRole(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.RoleRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	role, err := mw.TenantFromRequest(r).Roles.CreateRole(req.Name, req.Scope, req.Permissions)
+	switch err {
+	case nil:
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, role)
+	case manager.ErrRoleExists:
+		pkg.WriteError(w, r, http.StatusConflict, err)
+	case manager.ErrRoleScope:
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+	default:
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// DeleteRole removes role together with all its assignments
+func (s *Server) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	name, scope := chi.URLParam(r, "role_name"), chi.URLParam(r, "scope_name")
+	if err := mw.TenantFromRequest(r).Roles.RemoveRole(name, scope); err != nil {
+		roleError(w, r, err, name, scope)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) GetRolePermissions(w http.ResponseWriter, r *http.Request) {
+	name, scope := chi.URLParam(r, "role_name"), chi.URLParam(r, "scope_name")
+	role, err := mw.TenantFromRequest(r).Roles.FindRole(name, scope)
+	if err != nil {
+		roleError(w, r, err, name, scope)
+		return
+	}
+	render.JSON(w, r, role.Permissions)
+}
+
+func (s *Server) UpdateRolePermissions(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.PermissionsRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	name, scope := chi.URLParam(r, "role_name"), chi.URLParam(r, "scope_name")
+	roles := mw.TenantFromRequest(r).Roles
+	if _, err := roles.FindRole(name, scope); err != nil {
+		roleError(w, r, err, name, scope)
+		return
+	}
+	if _, err := roles.UpdatePermissions(name, scope, req.AddPermissions, req.RemovePermissions); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) ListUserRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := mw.TenantFromRequest(r).Roles.UserRoles(mw.UserFromRequest(r).ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, roles)
+}
+
+// AssignRole gives user global role or room role when room is set, replacing previous role in the same scope
+func (s *Server) AssignRole(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.AssignRoleRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	if req.RoomID != "" {
+		if _, err := t.Rooms.FindByID(req.RoomID); err == mongo.ErrNoDocuments {
+			pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("room %s not found", req.RoomID))
+			return
+		} else if err != nil {
+			pkg.WriteError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if _, err := t.Roles.Assign(mw.UserFromRequest(r).ID, req.Name, req.RoomID); err == mongo.ErrNoDocuments {
+		pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("role %s not found", req.Name))
+		return
+	} else if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveRole removes room role of user when room_id is given, global role otherwise
+func (s *Server) RemoveRole(w http.ResponseWriter, r *http.Request) {
+	err := mw.TenantFromRequest(r).Roles.Unassign(mw.UserFromRequest(r).ID, r.URL.Query().Get("room_id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case mongo.ErrNoDocuments:
+		pkg.WriteError(w, r, http.StatusNotFound, errors.New("user has no such role"))
+	default:
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func roleError(w http.ResponseWriter, r *http.Request, err error, name string, scope string) {
+	if err == mongo.ErrNoDocuments {
+		pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("role %s in scope %s not found", name, scope))
+		return
+	}
+	pkg.WriteError(w, r, http.StatusInternalServerError, err)
+}
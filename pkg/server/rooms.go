@@ -0,0 +1,316 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/manager"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+// ListRooms lists public rooms, archived rooms are listed only on demand and name filter finds them as well
+func (s *Server) ListRooms(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	filter := manager.RoomFilter{
+		FromID:          q.Get("from_id"),
+		Limit:           limit,
+		IncludePrivate:  q.Get("include_private") == "true",
+		IncludeArchived: q.Get("include_archived") == "true",
+		Name:            q.Get("name"),
+	}
+	if filter.Name != "" {
+		filter.IncludeArchived = true
+	}
+	if filter.IncludePrivate {
+		if claims := mw.ClaimsFromRequest(r); claims == nil || !claims.SU {
+			pkg.WriteError(w, r, http.StatusForbidden, errors.New("server token is required to list private rooms"))
+			return
+		}
+	}
+	rooms, err := mw.TenantFromRequest(r).Rooms.List(filter)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	render.JSON(w, r, rooms)
+}
+
+// CreateRoom creates room on behalf of token user, who becomes its member together with listed users
+func (s *Server) CreateRoom(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.RoomRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	creatorID, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	if err := t.UsersExist(append([]string{creatorID}, req.UserIDs...)); err != nil {
+		membershipError(w, r, err)
+		return
+	}
+	room, err := t.Rooms.CreateRoom(creatorID, req.Name, req.Private, req.PushNotificationTitleOverride, req.CustomData, req.UserIDs)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	for _, id := range room.MemberUserIDs {
+		s.publish(r, hub.UserChannel(t.Instance.ID, id), hub.EventAddedToRoom, room)
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, room)
+}
+
+// GetRoom returns public room to anyone, private rooms only to members and server tokens
+func (s *Server) GetRoom(w http.ResponseWriter, r *http.Request) {
+	room := mw.RoomFromRequest(r)
+	if room.Private {
+		claims := mw.ClaimsFromRequest(r)
+		if claims == nil {
+			pkg.WriteError(w, r, http.StatusUnauthorized, errors.New("access token is required"))
+			return
+		}
+		if !claims.SU && !mw.IsMember(room.MemberUserIDs, claims.Subject) {
+			pkg.WriteError(w, r, http.StatusForbidden, errors.New("user is not a member of room"))
+			return
+		}
+	}
+	render.JSON(w, r, room)
+}
+
+// UpdateRoom changes fields present in request, direct message rooms can't be changed
+func (s *Server) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.UpdateRoomRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	room := mw.RoomFromRequest(r)
+	if room.DM {
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("direct message rooms can't be changed"))
+		return
+	}
+	if err := mw.TenantFromRequest(r).Rooms.Update(room.ID, req.Name, req.Private, req.PushNotificationTitleOverride, req.CustomData); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	s.publishRoom(r, hub.EventRoomUpdated)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteRoom removes room with all its data, rooms whose messages are under legal hold are refused
+func (s *Server) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	if err := mw.TenantFromRequest(r).DeleteRoom(s.publisher(r), mw.RoomFromRequest(r)); err != nil {
+		membershipError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) ListJoinedRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := mw.TenantFromRequest(r).Rooms.JoinedRooms(mw.UserFromRequest(r).ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, rooms)
+}
+
+// AddMembers adds users to room, banned users are refused
+func (s *Server) AddMembers(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.MembersRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err := mw.TenantFromRequest(r).AddMembers(s.publisher(r), mw.RoomFromRequest(r), req.UserIDs); err != nil {
+		membershipError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMembers removes users from room, their room streams end
+func (s *Server) RemoveMembers(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.MembersRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err := mw.TenantFromRequest(r).RemoveMembers(s.publisher(r), mw.RoomFromRequest(r), req.UserIDs); err != nil {
+		membershipError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaveRoom removes user from room, leaving room user is not member of does nothing
+func (s *Server) LeaveRoom(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.RoomRefRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	user := mw.UserFromRequest(r)
+	room, err := t.Rooms.FindByID(req.RoomID)
+	if err == mongo.ErrNoDocuments {
+		pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("room %s not found", req.RoomID))
+		return
+	}
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if mw.IsMember(room.MemberUserIDs, user.ID) {
+		if err := t.RemoveMembers(s.publisher(r), room, []string{user.ID}); err != nil {
+			membershipError(w, r, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListJoinableRooms returns public rooms user is not member of, direct message rooms are never listed
+func (s *Server) ListJoinableRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := mw.TenantFromRequest(r).Rooms.JoinableRooms(mw.UserFromRequest(r).ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, rooms)
+}
+
+// JoinRoom adds user to public room unless they are banned from it, server tokens can add to private rooms as well
+func (s *Server) JoinRoom(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.RoomRefRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	user := mw.UserFromRequest(r)
+	room, err := t.Rooms.FindByID(req.RoomID)
+	if err == mongo.ErrNoDocuments {
+		pkg.WriteError(w, r, http.StatusNotFound, fmt.Errorf("room %s not found", req.RoomID))
+		return
+	}
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if mw.IsMember(room.MemberUserIDs, user.ID) {
+		render.JSON(w, r, room)
+		return
+	}
+	switch {
+	case room.DM:
+		pkg.WriteError(w, r, http.StatusForbidden, errors.New("direct message rooms can't be joined"))
+		return
+	case room.Private && !mw.ClaimsFromRequest(r).SU:
+		pkg.WriteError(w, r, http.StatusForbidden, errors.New("private rooms can't be joined"))
+		return
+	case room.Archived():
+		pkg.WriteError(w, r, http.StatusConflict, errors.New("room is archived"))
+		return
+	}
+	banned, err := t.Moderation.Banned(room.ID, user.ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if banned {
+		pkg.WriteError(w, r, http.StatusForbidden, errors.New("user is banned from room"))
+		return
+	}
+	if err := t.Rooms.AddMembers(room.ID, []string{user.ID}); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	room.MemberUserIDs = append(room.MemberUserIDs, user.ID)
+	s.publish(r, hub.UserChannel(t.Instance.ID, user.ID), hub.EventAddedToRoom, room)
+	render.JSON(w, r, room)
+}
+
+// ArchiveRoom makes room read only and hides it from listings
+func (s *Server) ArchiveRoom(w http.ResponseWriter, r *http.Request) {
+	t := mw.TenantFromRequest(r)
+	changed, err := t.Rooms.Archive(mw.RoomFromRequest(r).ID, mw.ClaimsFromRequest(r).Subject)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if changed {
+		s.publishRoom(r, hub.EventRoomArchived)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) UnarchiveRoom(w http.ResponseWriter, r *http.Request) {
+	changed, err := mw.TenantFromRequest(r).Rooms.Unarchive(mw.RoomFromRequest(r).ID)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if changed {
+		s.publishRoom(r, hub.EventRoomUnarchived)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishRoom sends room with its current state to room subscribers
+func (s *Server) publishRoom(r *http.Request, name string) {
+	t := mw.TenantFromRequest(r)
+	room := mw.RoomFromRequest(r)
+	if fresh, err := t.Rooms.FindByID(room.ID.Hex()); err == nil {
+		room = fresh
+	}
+	s.publish(r, hub.RoomChannel(t.Instance.ID, room.ID.Hex()), name, room)
+}
+
+// membershipError writes error of tenant room and user operations with matching status
+func membershipError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, tenant.ErrUnknownUser):
+		status = http.StatusNotFound
+	case errors.Is(err, tenant.ErrBanned):
+		status = http.StatusForbidden
+	case errors.Is(err, tenant.ErrDMMembers), errors.Is(err, tenant.ErrArchivedMembers), errors.Is(err, tenant.ErrLegalHold):
+		status = http.StatusConflict
+	}
+	pkg.WriteError(w, r, status, err)
+}
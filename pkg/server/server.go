@@ -23,144 +23,396 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	stdlog "log"
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/cors"
 	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/neonxp/chatcloud/pkg/certs"
 	"github.com/neonxp/chatcloud/pkg/config"
-	"github.com/neonxp/chatcloud/pkg/manager"
+	"github.com/neonxp/chatcloud/pkg/filter"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/models"
+	"github.com/neonxp/chatcloud/pkg/notify"
+	"github.com/neonxp/chatcloud/pkg/openapi"
 	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+	"github.com/neonxp/chatcloud/pkg/unfurl"
 )
 
+// MethodSubscribe opens event stream for a resource
+const MethodSubscribe = "SUBSCRIBE"
+
+func init() {
+	chi.RegisterMethod(MethodSubscribe)
+}
+
 type Server struct {
-	db             *mongo.Database
-	cfg            *config.Config
-	rds            *redis.Client
-	serv           *http.Server
-	userManager    *manager.User
-	roomManager    *manager.Room
-	messageManager *manager.Message
+	db      *mongo.Database
+	cfg     *config.Config
+	rds     *redis.Client
+	log     *logrus.Logger
+	serv    *http.Server
+	hub     *hub.Hub
+	certs   *certs.Reloader
+	tenants *tenant.Registry
+	spec    *openapi.Document
+	notify  *notify.Queue
+	filters *filter.Chain
+	unfurl  *unfurl.Unfurler
+	unfurls chan struct{} // one slot per running unfurl job
+
+	// Background jobs are cancelled and awaited on shutdown
+	jobs       sync.WaitGroup
+	jobsCtx    context.Context
+	cancelJobs context.CancelFunc
 }
 
-func NewServer(db *mongo.Database, rds *redis.Client, cfg *config.Config) (*Server, error) {
-	roomManager, err := manager.NewRoom(db.Collection("rooms"))
-	if err != nil {
-		return nil, err
+func NewServer(db *mongo.Database, rds *redis.Client, tenants *tenant.Registry, cfg *config.Config, log *logrus.Logger) (*Server, error) {
+	var hook *filter.Hook
+	if cfg.FilterHookURL != "" {
+		hook = filter.NewHook(cfg.FilterHookURL, cfg.FilterHookTimeout, cfg.FilterHookFailure == "open", log.WithField("hook", "filter"))
+	}
+	var unfurler *unfurl.Unfurler
+	if cfg.UnfurlTimeout > 0 {
+		unfurler = unfurl.New(rds, cfg.UnfurlTimeout, cfg.UnfurlMaxSize, cfg.UnfurlCacheTTL)
 	}
-	userManager, err := manager.NewUser(db.Collection("users"))
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	return &Server{
+		db:         db,
+		cfg:        cfg,
+		rds:        rds,
+		log:        log,
+		serv:       nil,
+		hub:        hub.New(rds),
+		tenants:    tenants,
+		notify:     notify.NewQueue(rds, cfg.NotifyQueueLimit),
+		filters:    filter.NewChain(hook),
+		unfurl:     unfurler,
+		unfurls:    make(chan struct{}, cfg.UnfurlConcurrency),
+		jobsCtx:    jobsCtx,
+		cancelJobs: cancelJobs,
+	}, nil
+}
+
+func (s *Server) Init() error {
+	s.spec = newSpec()
+	api := s.router()
+	missing, err := s.spec.MissingRoutes(api)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("routes missing from openapi spec: %v", missing)
 	}
-	messageManager, err := manager.NewMessage(db.Collection("messages"), rds)
+
+	s.serv = &http.Server{
+		Addr:     s.cfg.Listen,
+		Handler:  api,
+		ErrorLog: stdlog.New(s.log.WriterLevel(logrus.ErrorLevel), "", 0),
+	}
+	if s.cfg.TLSCert == "" {
+		return nil
+	}
+	reloader, err := certs.NewReloader(s.cfg.TLSCert, s.cfg.TLSKey, s.log)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &Server{
-		db:             db,
-		cfg:            cfg,
-		rds:            rds,
-		serv:           nil,
-		userManager:    userManager,
-		roomManager:    roomManager,
-		messageManager: messageManager,
-	}, nil
+	s.certs = reloader
+	s.serv.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if s.cfg.TLSClientCA != "" {
+		pool, err := certs.LoadCertPool(s.cfg.TLSClientCA)
+		if err != nil {
+			return err
+		}
+		s.serv.TLSConfig.ClientCAs = pool
+		s.serv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return nil
 }
 
-func (s *Server) Init() {
+// router registers every route of the API, requests are validated against s.spec
+func (s *Server) router() *chi.Mux {
 	api := chi.NewRouter()
+	api.Use(cors.Handler(cors.Options{
+		AllowOriginFunc:  s.allowOrigin,
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, MethodSubscribe},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Request-Id"},
+		AllowCredentials: s.cfg.CORSCredentials,
+		MaxAge:           int(s.cfg.CORSMaxAge.Seconds()),
+	}))
 	api.Use(middleware.RequestID)
-	api.Use(middleware.RealIP)
-	api.Use(middleware.Logger)
 	api.Use(middleware.Recoverer)
+	api.Use(mw.Metrics)
+	api.Use(middleware.RealIP)
+	api.Use(mw.Logger(s.log))
+	if s.cfg.LegacyInstance != "" {
+		api.Use(mw.LegacyPaths(s.cfg.LegacyInstance))
+	}
 	api.Use(middleware.StripSlashes)
 	api.Get("/", s.notImplemented)
+	api.Get("/healthz", s.Healthz)
+	api.Get("/readyz", s.Readyz)
 	api.Route("/api", func(r chi.Router) {
-		// Users
-		r.Post("/batch_users", s.BatchCreateUsers)
-		r.Get("/users_by_ids", s.ListUsersByIds)
-		r.Route("/users", func(users chi.Router) {
-			users.Use(mw.User(s.userManager))
-			users.Get("/", s.ListUsers)
-			users.Post("/", s.CreateUser)
-			users.Get("/{user_id}", s.GetUser)
-			users.Get("/{user_id}/joined_rooms", s.notImplemented)
-			users.Get("/{user_id}/joinable_rooms", s.notImplemented)
-			users.Post("/{user_id}/join", s.notImplemented)
-			users.Post("/{user_id}/leave", s.notImplemented)
-			users.Put("/{user_id}", s.notImplemented)
-			users.Delete("/{user_id}", s.notImplemented)
-			users.Put("/{user_id}/roles", s.notImplemented)
-			users.Get("/{user_id}/roles", s.notImplemented)
-			users.Delete("/{user_id}/roles", s.notImplemented)
-			users.MethodFunc("SUBSCRIBE", "", s.notImplemented)
-			users.MethodFunc("SUBSCRIBE", "/{user_id}", s.notImplemented)
-			users.MethodFunc("SUBSCRIBE", "/{user_id}/register", s.notImplemented)
+		r.Get("/openapi.json", s.OpenAPI)
+		r.Route("/admin/instances", func(instances chi.Router) {
+			instances.Use(mw.AdminToken(s.cfg.AdminToken))
+			instances.Use(mw.Validate(s.spec))
+			instances.Get("/", s.ListInstances)
+			instances.Post("/", s.CreateInstance)
+			instances.Get("/{instance_id}", s.GetInstance)
+			instances.Delete("/{instance_id}", s.DeleteInstance)
+			instances.Put("/{instance_id}/retention", s.SetInstanceRetention)
+			instances.Delete("/{instance_id}/retention", s.DeleteInstanceRetention)
+			instances.Put("/{instance_id}/filters", s.SetInstanceFilters)
+			instances.Delete("/{instance_id}/filters", s.DeleteInstanceFilters)
 		})
+		r.Route("/v1/{instance_id}", func(r chi.Router) {
+			r.Use(mw.Tenant(s.tenants))
+			r.Use(mw.Auth())
+			r.Use(mw.Validate(s.spec))
+			// Users
+			r.Group(func(s2s chi.Router) {
+				// Server to server endpoints, guarded by client certificates when mTLS is configured
+				if s.cfg.TLSClientCA != "" {
+					s2s.Use(mw.ClientCert)
+				}
+				s2s.Post("/batch_users", s.BatchCreateUsers)
+			})
+			r.Get("/users_by_ids", s.ListUsersByIds)
+			r.Route("/users", func(users chi.Router) {
+				users.Get("/", s.ListUsers)
+				users.Post("/", s.CreateUser)
+				users.MethodFunc(MethodSubscribe, "/", s.notImplemented)
+				users.Route("/{user_id}", func(user chi.Router) {
+					user.Use(mw.User())
+					user.Get("/", s.GetUser)
+					user.With(mw.Self()).Get("/joined_rooms", s.ListJoinedRooms)
+					user.With(mw.Self()).Get("/joinable_rooms", s.ListJoinableRooms)
+					user.With(mw.Self()).Post("/join", s.JoinRoom)
+					user.With(mw.Self()).Post("/leave", s.LeaveRoom)
+					user.With(mw.Self()).Put("/", s.UpdateUser)
+					user.With(mw.SU()).Delete("/", s.DeleteUser)
+					user.With(mw.SU()).Put("/roles", s.AssignRole)
+					user.With(mw.SU()).Get("/roles", s.ListUserRoles)
+					user.With(mw.SU()).Delete("/roles", s.RemoveRole)
+					user.With(mw.Self()).MethodFunc(MethodSubscribe, "/", s.SubscribeUser)
+					user.MethodFunc(MethodSubscribe, "/register", s.notImplemented)
+					user.With(mw.Self()).Post("/dm", s.CreateGroupDM)
+					user.With(mw.Self()).Post("/dm/{other_id}", s.CreateDM)
+					user.With(mw.Self()).Get("/mentions", s.ListMentions)
+					user.With(mw.Self()).Get("/read_states", s.ListReadStates)
+					user.With(mw.SU()).Put("/legal_hold", s.HoldUser)
+					user.With(mw.SU()).Delete("/legal_hold", s.ReleaseUser)
+				})
+			})
 
-		// Rooms
-		r.Route("/rooms", func(rooms chi.Router) {
-			rooms.Post("/", s.notImplemented)
-			rooms.Get("/", s.notImplemented)
-			rooms.Get("/{room_id}", s.notImplemented)
-			rooms.Put("/{room_id}", s.notImplemented)
-			rooms.Delete("/{room_id}", s.notImplemented)
-			rooms.Put("/{room_id}/users/add", s.notImplemented)
-			rooms.Put("/{room_id}/users/remove", s.notImplemented)
-			rooms.Post("/{room_id}/typing_indicators", s.notImplemented)
-			rooms.Post("/{room_id}/attachments", s.notImplemented)
-			rooms.Get("/{room_id}/messages", s.notImplemented)
-			rooms.Post("/{room_id}/messages", s.notImplemented)
-			rooms.Get("/{room_id}/messages/{message_id}", s.notImplemented)
-			rooms.Put("/{room_id}/messages/{message_id}", s.notImplemented)
-			rooms.Delete("/{room_id}/messages/{message_id}", s.notImplemented)
-			rooms.Get("/{room_id}/files/{file_name}", s.notImplemented)
-			rooms.Delete("/{room_id}/files/{file_name}", s.notImplemented)
-			rooms.Post("/{room_id}/users/{user_id}/files/{file_name}", s.notImplemented)
-			rooms.Delete("/{room_id}/users/{user_id}/files", s.notImplemented)
-			rooms.MethodFunc("SUBSCRIBE", "/{room_id}", s.notImplemented)
-		})
+			// Rooms
+			r.Route("/rooms", func(rooms chi.Router) {
+				rooms.Post("/", s.CreateRoom)
+				rooms.Get("/", s.ListRooms)
+				rooms.Route("/{room_id}", func(room chi.Router) {
+					room.Use(mw.Room())
+					room.Get("/", s.GetRoom)
+					room.With(mw.SU()).Put("/", s.UpdateRoom)
+					room.With(mw.SU()).Delete("/", s.DeleteRoom)
+					room.With(mw.SU()).Put("/users/add", s.AddMembers)
+					room.With(mw.SU()).Put("/users/remove", s.RemoveMembers)
+					room.Post("/typing_indicators", s.notImplemented)
+					room.Post("/attachments", s.notImplemented)
+					room.With(mw.Member()).Get("/messages", s.ListMessages)
+					room.With(mw.Member(), mw.Writable()).Post("/messages", s.SendMessage)
+					room.With(mw.Member()).Get("/pins", s.ListPins)
+					room.Route("/messages/{message_id}", func(message chi.Router) {
+						message.Use(mw.Member())
+						message.Use(mw.Message())
+						message.Get("/", s.GetMessage)
+						message.With(mw.Writable()).Put("/", s.EditMessage)
+						message.With(mw.Writable()).Delete("/", s.DeleteMessage)
+						message.With(mw.Writable()).Put("/reactions/{reaction}", s.AddReaction)
+						message.With(mw.Writable()).Delete("/reactions/{reaction}", s.RemoveReaction)
+						message.Get("/replies", s.ListReplies)
+						message.Get("/cursor", s.GetThreadCursor)
+						message.Put("/cursor", s.SetThreadCursor)
+						message.With(mw.Permission(models.PermissionMessagePin), mw.Writable()).Put("/pin", s.PinMessage)
+						message.With(mw.Permission(models.PermissionMessagePin), mw.Writable()).Delete("/pin", s.UnpinMessage)
+						message.With(mw.Permission(models.PermissionRoomModerate)).Delete("/flags", s.ClearFlags)
+						message.Post("/report", s.ReportMessage)
+					})
+					room.Get("/files/{file_name}", s.notImplemented)
+					room.Delete("/files/{file_name}", s.notImplemented)
+					room.Post("/users/{user_id}/files/{file_name}", s.notImplemented)
+					room.Delete("/users/{user_id}/files", s.notImplemented)
+					room.With(mw.Member()).MethodFunc(MethodSubscribe, "/", s.SubscribeRoom)
+					room.With(mw.Permission(models.PermissionRoomArchive)).Put("/archive", s.ArchiveRoom)
+					room.With(mw.Permission(models.PermissionRoomArchive)).Delete("/archive", s.UnarchiveRoom)
+					room.Group(func(moderation chi.Router) {
+						moderation.Use(mw.Permission(models.PermissionRoomModerate))
+						moderation.Get("/bans", s.ListBans)
+						moderation.With(mw.User(), mw.Writable()).Put("/bans/{user_id}", s.BanUser)
+						moderation.Delete("/bans/{user_id}", s.UnbanUser)
+						moderation.Get("/mutes", s.ListMutes)
+						moderation.With(mw.User()).Put("/mutes/{user_id}", s.MuteUser)
+						moderation.Delete("/mutes/{user_id}", s.UnmuteUser)
+						moderation.With(mw.User(), mw.Writable()).Post("/users/{user_id}/kick", s.KickUser)
+						moderation.Get("/moderation_log", s.ListModerationLog)
+						moderation.Get("/flagged", s.ListFlagged)
+						moderation.Get("/reports", s.ListReports)
+						moderation.Post("/reports/{report_id}/resolve", s.ResolveReport)
+						moderation.Get("/filters", s.GetRoomFilters)
+						moderation.Put("/filters", s.SetRoomFilters)
+						moderation.Delete("/filters", s.DeleteRoomFilters)
+					})
+					room.Route("/exports", func(exports chi.Router) {
+						exports.Use(mw.SU())
+						exports.Post("/", s.CreateExport)
+						exports.Get("/{export_id}", s.GetExport)
+						exports.Get("/{export_id}/download", s.DownloadExport)
+					})
+					room.Group(func(retention chi.Router) {
+						retention.Use(mw.SU())
+						retention.Put("/retention", s.SetRoomRetention)
+						retention.Delete("/retention", s.DeleteRoomRetention)
+						retention.Put("/legal_hold", s.HoldRoom)
+						retention.Delete("/legal_hold", s.ReleaseRoom)
+					})
+				})
+			})
 
-		// Roles
-		r.Route("/roles", func(roles chi.Router) {
-			roles.Get("/", s.notImplemented)
-			roles.Post("/", s.notImplemented)
-			roles.Delete("/{role_name}/scope/{scope_type}", s.notImplemented)
-			roles.Get("/{role_name}/scope/{scope_name}/permissions", s.notImplemented)
-			roles.Put("/{role_name}/scope/{scope_name}/permissions", s.notImplemented)
-		})
+			// Roles
+			r.Route("/roles", func(roles chi.Router) {
+				roles.Use(mw.SU())
+				roles.Get("/", s.ListRoles)
+				roles.Post("/", s.CreateRole)
+				roles.Delete("/{role_name}/scope/{scope_name}", s.DeleteRole)
+				roles.Get("/{role_name}/scope/{scope_name}/permissions", s.GetRolePermissions)
+				roles.Put("/{role_name}/scope/{scope_name}/permissions", s.UpdateRolePermissions)
+			})
 
-		// Cursors
-		r.Route("/cursors", func(cursors chi.Router) {
-			cursors.Get("/0/rooms/{room_id}/users/{user_id}", s.notImplemented)
-			cursors.Put("/0/rooms/{room_id}/users/{user_id}", s.notImplemented)
-			cursors.Get("/0/rooms/{room_id}", s.notImplemented)
-			cursors.Get("/0/users/{user_id}", s.notImplemented)
-			cursors.MethodFunc("SUBSCRIBE", "/0/users/{user_id}", s.notImplemented)
-			cursors.MethodFunc("SUBSCRIBE", "/0/rooms/{room_id}", s.notImplemented)
-		})
+			// Cursors
+			r.Route("/cursors", func(cursors chi.Router) {
+				cursors.With(mw.Room(), mw.User(), mw.Self(), mw.Member()).Get("/0/rooms/{room_id}/users/{user_id}", s.GetReadCursor)
+				cursors.With(mw.Room(), mw.User(), mw.Self(), mw.Member()).Put("/0/rooms/{room_id}/users/{user_id}", s.SetReadCursor)
+				cursors.With(mw.Room(), mw.Member()).Get("/0/rooms/{room_id}", s.ListRoomCursors)
+				cursors.With(mw.User(), mw.Self()).Get("/0/users/{user_id}", s.ListUserCursors)
+				cursors.MethodFunc(MethodSubscribe, "/0/users/{user_id}", s.notImplemented)
+				cursors.MethodFunc(MethodSubscribe, "/0/rooms/{room_id}", s.notImplemented)
+			})
 
-		// Token
-		r.Post("/token", s.notImplemented)
-	})
+			// Retention
+			r.With(mw.SU()).Get("/retention/audit", s.ListRetentionAudit)
+			r.With(mw.SU()).Get("/reports", s.ListAllReports)
 
-	s.serv = &http.Server{
-		Addr:    s.cfg.Listen,
-		Handler: api,
-	}
+			// Token
+			r.Post("/token", s.Token)
+		})
+	})
+	return api
 }
 
 func (s *Server) Run(ctx context.Context) error {
-	if err := s.serv.ListenAndServe(); err != http.ErrServerClosed {
+	s.background(s.ensureIndexes)
+	var err error
+	if s.certs != nil {
+		go s.certs.Watch(ctx, s.cfg.TLSReload)
+		err = s.serv.ListenAndServeTLS("", "")
+	} else {
+		err = s.serv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		return err
 	}
 	return nil
 }
 
-func (s *Server) Close() error {
-	return s.serv.Close()
+// Shutdown asks subscribers to reconnect elsewhere, waits for in-flight requests until ctx deadline
+// and then cancels background jobs
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.hub.Shutdown(s.cfg.ReconnectAfter)
+	err := s.serv.Shutdown(ctx)
+	s.cancelJobs()
+	done := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.log.Warn("background jobs did not stop in time")
+	}
+	return err
+}
+
+// background runs job that outlives request, job must return when ctx is cancelled
+func (s *Server) background(job func(ctx context.Context)) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		job(s.jobsCtx)
+	}()
+}
+
+// ensureIndexes creates indexes added by upgrades on instances created before them.
+// Failure is logged and the instance keeps being served, its data may need cleanup before index can be built
+func (s *Server) ensureIndexes(ctx context.Context) {
+	instances, err := s.tenants.List()
+	if err != nil {
+		s.log.WithError(err).Error("can't list instances to ensure indexes")
+		return
+	}
+	for _, instance := range instances {
+		if ctx.Err() != nil {
+			return
+		}
+		// cached tenant may belong to instance deleted on another replica, creating indexes would bring its collections back
+		t, err := s.tenants.Load(instance.ID)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err == nil {
+			err = t.EnsureIndexes()
+		}
+		if err != nil {
+			s.log.WithError(err).WithField("instance", instance.ID).Error("can't ensure indexes")
+		}
+	}
+}
+
+// allowOrigin accepts origins configured globally or for the instance addressed by request path
+func (s *Server) allowOrigin(r *http.Request, origin string) bool {
+	for _, o := range s.cfg.CORSOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+	if path == r.URL.Path {
+		return false
+	}
+	t, err := s.tenants.Get(strings.SplitN(path, "/", 2)[0])
+	if err != nil {
+		return false
+	}
+	for _, o := range t.Instance.CORSOrigins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *Server) notImplemented(w http.ResponseWriter, r *http.Request) {
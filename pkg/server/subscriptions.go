@@ -0,0 +1,141 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/logger"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/tenant"
+)
+
+// SubscribeRoom streams room events to its members, stream of member ends once they are kicked or banned
+func (s *Server) SubscribeRoom(w http.ResponseWriter, r *http.Request) {
+	room := mw.RoomFromRequest(r)
+	var last func(event *hub.Event) bool
+	if claims := mw.ClaimsFromRequest(r); !claims.SU {
+		last = func(event *hub.Event) bool {
+			return event.Name == hub.EventMembersRemoved && removes(event, claims.Subject)
+		}
+	}
+	s.subscribe(w, r, "rooms", last, hub.RoomChannel(mw.TenantFromRequest(r).Instance.ID, room.ID.Hex()))
+}
+
+// removes reports whether members_removed event lists user, event data is decoded from JSON
+func removes(event *hub.Event, userID string) bool {
+	data, _ := event.Data.(map[string]interface{})
+	ids, _ := data["user_ids"].([]interface{})
+	for _, id := range ids {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscribeUser streams user events, user counts as online for @here mentions while stream is open
+func (s *Server) SubscribeUser(w http.ResponseWriter, r *http.Request) {
+	user := mw.UserFromRequest(r)
+	instanceID := mw.TenantFromRequest(r).Instance.ID
+	go s.heartbeat(r, instanceID, user.ID)
+	s.subscribe(w, r, "users", nil, hub.UserChannel(instanceID, user.ID))
+}
+
+// heartbeat refreshes presence of user until request ends
+func (s *Server) heartbeat(r *http.Request, instanceID string, userID string) {
+	ticker := time.NewTicker(hub.PresenceTTL / 2)
+	defer ticker.Stop()
+	for {
+		if err := s.hub.Touch(instanceID, userID); err != nil {
+			logger.FromRequest(r).WithError(err).Warn("can't refresh presence")
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// subscribe streams hub events as JSON lines until client goes away or server shuts down.
+// When last is given, stream also ends after the first event it accepts
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request, resource string, last func(event *hub.Event) bool, channels ...string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		pkg.WriteError(w, r, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	sub, err := s.hub.Subscribe(resource, channels...)
+	if err != nil {
+		if err == hub.ErrClosed {
+			pkg.WriteError(w, r, http.StatusServiceUnavailable, err)
+			return
+		}
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := enc.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+			if last != nil && last(event) {
+				return
+			}
+		}
+	}
+}
+
+// publish sends event to subscribers of channel. Change is already stored, so failure is only logged
+func (s *Server) publish(r *http.Request, channel string, name string, data interface{}) {
+	if err := s.hub.Publish(channel, name, data); err != nil {
+		logger.FromRequest(r).WithError(err).WithField("event", name).Warn("can't publish event")
+	}
+}
+
+// publisher binds publish to request for tenant operations that send events
+func (s *Server) publisher(r *http.Request) tenant.Publisher {
+	return func(channel string, name string, data interface{}) {
+		s.publish(r, channel, name, data)
+	}
+}
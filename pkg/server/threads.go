@@ -0,0 +1,109 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg"
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/models"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+)
+
+// ListReplies pages through thread of message with the same parameters as room history
+func (s *Server) ListReplies(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	initialID, _ := strconv.ParseInt(q.Get("initial_id"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	replies, err := mw.TenantFromRequest(r).Messages.Replies(mw.MessageFromRequest(r).ID, initialID, q.Get("direction") == "newer", limit)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, replies)
+}
+
+// GetThreadCursor returns read state of token user in thread, user that never read it has nil cursor
+func (s *Server) GetThreadCursor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	parent := mw.MessageFromRequest(r)
+	rs := &models.ThreadRS{ThreadID: parent.ID}
+	cursor, err := t.Cursors.FindThread(parent.ID, userID)
+	switch err {
+	case nil:
+		rs.Cursor = cursor
+	case mongo.ErrNoDocuments:
+	default:
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	var position int64
+	if rs.Cursor != nil {
+		position = rs.Cursor.Position
+	}
+	if rs.UnreadCount, err = t.Messages.CountReplies(parent.ID, position); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, rs)
+}
+
+// SetThreadCursor marks thread read up to position by token user
+func (s *Server) SetThreadCursor(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.CursorRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	userID, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	t := mw.TenantFromRequest(r)
+	parent := mw.MessageFromRequest(r)
+	cursor := &models.Cursor{
+		CursorType: models.CursorTypeRead,
+		Position:   req.Position,
+		RoomID:     parent.RoomID.Hex(),
+		UpdatedAt:  primitive.NewDateTimeFromTime(time.Now()),
+		UserID:     userID,
+		ThreadID:   parent.ID,
+	}
+	if err := t.Cursors.SetThread(cursor); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	s.publish(r, hub.UserChannel(t.Instance.ID, userID), hub.EventThreadCursor, cursor)
+	w.WriteHeader(http.StatusNoContent)
+}
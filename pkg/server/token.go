@@ -0,0 +1,63 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/neonxp/chatcloud/pkg"
+	mw "github.com/neonxp/chatcloud/pkg/server/middleware"
+	"github.com/neonxp/chatcloud/pkg/server/rest"
+	"github.com/neonxp/chatcloud/pkg/token"
+)
+
+// Token issues access token to holders of instance key, passed as basic auth key_id:secret
+func (s *Server) Token(w http.ResponseWriter, r *http.Request) {
+	instance := mw.TenantFromRequest(r).Instance
+	keyID, secret, ok := r.BasicAuth()
+	if !ok ||
+		subtle.ConstantTimeCompare([]byte(keyID), []byte(instance.KeyID)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(secret), []byte(instance.Secret)) != 1 {
+		pkg.WriteError(w, r, http.StatusUnauthorized, errors.New("invalid instance key"))
+		return
+	}
+	req := new(rest.TokenRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	signed, expiresAt, err := token.Issue(instance, req.UserID, req.SU, s.cfg.TokenTTL)
+	if err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	render.JSON(w, r, &rest.TokenResponse{
+		AccessToken: signed,
+		TokenType:   "bearer",
+		ExpiresIn:   int64(time.Until(expiresAt).Seconds()),
+	})
+}
@@ -37,17 +37,17 @@ import (
 func (s *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 	req := new(rest.UserRequest)
 	if err := render.Bind(r, req); err != nil {
-		pkg.WriteError(w, http.StatusBadRequest, err)
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
 		return
 	}
-	u, err := s.userManager.CreateUser(
+	u, err := mw.TenantFromRequest(r).Users.CreateUser(
 		req.ID,
 		req.Name,
 		req.AvatarURL,
 		req.CustomData,
 	)
 	if err != nil {
-		pkg.WriteError(w, http.StatusBadRequest, err)
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
 		return
 	}
 	render.JSON(w, r, u)
@@ -56,20 +56,20 @@ func (s *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 func (s *Server) BatchCreateUsers(w http.ResponseWriter, r *http.Request) {
 	req := new(rest.BatchUsersRequest)
 	if err := render.Bind(r, req); err != nil {
-		pkg.WriteError(w, http.StatusBadRequest, err)
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
 		return
 	}
 	var resp []*models.User
 	//TODO insert many
 	for _, request := range *req {
-		u, err := s.userManager.CreateUser(
+		u, err := mw.TenantFromRequest(r).Users.CreateUser(
 			request.ID,
 			request.Name,
 			request.AvatarURL,
 			request.CustomData,
 		)
 		if err != nil {
-			pkg.WriteError(w, http.StatusBadRequest, err)
+			pkg.WriteError(w, r, http.StatusBadRequest, err)
 			return
 		}
 		resp = append(resp, u)
@@ -83,6 +83,29 @@ func (s *Server) GetUser(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, user)
 }
 
+// UpdateUser changes fields present in request
+func (s *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	req := new(rest.UpdateUserRequest)
+	if err := render.Bind(r, req); err != nil {
+		pkg.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err := mw.TenantFromRequest(r).Users.Update(mw.UserFromRequest(r).ID, req.Name, req.AvatarURL, req.CustomData); err != nil {
+		pkg.WriteError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUser removes user with memberships and role assignments, messages are kept. Held users are refused
+func (s *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := mw.TenantFromRequest(r).DeleteUser(s.publisher(r), mw.UserFromRequest(r)); err != nil {
+		membershipError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (s *Server) ListUsers(w http.ResponseWriter, r *http.Request) {
 	fromTs := r.URL.Query().Get("from_ts")
 	limit := r.URL.Query().Get("limit")
@@ -91,19 +114,19 @@ func (s *Server) ListUsers(w http.ResponseWriter, r *http.Request) {
 	var err error
 	if fromTs != "" {
 		if iFromTs, err = time.Parse(time.RFC3339, fromTs); err != nil {
-			pkg.WriteError(w, http.StatusBadRequest, err)
+			pkg.WriteError(w, r, http.StatusBadRequest, err)
 			return
 		}
 	}
 	if limit != "" {
 		if iLimit, err = strconv.Atoi(limit); err != nil {
-			pkg.WriteError(w, http.StatusBadRequest, err)
+			pkg.WriteError(w, r, http.StatusBadRequest, err)
 			return
 		}
 	}
-	resp, err := s.userManager.Find(iFromTs, iLimit)
+	resp, err := mw.TenantFromRequest(r).Users.Find(iFromTs, iLimit)
 	if err != nil {
-		pkg.WriteError(w, http.StatusServiceUnavailable, err)
+		pkg.WriteError(w, r, http.StatusServiceUnavailable, err)
 		return
 	}
 	render.JSON(w, r, resp)
@@ -111,9 +134,9 @@ func (s *Server) ListUsers(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) ListUsersByIds(w http.ResponseWriter, r *http.Request) {
 	ids := r.URL.Query()["id"]
-	resp, err := s.userManager.FindByIDs(ids)
+	resp, err := mw.TenantFromRequest(r).Users.FindByIDs(ids)
 	if err != nil {
-		pkg.WriteError(w, http.StatusServiceUnavailable, err)
+		pkg.WriteError(w, r, http.StatusServiceUnavailable, err)
 		return
 	}
 	render.JSON(w, r, resp)
@@ -0,0 +1,390 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/db"
+	"github.com/neonxp/chatcloud/pkg/manager"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+const (
+	IsolationPrefix   = "prefix"
+	IsolationDatabase = "database"
+
+	// cacheTTL bounds how long replica may miss eviction sent while it was disconnected from redis
+	cacheTTL = time.Minute
+	// evictChannel carries ids of changed or deleted instances to every replica
+	evictChannel = "instances:evict"
+)
+
+// collections lists every per-instance collection, used when instance data is dropped
+var collections = []string{
+	"users", "rooms", "messages", "roles", "user_roles", "cursors", "thread_cursors",
+	"import_ids", "import_progress",
+	"exports", "exports.files", "exports.chunks",
+	"retention_audit",
+	"sanctions", "moderation_log", "reports",
+}
+
+// legacyCollections hold data of deployments older than instances, unprefixed in control database
+var legacyCollections = []string{"users", "rooms", "messages"}
+
+var (
+	ErrInvalidID = errors.New("instance id must be 1-32 lowercase letters, digits or dashes")
+	ErrExists    = errors.New("instance already exists")
+)
+
+var idRe = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// Tenant holds managers bound to data of one instance
+type Tenant struct {
+	Instance   *models.Instance
+	Users      *manager.User
+	Rooms      *manager.Room
+	Messages   *manager.Message
+	Roles      *manager.Role
+	Cursors    *manager.Cursor
+	Imports    *manager.Import
+	Exports    *manager.Export
+	Retention  *manager.Retention
+	Moderation *manager.Moderation
+	Reports    *manager.Report
+
+	namespace db.Namespace
+	loadedAt  time.Time
+}
+
+// EnsureIndexes creates indexes of every instance collection
+func (t *Tenant) EnsureIndexes() error {
+	if err := t.Users.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := t.Rooms.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := t.Messages.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := t.Roles.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := t.Cursors.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := t.Imports.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := t.Exports.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := t.Retention.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := t.Moderation.EnsureIndexes(); err != nil {
+		return err
+	}
+	return t.Reports.EnsureIndexes()
+}
+
+// Registry resolves instances to their isolated data and caches managers
+type Registry struct {
+	control   *mongo.Database
+	rds       *redis.Client
+	isolation string
+	instances *manager.Instance
+	mu        sync.Mutex
+	cache     map[string]*Tenant
+}
+
+func NewRegistry(control *mongo.Database, rds *redis.Client, isolation string) (*Registry, error) {
+	instances, err := manager.NewInstance(control.Collection("instances"))
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		control:   control,
+		rds:       rds,
+		isolation: isolation,
+		instances: instances,
+		cache:     map[string]*Tenant{},
+	}, nil
+}
+
+// Get returns tenant of instance, mongo.ErrNoDocuments if instance is unknown or being deleted
+func (r *Registry) Get(id string) (*Tenant, error) {
+	r.mu.Lock()
+	t, ok := r.cache[id]
+	r.mu.Unlock()
+	if ok && time.Since(t.loadedAt) < cacheTTL {
+		return t, nil
+	}
+	return r.Load(id)
+}
+
+// Load reads instance bypassing cache, used before work that must not touch data of deleted instance
+func (r *Registry) Load(id string) (*Tenant, error) {
+	instance, err := r.instances.FindByID(id)
+	if err == nil && instance.DeletedAt != 0 {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			r.drop(id)
+		}
+		return nil, err
+	}
+	t, err := r.open(instance)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.cache[id] = t
+	r.mu.Unlock()
+	return t, nil
+}
+
+// List returns instances except ones being deleted
+func (r *Registry) List() ([]*models.Instance, error) {
+	instances, err := r.instances.Find()
+	if err != nil {
+		return nil, err
+	}
+	live := instances[:0]
+	for _, i := range instances {
+		if i.DeletedAt == 0 {
+			live = append(live, i)
+		}
+	}
+	return live, nil
+}
+
+// Create registers instance, its data lives either in own database or in prefixed collections depending on isolation
+func (r *Registry) Create(id string, name string, corsOrigins []string) (*models.Instance, error) {
+	if id == "" {
+		var err error
+		if id, err = manager.NewID(); err != nil {
+			return nil, err
+		}
+	}
+	if !idRe.MatchString(id) {
+		return nil, ErrInvalidID
+	}
+	if _, err := r.instances.FindByID(id); err == nil {
+		return nil, ErrExists
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	var database, prefix string
+	if r.isolation == IsolationDatabase {
+		database = r.control.Name() + "_" + id
+	} else {
+		prefix = id + "_"
+	}
+	instance, err := r.instances.CreateInstance(id, name, database, prefix, corsOrigins)
+	if err != nil {
+		return nil, err
+	}
+	t, err := r.open(instance)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.EnsureIndexes(); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// AdoptLegacy registers instance id for data stored in control database before instances were introduced,
+// so the data stays reachable. It returns nil when there is no such data or it is already adopted
+func (r *Registry) AdoptLegacy(id string) (*models.Instance, error) {
+	if !idRe.MatchString(id) {
+		return nil, ErrInvalidID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	names, err := r.control.ListCollectionNames(ctx, bson.M{"name": bson.M{"$in": legacyCollections}})
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	if _, err := r.instances.FindLegacy(); err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if _, err := r.instances.FindByID(id); err == nil {
+		return nil, fmt.Errorf("can't adopt legacy data as instance %s: %w", id, ErrExists)
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return r.instances.CreateInstance(id, "Legacy", "", "", nil)
+}
+
+// Delete drops instance data and forgets instance. Instance is marked deleted and evicted on every replica
+// before its data is dropped, so requests served elsewhere don't recreate its collections.
+// Failed delete can be retried
+func (r *Registry) Delete(id string) error {
+	instance, err := r.instances.FindByID(id)
+	if err != nil {
+		return err
+	}
+	t, err := r.open(instance)
+	if err != nil {
+		return err
+	}
+	if err := r.instances.MarkDeleted(id); err != nil {
+		return err
+	}
+	r.evict(id)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := t.namespace.Drop(ctx, collections...); err != nil {
+		return err
+	}
+	return r.instances.Remove(id)
+}
+
+// SetRetention changes default retention policy of instance
+func (r *Registry) SetRetention(id string, retention *models.Retention) error {
+	if err := r.instances.SetRetention(id, retention); err != nil {
+		return err
+	}
+	r.evict(id)
+	return nil
+}
+
+// SetFilters changes message filter rules of instance
+func (r *Registry) SetFilters(id string, rules []models.FilterRule) error {
+	if err := r.instances.SetFilters(id, rules); err != nil {
+		return err
+	}
+	r.evict(id)
+	return nil
+}
+
+// Watch evicts instances changed on other replicas until ctx is done
+func (r *Registry) Watch(ctx context.Context) error {
+	ps := r.rds.Subscribe(evictChannel)
+	defer ps.Close()
+	messages := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			r.drop(msg.Payload)
+		}
+	}
+}
+
+// evict drops instance from cache of every replica. Publish failure is not reported,
+// replicas that miss the message reload instance after cacheTTL
+func (r *Registry) evict(id string) {
+	r.drop(id)
+	r.rds.Publish(evictChannel, id)
+}
+
+// drop forgets cached instance on this replica only
+func (r *Registry) drop(id string) {
+	r.mu.Lock()
+	delete(r.cache, id)
+	r.mu.Unlock()
+}
+
+func (r *Registry) open(instance *models.Instance) (*Tenant, error) {
+	database := r.control
+	if instance.Database != "" {
+		database = r.control.Client().Database(instance.Database)
+	}
+	ns := db.NewNamespace(database, instance.Prefix)
+	users, err := manager.NewUser(ns.Collection("users"))
+	if err != nil {
+		return nil, err
+	}
+	rooms, err := manager.NewRoom(ns.Collection("rooms"))
+	if err != nil {
+		return nil, err
+	}
+	messages, err := manager.NewMessage(ns.Collection("messages"), r.rds)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := manager.NewRole(ns.Collection("roles"), ns.Collection("user_roles"))
+	if err != nil {
+		return nil, err
+	}
+	cursors, err := manager.NewCursor(ns.Collection("cursors"), ns.Collection("thread_cursors"))
+	if err != nil {
+		return nil, err
+	}
+	imports, err := manager.NewImport(ns.Collection("import_ids"), ns.Collection("import_progress"))
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := ns.Bucket("exports")
+	if err != nil {
+		return nil, err
+	}
+	exports, err := manager.NewExport(ns.Collection("exports"), bucket)
+	if err != nil {
+		return nil, err
+	}
+	retention, err := manager.NewRetention(ns.Collection("retention_audit"))
+	if err != nil {
+		return nil, err
+	}
+	moderation, err := manager.NewModeration(ns.Collection("sanctions"), ns.Collection("moderation_log"))
+	if err != nil {
+		return nil, err
+	}
+	reports, err := manager.NewReport(ns.Collection("reports"))
+	if err != nil {
+		return nil, err
+	}
+	return &Tenant{
+		Instance:   instance,
+		Users:      users,
+		Rooms:      rooms,
+		Messages:   messages,
+		Roles:      roles,
+		Cursors:    cursors,
+		Imports:    imports,
+		Exports:    exports,
+		Retention:  retention,
+		Moderation: moderation,
+		Reports:    reports,
+		namespace:  ns,
+		loadedAt:   time.Now(),
+	}, nil
+}
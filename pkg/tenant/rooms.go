@@ -0,0 +1,232 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package tenant
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/neonxp/chatcloud/pkg/hub"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var (
+	// ErrDMMembers is returned for direct message rooms, they are identified by their member set
+	ErrDMMembers = errors.New("members of direct message room can't change")
+	// ErrArchivedMembers is returned for archived rooms, their memberships are frozen until restored
+	ErrArchivedMembers = errors.New("members of archived room can't change")
+	ErrBanned          = errors.New("user is banned from room")
+	ErrUnknownUser     = errors.New("user not found")
+	// ErrLegalHold is returned when deletion would drop data that must be kept
+	ErrLegalHold = errors.New("data is under legal hold")
+)
+
+// Publisher delivers event to subscribers of channel. Stored changes are not rolled back when delivery fails,
+// so publisher reports failures itself
+type Publisher func(channel string, name string, data interface{})
+
+// AddMembers adds existing users that are not banned from room and tells each of them about it.
+// Members of room are updated in place
+func (t *Tenant) AddMembers(publish Publisher, room *models.Room, userIDs []string) error {
+	if err := changeable(room); err != nil {
+		return err
+	}
+	if err := t.UsersExist(userIDs); err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		banned, err := t.Moderation.Banned(room.ID, id)
+		if err != nil {
+			return err
+		}
+		if banned {
+			return fmt.Errorf("%w: %s", ErrBanned, id)
+		}
+	}
+	if err := t.Rooms.AddMembers(room.ID, userIDs); err != nil {
+		return err
+	}
+	var added []string
+	for _, id := range userIDs {
+		if !contains(room.MemberUserIDs, id) && !contains(added, id) {
+			added = append(added, id)
+		}
+	}
+	room.MemberUserIDs = append(room.MemberUserIDs, added...)
+	for _, id := range added {
+		publish(hub.UserChannel(t.Instance.ID, id), hub.EventAddedToRoom, room)
+	}
+	return nil
+}
+
+// RemoveMembers removes users from room, tells them about it and ends their room streams.
+// Members of room are updated in place
+func (t *Tenant) RemoveMembers(publish Publisher, room *models.Room, userIDs []string) error {
+	if err := changeable(room); err != nil {
+		return err
+	}
+	if err := t.Rooms.RemoveMembers(room.ID, userIDs); err != nil {
+		return err
+	}
+	t.removed(publish, room, userIDs)
+	return nil
+}
+
+// DeleteRoom removes room with its messages, read and thread cursors, exports, moderation data, reports
+// and room scoped role assignments, then tells members they were removed. Rooms whose messages are held are refused
+func (t *Tenant) DeleteRoom(publish Publisher, room *models.Room) error {
+	held, err := t.Held(room)
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+	threadIDs, err := t.Messages.ThreadIDs(room.ID)
+	if err != nil {
+		return err
+	}
+	if err := t.Cursors.RemoveByRoom(room.ID.Hex(), threadIDs); err != nil {
+		return err
+	}
+	if err := t.Exports.RemoveByRoom(room.ID); err != nil {
+		return err
+	}
+	if _, err := t.Messages.RemoveByRooms([]primitive.ObjectID{room.ID}, nil); err != nil {
+		return err
+	}
+	if err := t.Roles.UnassignRooms([]string{room.ID.Hex()}); err != nil {
+		return err
+	}
+	if err := t.Moderation.RemoveByRooms([]primitive.ObjectID{room.ID}); err != nil {
+		return err
+	}
+	if err := t.Reports.RemoveByRooms([]primitive.ObjectID{room.ID}); err != nil {
+		return err
+	}
+	if err := t.Rooms.Remove(room.ID); err != nil {
+		return err
+	}
+	t.removed(publish, room, room.MemberUserIDs)
+	return nil
+}
+
+// Held reports whether messages of room must be kept, either room is under legal hold or it has messages of held users
+func (t *Tenant) Held(room *models.Room) (bool, error) {
+	if room.LegalHold {
+		return true, nil
+	}
+	heldIDs, err := t.Users.HeldIDs()
+	if err != nil || len(heldIDs) == 0 {
+		return false, err
+	}
+	n, err := t.Messages.CountByUsers(room.ID, heldIDs)
+	return n > 0, err
+}
+
+// DeleteUser removes user with memberships and role assignments, messages are kept.
+// Held users are refused since retention keeps their messages only while they exist
+func (t *Tenant) DeleteUser(publish Publisher, user *models.User) error {
+	if user.LegalHold {
+		return ErrLegalHold
+	}
+	rooms, err := t.Rooms.JoinedRooms(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := t.Rooms.RemoveUserEverywhere(user.ID); err != nil {
+		return err
+	}
+	if err := t.Roles.UnassignAll(user.ID); err != nil {
+		return err
+	}
+	if err := t.Users.Remove(user.ID); err != nil {
+		return err
+	}
+	for _, room := range rooms {
+		t.removed(publish, room, []string{user.ID})
+	}
+	return nil
+}
+
+// UsersExist returns ErrUnknownUser naming the first missing user
+func (t *Tenant) UsersExist(ids []string) error {
+	users, err := t.Users.FindByIDs(ids)
+	if err != nil {
+		return err
+	}
+	found := map[string]bool{}
+	for _, u := range users {
+		found[u.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return fmt.Errorf("%w: %s", ErrUnknownUser, id)
+		}
+	}
+	return nil
+}
+
+// removed drops members of room that are in userIDs, tells each of them they were removed and tells room,
+// room streams of removed users end on that event
+func (t *Tenant) removed(publish Publisher, room *models.Room, userIDs []string) {
+	var removed, kept []string
+	for _, id := range room.MemberUserIDs {
+		if contains(userIDs, id) {
+			removed = append(removed, id)
+		} else {
+			kept = append(kept, id)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	room.MemberUserIDs = kept
+	for _, id := range removed {
+		publish(hub.UserChannel(t.Instance.ID, id), hub.EventRemovedFromRoom, room)
+	}
+	publish(hub.RoomChannel(t.Instance.ID, room.ID.Hex()), hub.EventMembersRemoved, &models.Membership{
+		RoomID:  room.ID,
+		UserIds: removed,
+	})
+}
+
+func changeable(room *models.Room) error {
+	switch {
+	case room.DM:
+		return ErrDMMembers
+	case room.Archived():
+		return ErrArchivedMembers
+	}
+	return nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
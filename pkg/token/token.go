@@ -0,0 +1,80 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var ErrInvalid = errors.New("invalid token")
+
+// Claims of access token. Subject is user id, SU marks server tokens allowed to act on behalf of any user
+type Claims struct {
+	jwt.StandardClaims
+	Instance string `json:"instance"`
+	SU       bool   `json:"su,omitempty"`
+}
+
+// Issue signs access token with instance secret
+func Issue(instance *models.Instance, userID string, su bool, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    "api_keys/" + instance.KeyID,
+			Subject:   userID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+		Instance: instance.ID,
+		SU:       su,
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(instance.Secret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Parse verifies token signature, expiration and instance
+func Parse(instance *models.Instance, raw string) (*Claims, error) {
+	claims := new(Claims)
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(instance.Secret), nil
+	})
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	if claims.Instance != instance.ID {
+		return nil, ErrInvalid
+	}
+	return claims, nil
+}
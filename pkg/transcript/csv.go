@@ -0,0 +1,103 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package transcript
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var csvHeader = []string{
+	"id", "created_at", "updated_at", "user_id", "user_name",
+	"text", "urls", "attachments", "edited", "deleted", "deleted_at",
+	"redacted", "redacted_at", "hidden", "hidden_at",
+}
+
+// csvWriter writes one row per message, parts are flattened into text, urls and attachments columns
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) begin(room *models.Room) error {
+	return w.w.Write(csvHeader)
+}
+
+func (w *csvWriter) write(r *Record) error {
+	var text, urls, attachments []string
+	for _, p := range r.Parts {
+		switch {
+		case p.Attachment != nil:
+			attachments = append(attachments, fmt.Sprintf("%s (%s, %d bytes) %s", p.Attachment.Name, p.Type, p.Attachment.Size, p.Attachment.DownloadURL))
+		case p.URL != "":
+			urls = append(urls, p.URL)
+		default:
+			text = append(text, p.Content)
+		}
+	}
+	return w.w.Write([]string{
+		strconv.FormatInt(r.ID, 10),
+		r.CreatedAt.Format(time.RFC3339Nano),
+		r.UpdatedAt.Format(time.RFC3339Nano),
+		csvCell(r.UserID),
+		csvCell(r.UserName),
+		csvCell(strings.Join(text, "\n")),
+		csvCell(strings.Join(urls, " ")),
+		csvCell(strings.Join(attachments, "; ")),
+		strconv.FormatBool(r.Edited),
+		strconv.FormatBool(r.Deleted),
+		csvTime(r.DeletedAt),
+		strconv.FormatBool(r.Redacted),
+		csvTime(r.RedactedAt),
+		strconv.FormatBool(r.Hidden),
+		csvTime(r.HiddenAt),
+	})
+}
+
+// csvCell escapes user supplied value so spreadsheets don't evaluate it as formula
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func (w *csvWriter) end() error {
+	w.w.Flush()
+	return w.w.Error()
+}
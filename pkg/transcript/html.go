@@ -0,0 +1,102 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package transcript
+
+import (
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+// htmlWriter produces standalone page without external resources
+type htmlWriter struct {
+	w io.Writer
+}
+
+var htmlTemplates = template.Must(template.New("begin").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05 MST") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Transcript of {{.Room.Name}}</title>
+<style>
+body{font-family:sans-serif;max-width:860px;margin:2em auto;color:#222}
+header{border-bottom:1px solid #ccc;margin-bottom:1em}
+.message{padding:.5em 0;border-bottom:1px solid #eee}
+.meta{color:#666;font-size:.85em}
+.sender{font-weight:bold;color:#222}
+.text{white-space:pre-wrap;margin:.25em 0}
+.badge{font-size:.75em;border-radius:3px;padding:0 .4em;margin-left:.4em;background:#eee}
+.deleted{opacity:.6}
+.deleted .badge.del{background:#f5c6c6}
+.redacted,.hidden{opacity:.6}
+.badge.red{background:#e0e0e0}
+.badge.hid{background:#f5e3c6}
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Room.Name}}</h1>
+<p class="meta">Room {{.Room.ID.Hex}}{{if .Room.Private}}, private{{end}}. Generated {{time .Generated}}</p>
+</header>
+<main>
+`))
+
+func init() {
+	template.Must(htmlTemplates.New("message").Parse(`<article class="message{{if .Deleted}} deleted{{end}}{{if .Redacted}} redacted{{end}}{{if .Hidden}} hidden{{end}}" id="m{{.ID}}">
+<div class="meta"><span class="sender">{{if .UserName}}{{.UserName}}{{else}}{{.UserID}}{{end}}</span> ({{.UserID}}) &middot; {{time .CreatedAt}} &middot; #{{.ID}}
+{{- if .Edited}}<span class="badge" title="{{time .UpdatedAt}}">edited</span>{{end}}
+{{- if .Deleted}}<span class="badge del" title="{{time .DeletedAt}}">deleted</span>{{end}}
+{{- if .Redacted}}<span class="badge red" title="{{time .RedactedAt}}">redacted</span>{{end}}
+{{- if .Hidden}}<span class="badge hid" title="{{time .HiddenAt}}">hidden</span>{{end}}</div>
+{{range .Parts}}{{if .Attachment}}<div class="attachment">Attachment: <a href="{{.Attachment.DownloadURL}}">{{.Attachment.Name}}</a> ({{.Type}}, {{.Attachment.Size}} bytes)</div>
+{{else if .URL}}<div class="url"><a href="{{.URL}}">{{.URL}}</a> ({{.Type}})</div>
+{{else}}<div class="text">{{.Content}}</div>
+{{end}}{{end}}</article>
+`))
+	template.Must(htmlTemplates.New("end").Parse(`</main>
+</body>
+</html>
+`))
+}
+
+func newHTMLWriter(w io.Writer) *htmlWriter {
+	return &htmlWriter{w: w}
+}
+
+func (w *htmlWriter) begin(room *models.Room) error {
+	return htmlTemplates.ExecuteTemplate(w.w, "begin", map[string]interface{}{
+		"Room":      room,
+		"Generated": time.Now().UTC(),
+	})
+}
+
+func (w *htmlWriter) write(r *Record) error {
+	return htmlTemplates.ExecuteTemplate(w.w, "message", r)
+}
+
+func (w *htmlWriter) end() error {
+	return htmlTemplates.ExecuteTemplate(w.w, "end", nil)
+}
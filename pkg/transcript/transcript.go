@@ -0,0 +1,185 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package transcript
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/neonxp/chatcloud/pkg/manager"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+var ErrFormat = errors.New("format must be jsonl, csv or html")
+
+// Record is one message of transcript with sender name resolved
+type Record struct {
+	ID        int64      `json:"id"`
+	RoomID    string     `json:"room_id"`
+	UserID    string     `json:"user_id"`
+	UserName  string     `json:"user_name"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Edited    bool       `json:"edited"`
+	Deleted   bool       `json:"deleted"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Redacted messages had their parts removed by retention policy
+	Redacted   bool       `json:"redacted"`
+	RedactedAt *time.Time `json:"redacted_at,omitempty"`
+	// Hidden messages were hidden after reports and are still waiting for moderator review
+	Hidden   bool       `json:"hidden"`
+	HiddenAt *time.Time `json:"hidden_at,omitempty"`
+	Parts    []Part     `json:"parts"`
+}
+
+type Part struct {
+	Type       string      `json:"type"`
+	Content    string      `json:"content,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+type Attachment struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Size        int64           `json:"size"`
+	DownloadURL string          `json:"download_url,omitempty"`
+	CustomData  json.RawMessage `json:"custom_data,omitempty"`
+}
+
+type writer interface {
+	begin(room *models.Room) error
+	write(r *Record) error
+	end() error
+}
+
+// ContentType returns MIME type of format, empty for unknown formats
+func ContentType(format string) string {
+	switch format {
+	case models.ExportFormatJSONL:
+		return "application/x-ndjson"
+	case models.ExportFormatCSV:
+		return "text/csv; charset=utf-8"
+	case models.ExportFormatHTML:
+		return "text/html; charset=utf-8"
+	}
+	return ""
+}
+
+// Filename suggests file name for transcript of room
+func Filename(room *models.Room, format string) string {
+	return "room-" + room.ID.Hex() + "." + format
+}
+
+// Write streams full history of room to w and returns number of messages written
+func Write(ctx context.Context, users *manager.User, messages *manager.Message, room *models.Room, format string, w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var tw writer
+	switch format {
+	case models.ExportFormatJSONL:
+		tw = newJSONLWriter(bw)
+	case models.ExportFormatCSV:
+		tw = newCSVWriter(bw)
+	case models.ExportFormatHTML:
+		tw = newHTMLWriter(bw)
+	default:
+		return 0, ErrFormat
+	}
+	if err := tw.begin(room); err != nil {
+		return 0, err
+	}
+	names := map[string]string{}
+	var n int64
+	err := messages.ForEachInRoom(ctx, room.ID, func(msg *models.Message) error {
+		name, ok := names[msg.UserID]
+		if !ok {
+			u, err := users.FindByID(msg.UserID)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return err
+			}
+			if err == nil {
+				name = u.Name
+			}
+			names[msg.UserID] = name
+		}
+		n++
+		return tw.write(record(msg, name))
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := tw.end(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+func record(msg *models.Message, userName string) *Record {
+	r := &Record{
+		ID:        msg.ID,
+		RoomID:    msg.RoomID.Hex(),
+		UserID:    msg.UserID,
+		UserName:  userName,
+		CreatedAt: msg.CreatedAt.Time().UTC(),
+		UpdatedAt: msg.UpdatedAt.Time().UTC(),
+		Edited:    msg.Edited(),
+		Deleted:   msg.Deleted(),
+		Redacted:  msg.RedactedAt != 0,
+		Hidden:    msg.HiddenAt != 0,
+		Parts:     make([]Part, 0, len(msg.Parts)),
+	}
+	if r.Deleted {
+		r.DeletedAt = timeOf(msg.DeletedAt)
+	}
+	if r.Redacted {
+		r.RedactedAt = timeOf(msg.RedactedAt)
+	}
+	if r.Hidden {
+		r.HiddenAt = timeOf(msg.HiddenAt)
+	}
+	for _, p := range msg.Parts {
+		part := Part{Type: p.Type, Content: p.Content, URL: p.URL}
+		if !p.Attachment.ID.IsZero() {
+			part.Attachment = &Attachment{
+				ID:          p.Attachment.ID.Hex(),
+				Name:        p.Attachment.Name,
+				Size:        p.Attachment.Size,
+				DownloadURL: p.Attachment.DownloadURL,
+				CustomData:  p.Attachment.CustomData,
+			}
+		}
+		r.Parts = append(r.Parts, part)
+	}
+	return r
+}
+
+func timeOf(dt primitive.DateTime) *time.Time {
+	t := dt.Time().UTC()
+	return &t
+}
@@ -0,0 +1,55 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package unfurl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// errMiss is returned by cache for keys it doesn't have
+var errMiss = errors.New("not in cache")
+
+// cache keeps encoded previews, empty value remembers page without preview
+type cache interface {
+	get(key string) ([]byte, error)
+	// set failures are ignored, page is fetched again next time
+	set(key string, value []byte, ttl time.Duration)
+}
+
+type redisCache struct {
+	rds *redis.Client
+}
+
+func (c redisCache) get(key string) ([]byte, error) {
+	b, err := c.rds.Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, errMiss
+	}
+	return b, err
+}
+
+func (c redisCache) set(key string, value []byte, ttl time.Duration) {
+	c.rds.Set(key, value, ttl)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package unfurl
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+const (
+	titleLimit       = 300
+	descriptionLimit = 1000
+	urlLimit         = 2048
+)
+
+// parse reads meta tags of page head, OpenGraph properties win over Twitter card ones
+// and those win over title and description tags. Returns nil when page has neither title, description nor image
+func parse(r io.Reader, limit int64, base *url.URL) *models.LinkPreview {
+	meta := map[string]string{}
+	var title string
+	z := html.NewTokenizer(io.LimitReader(r, limit))
+	for done := false; !done; {
+		switch z.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				done = true
+			case "title":
+				if title == "" && z.Next() == html.TextToken {
+					title = string(z.Text())
+				}
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						key = strings.ToLower(strings.TrimSpace(string(v)))
+					case "content":
+						content = string(v)
+					}
+				}
+				if _, ok := meta[key]; key != "" && !ok {
+					meta[key] = content
+				}
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				done = true
+			}
+		}
+	}
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := clean(meta[k]); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	preview := &models.LinkPreview{
+		URL:         base.String(),
+		Title:       truncate(first("og:title", "twitter:title"), titleLimit),
+		Description: truncate(first("og:description", "twitter:description", "description"), descriptionLimit),
+		Image:       resolve(base, first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src")),
+		SiteName:    truncate(first("og:site_name"), titleLimit),
+		Type:        truncate(first("og:type"), titleLimit),
+	}
+	if canonical := resolve(base, first("og:url")); canonical != "" {
+		preview.URL = canonical
+	}
+	if preview.Title == "" {
+		preview.Title = truncate(clean(title), titleLimit)
+	}
+	if preview.Title == "" && preview.Description == "" && preview.Image == "" {
+		return nil
+	}
+	return preview
+}
+
+// clean collapses whitespace and drops invalid UTF-8, pages in other charsets may produce it
+func clean(s string) string {
+	return strings.Join(strings.Fields(strings.ToValidUTF8(s, "")), " ")
+}
+
+// truncate cuts s to limit runes
+func truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return string([]rune(s)[:limit-1]) + "…"
+}
+
+// resolve makes ref absolute against page URL, only http and https links are kept
+func resolve(base *url.URL, ref string) string {
+	if ref == "" || len(ref) > urlLimit {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
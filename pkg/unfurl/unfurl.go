@@ -0,0 +1,207 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package unfurl fetches link previews of URLs posted in messages
+package unfurl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/neonxp/chatcloud/pkg/metrics"
+	"github.com/neonxp/chatcloud/pkg/models"
+)
+
+const (
+	maxRedirects = 5
+	// failureTTL keeps pages without preview from being fetched on every message, shorter than cache TTL
+	// because failures may be transient
+	failureTTL = 10 * time.Minute
+	userAgent  = "chatcloud-unfurl/1.0 (link preview)"
+)
+
+var (
+	// ErrNoPreview is returned for pages without usable metadata and for failed lookups remembered in cache
+	ErrNoPreview = errors.New("page has no preview")
+	// ErrBlocked is returned for URLs pointing to loopback, private or otherwise internal addresses
+	ErrBlocked = errors.New("address is not allowed")
+)
+
+// Unfurler fetches pages over public network only and keeps previews in redis
+type Unfurler struct {
+	cache    cache
+	client   *http.Client
+	maxBytes int64
+	ttl      time.Duration
+	// exempt is host:port that passes network checks, tests point it at local server
+	exempt string
+}
+
+// New creates unfurler, timeout bounds whole fetch including redirects and maxBytes bounds part of page that is read
+func New(rds *redis.Client, timeout time.Duration, maxBytes int64, ttl time.Duration) *Unfurler {
+	u := &Unfurler{
+		cache:    redisCache{rds: rds},
+		maxBytes: maxBytes,
+		ttl:      ttl,
+	}
+	dialer := &net.Dialer{Timeout: timeout, Control: u.guard}
+	u.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// Proxy is not used, it would hide real address of page from guard
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return u.checkURL(req.URL)
+		},
+	}
+	return u
+}
+
+// Unfurl returns preview of page at rawURL from cache or fetches it, lookups without preview return ErrNoPreview
+func (u *Unfurler) Unfurl(ctx context.Context, rawURL string) (*models.LinkPreview, error) {
+	key := cacheKey(rawURL)
+	b, err := u.cache.get(key)
+	if err == nil {
+		metrics.Unfurls.WithLabelValues("cached").Inc()
+		if len(b) == 0 {
+			return nil, ErrNoPreview
+		}
+		preview := new(models.LinkPreview)
+		return preview, json.Unmarshal(b, preview)
+	}
+	// cache failure is not fatal, page is fetched without cache then
+	cacheable := err == errMiss
+	preview, err := u.fetch(ctx, rawURL)
+	switch {
+	case err == nil:
+		metrics.Unfurls.WithLabelValues("ok").Inc()
+	case err == ErrNoPreview:
+		metrics.Unfurls.WithLabelValues("empty").Inc()
+	case errors.Is(err, ErrBlocked):
+		metrics.Unfurls.WithLabelValues("blocked").Inc()
+	default:
+		metrics.Unfurls.WithLabelValues("failed").Inc()
+	}
+	if !cacheable || ctx.Err() != nil {
+		return preview, err
+	}
+	if err != nil {
+		u.cache.set(key, nil, failureTTL)
+		return nil, err
+	}
+	if b, err := json.Marshal(preview); err == nil {
+		u.cache.set(key, b, u.ttl)
+	}
+	return preview, nil
+}
+
+func (u *Unfurler) fetch(ctx context.Context, rawURL string) (*models.LinkPreview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.checkURL(target); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("page responded with status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNoPreview
+	}
+	preview := parse(resp.Body, u.maxBytes, resp.Request.URL)
+	if preview == nil {
+		return nil, ErrNoPreview
+	}
+	return preview, nil
+}
+
+// checkURL rejects schemes other than http and https and hosts that are IP literals of internal networks,
+// names are checked by guard once they are resolved
+func (u *Unfurler) checkURL(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", ErrBlocked, target.Scheme)
+	}
+	if target.Hostname() == "" {
+		return errors.New("url has no host")
+	}
+	if target.Host == u.exempt {
+		return nil
+	}
+	if ip := net.ParseIP(target.Hostname()); ip != nil && !Public(ip) {
+		return fmt.Errorf("%w: %s", ErrBlocked, ip)
+	}
+	return nil
+}
+
+// guard runs for every connection after name is resolved, so redirects and DNS rebinding can't reach internal addresses
+func (u *Unfurler) guard(network string, address string, _ syscall.RawConn) error {
+	if address == u.exempt {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !Public(ip) {
+		return fmt.Errorf("%w: %s", ErrBlocked, host)
+	}
+	return nil
+}
+
+func cacheKey(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return "unfurl:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,270 @@
+/*
+Copyright © 2020 Alexander Kiryukhin <a.kiryukhin@mail.ru>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPage = `<html><head><title>Title</title><meta property="og:description" content="Description"></head><body></body></html>`
+
+type memEntry struct {
+	value []byte
+	ttl   time.Duration
+}
+
+type memCache struct {
+	mu      sync.Mutex
+	entries map[string]memEntry
+}
+
+func (c *memCache) get(key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, errMiss
+	}
+	return e.value, nil
+}
+
+func (c *memCache) set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = memEntry{value: value, ttl: ttl}
+}
+
+// testUnfurler fetches pages of srv only, every other local address stays blocked
+func testUnfurler(srv *httptest.Server, timeout time.Duration, maxBytes int64) (*Unfurler, *memCache) {
+	c := &memCache{entries: map[string]memEntry{}}
+	u := New(nil, timeout, maxBytes, time.Hour)
+	u.cache = c
+	u.exempt = srv.Listener.Addr().String()
+	return u, c
+}
+
+func TestPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"2001:4860:4860::8888", true},
+		{"0.0.0.0", false},
+		{"10.1.2.3", false},
+		{"100.64.0.1", false},
+		{"127.0.0.1", false},
+		{"127.10.0.1", false},
+		{"169.254.169.254", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"fc00::1", false},
+		{"fd12:3456::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+	}
+	for _, tt := range tests {
+		if got := Public(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Public(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestUnfurlBlocksInternalURLs(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer srv.Close()
+	u := New(nil, time.Second, 1024, time.Hour)
+	u.cache = &memCache{entries: map[string]memEntry{}}
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	for _, rawURL := range []string{
+		srv.URL,
+		"http://localhost:" + port + "/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]:" + port + "/",
+		"http://10.0.0.1/",
+		"ftp://example.com/",
+		"file:///etc/passwd",
+	} {
+		if _, err := u.Unfurl(context.Background(), rawURL); !errors.Is(err, ErrBlocked) {
+			t.Errorf("%s: got %v, want ErrBlocked", rawURL, err)
+		}
+	}
+	if atomic.LoadInt32(&hits) != 0 {
+		t.Errorf("local server got %d requests", hits)
+	}
+}
+
+func TestUnfurlBlocksRedirectToInternal(t *testing.T) {
+	var internalHits int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&internalHits, 1)
+		fmt.Fprint(w, testPage)
+	}))
+	defer internal.Close()
+	_, internalPort, _ := net.SplitHostPort(internal.Listener.Addr().String())
+
+	targets := map[string]string{
+		"/loopback":  internal.URL + "/",
+		"/localhost": "http://localhost:" + internalPort + "/",
+		"/metadata":  "http://169.254.169.254/latest/meta-data/",
+		"/scheme":    "file:///etc/passwd",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, targets[r.URL.Path], http.StatusFound)
+	}))
+	defer srv.Close()
+	u, _ := testUnfurler(srv, time.Second, 1024)
+
+	for path := range targets {
+		if _, err := u.Unfurl(context.Background(), srv.URL+path); !errors.Is(err, ErrBlocked) {
+			t.Errorf("redirect to %s: got %v, want ErrBlocked", targets[path], err)
+		}
+	}
+	if atomic.LoadInt32(&internalHits) != 0 {
+		t.Errorf("internal server got %d requests", internalHits)
+	}
+}
+
+func TestUnfurlFollowsRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" {
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, testPage)
+	}))
+	defer srv.Close()
+	u, _ := testUnfurler(srv, time.Second, 1024)
+
+	preview, err := u.Unfurl(context.Background(), srv.URL+"/short")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if preview.URL != srv.URL+"/page" || preview.Title != "Title" || preview.Description != "Description" {
+		t.Errorf("got %+v", preview)
+	}
+}
+
+func TestUnfurlReadsAtMostMaxBytes(t *testing.T) {
+	padding := "<!--" + strings.Repeat("x", 4096) + "-->"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/late" {
+			fmt.Fprint(w, "<html><head>"+padding)
+		}
+		fmt.Fprint(w, testPage)
+	}))
+	defer srv.Close()
+	u, _ := testUnfurler(srv, time.Second, 1024)
+
+	if _, err := u.Unfurl(context.Background(), srv.URL+"/early"); err != nil {
+		t.Errorf("metadata before limit: unexpected error %v", err)
+	}
+	if _, err := u.Unfurl(context.Background(), srv.URL+"/late"); err != ErrNoPreview {
+		t.Errorf("metadata after limit: got %v, want ErrNoPreview", err)
+	}
+}
+
+func TestUnfurlTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	u, _ := testUnfurler(srv, 100*time.Millisecond, 1024)
+
+	start := time.Now()
+	_, err := u.Unfurl(context.Background(), srv.URL)
+	if err == nil || err == ErrNoPreview {
+		t.Fatalf("got %v, want timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("fetch took %s with 100ms timeout", elapsed)
+	}
+}
+
+func TestUnfurlCachesFailures(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, testPage)
+	}))
+	defer srv.Close()
+	u, c := testUnfurler(srv, time.Second, 1024)
+
+	if _, err := u.Unfurl(context.Background(), srv.URL+"/broken"); err == nil || err == ErrNoPreview {
+		t.Fatalf("first lookup: got %v, want fetch error", err)
+	}
+	if e := c.entries[cacheKey(srv.URL+"/broken")]; len(e.value) != 0 || e.ttl != failureTTL {
+		t.Errorf("failure cached as %q for %s, want empty value for %s", e.value, e.ttl, failureTTL)
+	}
+	if _, err := u.Unfurl(context.Background(), srv.URL+"/broken"); err != ErrNoPreview {
+		t.Errorf("second lookup: got %v, want ErrNoPreview", err)
+	}
+
+	if _, err := u.Unfurl(context.Background(), srv.URL+"/page"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e := c.entries[cacheKey(srv.URL+"/page")]; len(e.value) == 0 || e.ttl != u.ttl {
+		t.Errorf("preview cached as %q for %s, want preview for %s", e.value, e.ttl, u.ttl)
+	}
+	preview, err := u.Unfurl(context.Background(), srv.URL+"/page")
+	if err != nil || preview.Title != "Title" {
+		t.Errorf("cached lookup: got %+v, %v", preview, err)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("server got %d requests, want 2", hits)
+	}
+}
@@ -24,9 +24,15 @@ package pkg
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/neonxp/chatcloud/pkg/logger"
+	"github.com/neonxp/chatcloud/pkg/openapi"
 )
 
-func WriteError(w http.ResponseWriter, code int, err error) {
+func WriteError(w http.ResponseWriter, r *http.Request, code int, err error) {
+	if code >= http.StatusInternalServerError {
+		logger.FromRequest(r).WithError(err).WithField("status", code).Error("request failed")
+	}
 	e := struct {
 		Code    int    `json:"code"`
 		Message string `json:"message"`
@@ -37,3 +43,18 @@ func WriteError(w http.ResponseWriter, code int, err error) {
 	w.WriteHeader(code)
 	_ = json.NewEncoder(w).Encode(e)
 }
+
+// WriteValidationError responds 400 listing every invalid field
+func WriteValidationError(w http.ResponseWriter, r *http.Request, err *openapi.ValidationError) {
+	e := struct {
+		Code    int                  `json:"code"`
+		Message string               `json:"message"`
+		Errors  []openapi.FieldError `json:"errors"`
+	}{
+		Code:    http.StatusBadRequest,
+		Message: "request validation failed",
+		Errors:  err.Errors,
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(e)
+}